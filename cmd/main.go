@@ -51,6 +51,7 @@ func main() {
 		HeartbeatIntervalMax: HEARTBEAT,
 		ChannelMax:           5,
 		FrameMax:             131072,
+		DataDir:              dataDir,
 	}
 
 	b := broker.NewBroker(config)
@@ -68,13 +69,16 @@ func main() {
 		user := persistdb.UserCreateDTO{Username: config.Username, Password: config.Password, RoleID: 1}
 		persistdb.AddUser(user)
 		persistdb.CloseDB()
+	} else {
+		// create the tables added by newer versions
+		persistdb.InitDB()
 	}
 	persistdb.OpenDB()
 	user, err := persistdb.GetUserByUsername(config.Username)
 	if err != nil {
 		log.Fatalf("Failed to get user: %v", err)
 	}
-	if user.RoleID != 1 {
+	if !user.IsAdmin() {
 		log.Fatalf("User is not an admin")
 	}
 	persistdb.CloseDB()
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+)
+
+var (
+	version = "0.6.0-alpha"
+)
+
+const (
+	API_URL  = "http://localhost:3000"
+	USERNAME = "guest"
+	PASSWORD = "guest"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `ottermqctl %s
+
+Usage:
+  ottermqctl [flags] export-definitions [file]   write the broker definitions to file (default stdout)
+  ottermqctl [flags] import-definitions <file>   apply the definitions in file ("-" for stdin)
+
+Flags:
+`, version)
+	flag.PrintDefaults()
+}
+
+func main() {
+	apiURL := flag.String("url", API_URL, "management API address")
+	username := flag.String("username", USERNAME, "admin username")
+	password := flag.String("password", PASSWORD, "admin password")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	token, err := login(*apiURL, *username, *password)
+	if err != nil {
+		log.Fatalf("Failed to log in: %v", err)
+	}
+
+	switch flag.Arg(0) {
+	case "export-definitions":
+		body, err := request(http.MethodGet, *apiURL+"/api/definitions", token, nil)
+		if err != nil {
+			log.Fatalf("Failed to export definitions: %v", err)
+		}
+		if flag.NArg() < 2 || flag.Arg(1) == "-" {
+			os.Stdout.Write(body)
+			return
+		}
+		if err := os.WriteFile(flag.Arg(1), body, 0644); err != nil {
+			log.Fatalf("Failed to write definitions: %v", err)
+		}
+	case "import-definitions":
+		if flag.NArg() < 2 {
+			usage()
+			os.Exit(2)
+		}
+		var definitions []byte
+		if flag.Arg(1) == "-" {
+			definitions, err = io.ReadAll(os.Stdin)
+		} else {
+			definitions, err = os.ReadFile(flag.Arg(1))
+		}
+		if err != nil {
+			log.Fatalf("Failed to read definitions: %v", err)
+		}
+		if _, err := request(http.MethodPost, *apiURL+"/api/definitions", token, definitions); err != nil {
+			log.Fatalf("Failed to import definitions: %v", err)
+		}
+		log.Println("Definitions imported")
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+// login returns the JWT token of the admin user
+func login(apiURL, username, password string) (string, error) {
+	credentials, _ := json.Marshal(map[string]string{"username": username, "password": password})
+	body, err := request(http.MethodPost, apiURL+"/api/login", "", credentials)
+	if err != nil {
+		return "", err
+	}
+	var response struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", err
+	}
+	return response.Token, nil
+}
+
+// request calls the management API and returns the response body, or the
+// error it replied with
+func request(method, url, token string, payload []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return nil, fmt.Errorf("%s", apiErr.Error)
+		}
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return body, nil
+}
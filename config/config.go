@@ -8,4 +8,5 @@ type Config struct {
 	HeartbeatIntervalMax uint16
 	ChannelMax           uint16
 	FrameMax             uint32
+	DataDir              string // persistent messages are kept under it, when set
 }
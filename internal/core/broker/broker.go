@@ -2,20 +2,24 @@ package broker
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/andrelcunha/ottermq/config"
+	"github.com/andrelcunha/ottermq/internal/core"
 	"github.com/andrelcunha/ottermq/internal/core/vhost"
 	. "github.com/andrelcunha/ottermq/pkg/common"
 	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
 	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp/message"
 	"github.com/andrelcunha/ottermq/pkg/connection/constants"
 
+	"github.com/andrelcunha/ottermq/pkg/connection/constants/confirm"
 	"github.com/andrelcunha/ottermq/pkg/connection/constants/tx"
 	"github.com/andrelcunha/ottermq/pkg/connection/server"
 	"github.com/andrelcunha/ottermq/pkg/connection/shared"
@@ -36,6 +40,7 @@ type Broker struct {
 	config      *config.Config               `json:"-"`
 	Connections map[net.Conn]*ConnectionInfo `json:"-"`
 	mu          sync.Mutex                   `json:"-"`
+	store       *core.MessageStore           // nil when no data directory is configured
 }
 
 func NewBroker(config *config.Config) *Broker {
@@ -44,10 +49,27 @@ func NewBroker(config *config.Config) *Broker {
 		Connections: make(map[net.Conn]*ConnectionInfo),
 		config:      config,
 	}
-	b.VHosts["/"] = vhost.NewVhost("/")
+	if config.DataDir != "" {
+		store, err := core.OpenMessageStore(filepath.Join(config.DataDir, "messages"))
+		if err != nil {
+			log.Fatalf("Failed to open the message store: %v", err)
+		}
+		b.store = store
+	}
+	b.VHosts["/"] = b.newVHost("/")
 	return b
 }
 
+// newVHost creates a vhost keeping its persistent messages in the broker's
+// message store
+func (b *Broker) newVHost(name string) *vhost.VHost {
+	vh := vhost.NewVhost(name)
+	if b.store != nil {
+		vh.SetMessageStore(b.store)
+	}
+	return vh
+}
+
 func (b *Broker) Start() {
 	capabilities := map[string]interface{}{
 		"basic.nack":             true,
@@ -71,6 +93,26 @@ func (b *Broker) Start() {
 		"channelMax":        b.config.ChannelMax,
 	}
 
+	// the durable topology and messages are back before clients connect
+	if err := b.restoreVHosts(); err != nil {
+		log.Fatalf("Failed to restore the vhosts: %v", err)
+	}
+	b.mu.Lock()
+	vhosts := make([]*vhost.VHost, 0, len(b.VHosts))
+	for _, vh := range b.VHosts {
+		vhosts = append(vhosts, vh)
+	}
+	b.mu.Unlock()
+	for _, vh := range vhosts {
+		if err := vh.RestoreTopology(); err != nil {
+			log.Fatalf("Failed to restore the topology of vhost %s: %v", vh.Name, err)
+		}
+		b.loadPermissions(vh)
+		if err := vh.RecoverMessages(); err != nil {
+			log.Fatalf("Failed to recover the messages of vhost %s: %v", vh.Name, err)
+		}
+	}
+
 	addr := fmt.Sprintf("%s:%s", b.config.Host, b.config.Port)
 
 	listener, err := net.Listen("tcp", addr)
@@ -87,7 +129,15 @@ func (b *Broker) Start() {
 			continue
 		}
 		log.Println("New client waiting for connection: ", conn.RemoteAddr())
-		go b.handleConnection((&configurations), conn)
+		// a single writer per connection keeps the frames of the handlers
+		// and of the heartbeats from interleaving
+		conn = shared.NewFrameConn(conn, b.config.FrameMax)
+		// the handshake stores the connection's user and vhost in its own copy
+		connConfigurations := make(map[string]interface{}, len(configurations))
+		for key, value := range configurations {
+			connConfigurations[key] = value
+		}
+		go b.handleConnection(&connConfigurations, conn)
 	}
 }
 
@@ -98,7 +148,7 @@ func (b *Broker) handleConnection(configurations *map[string]interface{}, conn n
 	}()
 	channelNum := uint16(0)
 
-	if err := server.ServerHandshake(configurations, conn); err != nil {
+	if err := server.ServerHandshake(configurations, conn, b.checkVHostAccess); err != nil {
 		log.Printf("Handshake failed: %v", err)
 		return
 	}
@@ -110,6 +160,10 @@ func (b *Broker) handleConnection(configurations *map[string]interface{}, conn n
 	go b.sendHeartbeats(conn)
 	log.Println("Handshake successful")
 
+	// channels closed by the server on an exception: their frames are
+	// discarded until the client answers with channel.close-ok
+	closingChannels := make(map[uint16]bool)
+
 	// keep reading commands in loop
 	for {
 		frame, err := shared.ReadFrame(conn)
@@ -122,6 +176,14 @@ func (b *Broker) handleConnection(configurations *map[string]interface{}, conn n
 				log.Printf("Connection closed by client: %v", conn.RemoteAddr())
 				return
 			}
+			var amqpErr *amqp.Error
+			if errors.As(err, &amqpErr) {
+				// a malformed or oversized frame: the stream cannot be
+				// trusted anymore
+				log.Printf("Closing on exception: %v", amqpErr)
+				b.sendConnectionClose(conn, amqpErr, 0, 0)
+				return
+			}
 			log.Printf("Error reading frame: %v", err)
 			return
 		}
@@ -131,7 +193,8 @@ func (b *Broker) handleConnection(configurations *map[string]interface{}, conn n
 		//Process frame
 		newInterface, err := b.ParseFrame(configurations, conn, channelNum, frame)
 		if err != nil {
-			log.Fatalf("ERROR parsing frame: %v", err)
+			log.Printf("ERROR parsing frame: %v", err)
+			return
 		}
 		if newInterface != nil {
 			newState, ok := newInterface.(*amqp.ChannelState)
@@ -140,6 +203,17 @@ func (b *Broker) handleConnection(configurations *map[string]interface{}, conn n
 			}
 			fmt.Printf("[DEBUG] New State: %+v\n", newState)
 
+			frameChannel := binary.BigEndian.Uint16(frame[1:3])
+			if closingChannels[frameChannel] {
+				if request := newState.MethodFrame; request != nil &&
+					request.ClassID == uint16(constants.CHANNEL) &&
+					request.MethodID == uint16(constants.CHANNEL_CLOSE_OK) {
+					delete(closingChannels, frameChannel)
+				}
+				fmt.Printf("[DEBUG] Discarding frame on closing channel %d\n", frameChannel)
+				continue
+			}
+
 			if newState.MethodFrame != nil {
 				request := newState.MethodFrame
 				if channelNum != request.Channel {
@@ -155,11 +229,44 @@ func (b *Broker) handleConnection(configurations *map[string]interface{}, conn n
 				newState.MethodFrame = b.Connections[conn].Channels[channelNum].MethodFrame
 				fmt.Printf("[DEBUG] Request: %+v\n", newState.MethodFrame)
 			}
-			b.processRequest(conn, newState)
+			request := newState.MethodFrame
+			if b.connectionVHost(conn) == nil {
+				// the vhost was deleted under the connection
+				return
+			}
+			if _, err := b.processRequest(conn, newState); err != nil {
+				if !b.handleRequestError(conn, request, err, closingChannels) {
+					return
+				}
+			}
+			if request.ClassID == uint16(constants.CONNECTION) && request.MethodID == uint16(constants.CONNECTION_CLOSE) {
+				return
+			}
 		}
 	}
 }
 
+// handleRequestError replies AMQP exceptions to the peer. Soft errors close
+// the channel, hard errors close the connection. It returns false when the
+// connection must not be used anymore.
+func (b *Broker) handleRequestError(conn net.Conn, request *amqp.RequestMethodMessage, err error, closingChannels map[uint16]bool) bool {
+	var amqpErr *amqp.Error
+	if !errors.As(err, &amqpErr) {
+		// the client waits for a reply: an unexpected error closes the
+		// connection rather than leaving the request unanswered
+		amqpErr = amqp.NewError(constants.INTERNAL_ERROR, "%v", err)
+	}
+	log.Printf("Closing on exception: %v", amqpErr)
+	if amqpErr.ReplyCode.IsHardError() {
+		b.sendConnectionClose(conn, amqpErr, request.ClassID, request.MethodID)
+		return false
+	}
+	b.closeChannel(conn, request.Channel)
+	closingChannels[request.Channel] = true
+	b.sendChannelClose(conn, request.Channel, amqpErr, request.ClassID, request.MethodID)
+	return true
+}
+
 func (b *Broker) registerConnection(conn net.Conn, username, vhostName string, heartbeatInterval uint16) {
 	vhost := b.GetVHostFromName(vhostName)
 	if vhost == nil {
@@ -183,13 +290,52 @@ func (b *Broker) registerConnection(conn net.Conn, username, vhostName string, h
 	b.mu.Unlock()
 }
 
+// checkVHostAccess is called on connection.open: the vhost must exist and
+// the user must have access to it
+func (b *Broker) checkVHostAccess(username, vhostName string) error {
+	vh := b.GetVHostFromName(vhostName)
+	if vh == nil {
+		return amqp.NewError(constants.NOT_ALLOWED, "vhost '%s' not found", vhostName)
+	}
+	if !vh.HasUser(username) {
+		return amqp.NewError(constants.NOT_ALLOWED, "access to vhost '%s' refused for user '%s'", vhostName, username)
+	}
+	return nil
+}
+
+// connectionVHost returns the vhost the connection was opened on, or nil
+// once the vhost was deleted
+func (b *Broker) connectionVHost(conn net.Conn) *vhost.VHost {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	info, ok := b.Connections[conn]
+	if !ok {
+		return nil
+	}
+	return b.VHosts[info.VHostName]
+}
+
+// connectionUser returns the name of the user who opened the connection
+func (b *Broker) connectionUser(conn net.Conn) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if info, ok := b.Connections[conn]; ok {
+		return info.User
+	}
+	return ""
+}
+
 func (b *Broker) cleanupConnection(conn net.Conn) {
 	log.Println("Cleaning connection")
 	b.mu.Lock()
 	delete(b.Connections, conn)
+	vhosts := make([]*vhost.VHost, 0, len(b.VHosts))
+	for _, vh := range b.VHosts {
+		vhosts = append(vhosts, vh)
+	}
 	b.mu.Unlock()
-	for _, vhost := range b.VHosts {
-		vhost.CleanupConnection(conn)
+	for _, vh := range vhosts {
+		vh.CleanupConnection(conn)
 	}
 }
 
@@ -309,7 +455,7 @@ func (b *Broker) processRequest(conn net.Conn, newState *amqp.ChannelState) (int
 			return nil, nil
 		default:
 			log.Printf("[DEBUG] Unknown connection method: %d", request.MethodID)
-			return nil, fmt.Errorf("Unknown connection method: %d", request.MethodID)
+			return nil, amqp.NewError(constants.NOT_IMPLEMENTED, "unknown connection method %d", request.MethodID)
 		}
 
 	case uint16(constants.CHANNEL):
@@ -320,7 +466,7 @@ func (b *Broker) processRequest(conn net.Conn, newState *amqp.ChannelState) (int
 			// Check if the channel is already open
 			if b.checkChannel(conn, channelId) {
 				fmt.Printf("[DEBUG] Channel %d already open\n", channelId)
-				return nil, fmt.Errorf("Channel already open")
+				return nil, amqp.NewError(constants.CHANNEL_ERROR, "channel %d already open", channelId)
 			}
 			b.addChannel(conn, request)
 			fmt.Printf("[DEBUG] New state added: %+v\n", b.Connections[conn].Channels[request.Channel])
@@ -345,11 +491,11 @@ func (b *Broker) processRequest(conn net.Conn, newState *amqp.ChannelState) (int
 		case uint16(constants.CHANNEL_CLOSE):
 			channelId := request.Channel
 			// check if channel is open
-			if b.checkChannel(conn, channelId) {
-				fmt.Printf("[DEBUG] Channel %d already open\n", channelId)
-				return nil, fmt.Errorf("Channel already open")
+			if !b.checkChannel(conn, channelId) {
+				fmt.Printf("[DEBUG] Channel %d not open\n", channelId)
+				return nil, amqp.NewError(constants.CHANNEL_ERROR, "channel %d not open", channelId)
 			}
-			b.removeChannel(conn, channelId)
+			b.closeChannel(conn, channelId)
 			frame := amqp.ResponseMethodMessage{
 				Channel:  channelId,
 				ClassID:  uint16(constants.CHANNEL),
@@ -359,9 +505,13 @@ func (b *Broker) processRequest(conn net.Conn, newState *amqp.ChannelState) (int
 			shared.SendFrame(conn, frame)
 			return nil, nil
 
+		case uint16(constants.CHANNEL_CLOSE_OK):
+			// reply to a channel.close sent by the server: the channel is already gone
+			return nil, nil
+
 		default:
 			log.Printf("[DEBUG] Unknown channel method: %d", request.MethodID)
-			return nil, fmt.Errorf("Unknown channel method: %d", request.MethodID)
+			return nil, amqp.NewError(constants.NOT_IMPLEMENTED, "unknown channel method %d", request.MethodID)
 		}
 
 	case uint16(constants.EXCHANGE):
@@ -379,12 +529,25 @@ func (b *Broker) processRequest(conn net.Conn, newState *amqp.ChannelState) (int
 			typ := content.ExchangeType
 			exchangeName := content.ExchangeName
 
-			vh := b.VHosts["/"]
-
-			err := vh.CreateExchange(exchangeName, vhost.ExchangeType(typ))
+			if !content.Passive {
+				if err := b.checkPermission(conn, vhost.ConfigureAccess, "exchange", exchangeName); err != nil {
+					return nil, err
+				}
+			}
+			vh := b.connectionVHost(conn)
+
+			err := vh.DeclareExchange(exchangeName, vhost.ExchangeType(typ), content.Passive, vhost.ExchangeOptions{
+				Durable:    content.Durable,
+				AutoDelete: content.AutoDelete,
+				Internal:   content.Internal,
+				Arguments:  content.Arguments,
+			})
 			if err != nil {
 				return nil, err
 			}
+			if content.NoWait {
+				return nil, nil
+			}
 			frame := amqp.ResponseMethodMessage{
 				Channel:  channelId,
 				ClassID:  request.ClassID,
@@ -409,7 +572,10 @@ func (b *Broker) processRequest(conn net.Conn, newState *amqp.ChannelState) (int
 			// ifUnused := content.IfUnused
 			// noWait := content.NoWait
 
-			vh := b.VHosts["/"]
+			if err := b.checkPermission(conn, vhost.ConfigureAccess, "exchange", exchangeName); err != nil {
+				return nil, err
+			}
+			vh := b.connectionVHost(conn)
 			err := vh.DeleteExchange(exchangeName)
 			if err != nil {
 				return nil, err
@@ -426,7 +592,7 @@ func (b *Broker) processRequest(conn net.Conn, newState *amqp.ChannelState) (int
 			return nil, nil
 
 		default:
-			return nil, fmt.Errorf("unsupported command")
+			return nil, amqp.NewError(constants.NOT_IMPLEMENTED, "unsupported command")
 		}
 
 	case uint16(constants.QUEUE):
@@ -442,20 +608,35 @@ func (b *Broker) processRequest(conn net.Conn, newState *amqp.ChannelState) (int
 			fmt.Printf("[DEBUG] Content: %+v\n", content)
 			queueName := content.QueueName
 
-			vh := b.VHosts["/"]
+			vh := b.connectionVHost(conn)
 
-			queue, err := vh.CreateQueue(queueName)
-			if err != nil {
-				return nil, err
+			serverNamed := false
+			if !content.Passive {
+				// the permissions apply to the generated name of a
+				// server-named queue
+				if queueName == "" {
+					queueName = vh.NewQueueName()
+					serverNamed = true
+				}
+				if err := b.checkPermission(conn, vhost.ConfigureAccess, "queue", queueName); err != nil {
+					return nil, err
+				}
 			}
-
-			err = vh.BindToDefaultExchange(queueName)
+			queue, err := vh.DeclareQueue(conn, queueName, content.Passive, vhost.QueueOptions{
+				Durable:     content.Durable,
+				Exclusive:   content.Exclusive,
+				AutoDelete:  content.AutoDelete,
+				Arguments:   content.Arguments,
+				ServerNamed: serverNamed,
+			})
 			if err != nil {
-				fmt.Printf("[DEBUG] Error binding to default exchange: %v\n", err)
 				return nil, err
 			}
+			if content.NoWait {
+				return nil, nil
+			}
 			messageCount := uint32(queue.Len())
-			counsumerCount := uint32(0)
+			counsumerCount := uint32(vh.GetConsumerCount(queue))
 
 			frame := amqp.ResponseMethodMessage{
 				Channel:  channelId,
@@ -465,7 +646,7 @@ func (b *Broker) processRequest(conn net.Conn, newState *amqp.ChannelState) (int
 					KeyValuePairs: []amqp.KeyValue{
 						{
 							Key:   amqp.STRING_SHORT,
-							Value: queueName,
+							Value: queue.Name,
 						},
 						{
 							Key:   amqp.INT_LONG,
@@ -490,17 +671,25 @@ func (b *Broker) processRequest(conn net.Conn, newState *amqp.ChannelState) (int
 				return nil, fmt.Errorf("Invalid content type for ExchangeDeclareMessage")
 			}
 			fmt.Printf("[DEBUG] Content: %+v\n", content)
-			vh := b.VHosts["/"]
+			vh := b.connectionVHost(conn)
 			queue := content.Queue
 			exchange := content.Exchange
 			routingKey := content.RoutingKey
-			// noWait := content.NoWait
 
-			err := vh.BindQueue(exchange, queue, routingKey)
+			if err := b.checkPermission(conn, vhost.WriteAccess, "queue", queue); err != nil {
+				return nil, err
+			}
+			if err := b.checkPermission(conn, vhost.ReadAccess, "exchange", exchange); err != nil {
+				return nil, err
+			}
+			err := vh.BindQueue(b.connectionUser(conn), exchange, queue, routingKey, content.Arguments)
 			if err != nil {
 				fmt.Printf("[DEBUG] Error binding to default exchange: %v\n", err)
 				return nil, err
 			}
+			if content.NoWait {
+				return nil, nil
+			}
 			frame := amqp.ResponseMethodMessage{
 				Channel:  channelId,
 				ClassID:  request.ClassID,
@@ -511,73 +700,273 @@ func (b *Broker) processRequest(conn net.Conn, newState *amqp.ChannelState) (int
 			return nil, nil
 
 		case uint16(constants.QUEUE_DELETE):
-			// if len(parts) != 2 {
-			// 	return common.CommandResponse{Status: "ERROR", Message: "Invalid command"}, nil
-			// }
-			// queueName := parts[1]
-			// err := b.deleteQueue(queueName)
-			// if err != nil {
-			// 	return common.CommandResponse{Status: "ERROR", Message: err.Error()}, nil
-			// }
-			// return common.CommandResponse{Status: "OK", Message: fmt.Sprintf("Queue %s deleted", queueName)}, nil
+			fmt.Printf("[DEBUG] Received queue.delete request: %+v\n", request)
+			content, ok := request.Content.(*message.QueueDeleteMessage)
+			if !ok {
+				return nil, fmt.Errorf("Invalid content type for QueueDeleteMessage")
+			}
+			if err := b.checkPermission(conn, vhost.ConfigureAccess, "queue", content.QueueName); err != nil {
+				return nil, err
+			}
+			vh := b.connectionVHost(conn)
+			messageCount, err := vh.DeleteQueue(conn, content.QueueName, content.IfUnused, content.IfEmpty)
+			if err != nil {
+				return nil, err
+			}
+			if content.NoWait {
+				return nil, nil
+			}
+			frame := amqp.ResponseMethodMessage{
+				Channel:  request.Channel,
+				ClassID:  request.ClassID,
+				MethodID: uint16(constants.QUEUE_DELETE_OK),
+				Content: amqp.ContentList{
+					KeyValuePairs: []amqp.KeyValue{
+						{
+							Key:   amqp.INT_LONG,
+							Value: messageCount,
+						},
+					},
+				},
+			}.FormatMethodFrame()
+			shared.SendFrame(conn, frame)
+			return nil, nil
+
+		case uint16(constants.QUEUE_PURGE):
+			fmt.Printf("[DEBUG] Received queue.purge request: %+v\n", request)
+			content, ok := request.Content.(*message.QueuePurgeMessage)
+			if !ok {
+				return nil, fmt.Errorf("Invalid content type for QueuePurgeMessage")
+			}
+			if err := b.checkPermission(conn, vhost.ReadAccess, "queue", content.QueueName); err != nil {
+				return nil, err
+			}
+			vh := b.connectionVHost(conn)
+			messageCount, err := vh.PurgeQueue(conn, content.QueueName)
+			if err != nil {
+				return nil, err
+			}
+			if content.NoWait {
+				return nil, nil
+			}
+			frame := amqp.ResponseMethodMessage{
+				Channel:  request.Channel,
+				ClassID:  request.ClassID,
+				MethodID: uint16(constants.QUEUE_PURGE_OK),
+				Content: amqp.ContentList{
+					KeyValuePairs: []amqp.KeyValue{
+						{
+							Key:   amqp.INT_LONG,
+							Value: messageCount,
+						},
+					},
+				},
+			}.FormatMethodFrame()
+			shared.SendFrame(conn, frame)
+			return nil, nil
 
 		case uint16(constants.QUEUE_UNBIND):
-			// if len(parts) != 4 {
-			// 	return common.CommandResponse{Status: "ERROR", Message: "Invalid command"}, nil
-			// }
-			// exchangeName := parts[1]
-			// queueName := parts[2]
-			// routingKey := parts[3]
-			// b.DeletBinding(exchangeName, queueName, routingKey)
-			// return common.CommandResponse{Status: "OK", Message: fmt.Sprintf("Binding deleted")}, nil
+			fmt.Printf("[DEBUG] Received queue.unbind request: %+v\n", request)
+			content, ok := request.Content.(*message.QueueUnbindMessage)
+			if !ok {
+				return nil, fmt.Errorf("Invalid content type for QueueUnbindMessage")
+			}
+			if err := b.checkPermission(conn, vhost.WriteAccess, "queue", content.Queue); err != nil {
+				return nil, err
+			}
+			if err := b.checkPermission(conn, vhost.ReadAccess, "exchange", content.Exchange); err != nil {
+				return nil, err
+			}
+			vh := b.connectionVHost(conn)
+			err := vh.DeletBinding(content.Exchange, content.Queue, content.RoutingKey, content.Arguments)
+			if err != nil {
+				return nil, err
+			}
+			frame := amqp.ResponseMethodMessage{
+				Channel:  request.Channel,
+				ClassID:  request.ClassID,
+				MethodID: uint16(constants.QUEUE_UNBIND_OK),
+				Content:  amqp.ContentList{},
+			}.FormatMethodFrame()
+			shared.SendFrame(conn, frame)
+			return nil, nil
 		default:
-			return nil, fmt.Errorf("unsupported command")
+			return nil, amqp.NewError(constants.NOT_IMPLEMENTED, "unsupported command")
 		}
 	case uint16(constants.BASIC):
 		switch request.MethodID {
 		case uint16(constants.BASIC_QOS):
+			fmt.Printf("[DEBUG] Received basic.qos request: %+v\n", request)
+			content, ok := request.Content.(*message.BasicQosMessage)
+			if !ok {
+				return nil, fmt.Errorf("Invalid content type for BasicQosMessage")
+			}
+			if content.PrefetchSize != 0 {
+				return nil, amqp.NewError(constants.NOT_IMPLEMENTED, "prefetch_size!=0 (%d)", content.PrefetchSize)
+			}
+			b.connectionVHost(conn).SetQos(conn, request.Channel, content.PrefetchCount, content.Global)
+			frame := amqp.ResponseMethodMessage{
+				Channel:  request.Channel,
+				ClassID:  request.ClassID,
+				MethodID: uint16(constants.BASIC_QOS_OK),
+				Content:  amqp.ContentList{},
+			}.FormatMethodFrame()
+			shared.SendFrame(conn, frame)
+			return nil, nil
+
 		case uint16(constants.BASIC_CONSUME):
+			fmt.Printf("[DEBUG] Received basic.consume request: %+v\n", request)
+			channelId := request.Channel
+			content, ok := request.Content.(*message.BasicConsumeMessage)
+			if !ok {
+				return nil, fmt.Errorf("Invalid content type for BasicConsumeMessage")
+			}
+			if err := b.checkPermission(conn, vhost.ReadAccess, "queue", content.Queue); err != nil {
+				return nil, err
+			}
+			vh := b.connectionVHost(conn)
+			consumer, err := vh.RegisterConsumer(conn, channelId, content.Queue, content.ConsumerTag, content.NoAck, content.Exclusive)
+			if err != nil {
+				return nil, err
+			}
+			if !content.NoWait {
+				frame := amqp.ResponseMethodMessage{
+					Channel:  channelId,
+					ClassID:  request.ClassID,
+					MethodID: uint16(constants.BASIC_CONSUME_OK),
+					Content: amqp.ContentList{
+						KeyValuePairs: []amqp.KeyValue{
+							{
+								Key:   amqp.STRING_SHORT,
+								Value: consumer.ID,
+							},
+						},
+					},
+				}.FormatMethodFrame()
+				shared.SendFrame(conn, frame)
+			}
+			vh.StartConsumer(consumer)
+			return nil, nil
+
 		case uint16(constants.BASIC_CANCEL):
+			fmt.Printf("[DEBUG] Received basic.cancel request: %+v\n", request)
+			channelId := request.Channel
+			content, ok := request.Content.(*message.BasicCancelMessage)
+			if !ok {
+				return nil, fmt.Errorf("Invalid content type for BasicCancelMessage")
+			}
+			vh := b.connectionVHost(conn)
+			// cancelling an unknown consumer is not an error
+			if err := vh.CancelConsumer(conn, channelId, content.ConsumerTag); err != nil {
+				fmt.Printf("[DEBUG] %v\n", err)
+			}
+			if !content.NoWait {
+				frame := amqp.ResponseMethodMessage{
+					Channel:  channelId,
+					ClassID:  request.ClassID,
+					MethodID: uint16(constants.BASIC_CANCEL_OK),
+					Content: amqp.ContentList{
+						KeyValuePairs: []amqp.KeyValue{
+							{
+								Key:   amqp.STRING_SHORT,
+								Value: content.ConsumerTag,
+							},
+						},
+					},
+				}.FormatMethodFrame()
+				shared.SendFrame(conn, frame)
+			}
+			return nil, nil
+
 		case uint16(constants.BASIC_PUBLISH):
 			channel := request.Channel
 			currentState := b.getCurrentState(conn, channel)
 			if currentState == nil {
-				return nil, fmt.Errorf("Channel not found")
+				return nil, amqp.NewError(constants.CHANNEL_ERROR, "channel %d not open", request.Channel)
 			}
+			// a new method frame starts a new publish request: the content
+			// header and body frames follow
 			if currentState.MethodFrame != newState.MethodFrame {
-				b.Connections[conn].Channels[channel].MethodFrame = newState.MethodFrame
-				fmt.Printf("[DEBUG] Current state after update method : %+v\n", b.getCurrentState(conn, channel))
+				currentState.MethodFrame = newState.MethodFrame
+				currentState.HeaderFrame = nil
+				currentState.Body = nil
+				currentState.BodySize = 0
+				publishRequest := newState.MethodFrame.Content.(*message.BasicPublishMessage)
+				if err := b.checkPermission(conn, vhost.WriteAccess, "exchange", publishRequest.Exchange); err != nil {
+					return nil, err
+				}
+				fmt.Printf("[DEBUG] Current state after update method : %+v\n", currentState)
 				return nil, nil
 			}
-			// if the class and method are not the same as the current state,
-			// it means that it stated the new publish request
-			if currentState.HeaderFrame == nil && newState.HeaderFrame != nil {
-				b.Connections[conn].Channels[channel].HeaderFrame = newState.HeaderFrame
-				b.Connections[conn].Channels[channel].BodySize = newState.HeaderFrame.BodySize
-				fmt.Printf("[DEBUG] Current state after update header: %+v\n", b.getCurrentState(conn, channel))
+			if newState.HeaderFrame != nil {
+				currentState.HeaderFrame = newState.HeaderFrame
+				currentState.BodySize = newState.HeaderFrame.BodySize
+				fmt.Printf("[DEBUG] Current state after update header: %+v\n", currentState)
+			}
+			if newState.Body != nil {
+				currentState.Body = append(currentState.Body, newState.Body...)
+			}
+			if currentState.HeaderFrame == nil || uint64(len(currentState.Body)) < currentState.BodySize {
+				// wait for the remaining body frames
 				return nil, nil
 			}
-			if currentState.Body == nil && newState.Body != nil {
-				b.Connections[conn].Channels[channel].Body = newState.Body
+			fmt.Printf("[DEBUG] All fields shall be filled -> current state: %+v\n", currentState)
+			if len(currentState.Body) != int(currentState.BodySize) {
+				fmt.Printf("[DEBUG] Body size is not correct: %d != %d\n", len(currentState.Body), currentState.BodySize)
+				return nil, fmt.Errorf("Body size is not correct: %d != %d\n", len(currentState.Body), currentState.BodySize)
 			}
-			fmt.Printf("[DEBUG] Current state after all: %+v\n", currentState)
-			if currentState.MethodFrame.Content != nil && currentState.HeaderFrame != nil && currentState.BodySize > 0 && currentState.Body != nil {
-				fmt.Printf("[DEBUG] All fields shall be filled -> current state: %+v\n", currentState)
-				if len(currentState.Body) != int(currentState.BodySize) {
-					fmt.Printf("[DEBUG] Body size is not correct: %d != %d\n", len(currentState.Body), currentState.BodySize)
-					return nil, fmt.Errorf("Body size is not correct: %d != %d\n", len(currentState.Body), currentState.BodySize)
+			publishRequest := currentState.MethodFrame.Content.(*message.BasicPublishMessage)
+			exchanege := publishRequest.Exchange
+			routingKey := publishRequest.RoutingKey
+			body := currentState.Body
+			props := currentState.HeaderFrame.Properties
+			currentState.HeaderFrame = nil
+			currentState.Body = nil
+			currentState.BodySize = 0
+			v := b.connectionVHost(conn)
+			if currentState.TxMode {
+				// the publishes are routed on commit: the topic permissions
+				// are checked now
+				if err := v.CheckTopicPermission(b.connectionUser(conn), vhost.WriteAccess, exchanege, routingKey); err != nil {
+					return nil, err
 				}
-				publishRequest := currentState.MethodFrame.Content.(*message.BasicPublishMessage)
-				exchanege := publishRequest.Exchange
-				routingKey := publishRequest.RoutingKey
-				body := currentState.Body
-				props := currentState.HeaderFrame.Properties
-				v := b.VHosts["/"]
-				v.Publish(exchanege, routingKey, body, props)
+				if err := vhost.CheckExpiration(props.Expiration); err != nil {
+					return nil, err
+				}
+				msg := amqp.Message{
+					Body:       body,
+					Properties: *props,
+					Exchange:   exchanege,
+					RoutingKey: routingKey,
+				}
+				currentState.TxPublishes = append(currentState.TxPublishes, msg)
+				return nil, nil
+			}
+			if currentState.ConfirmMode {
+				currentState.PublishSeq++
+			}
+			_, err := v.Publish(b.connectionUser(conn), exchanege, routingKey, body, props)
+			var amqpErr *amqp.Error
+			if errors.As(err, &amqpErr) {
+				// the channel is closed without confirming
+				return nil, err
+			}
+			if err != nil {
+				// the message was not taken: nacked in confirm mode, dropped
+				// otherwise
+				log.Printf("Message not published: %v", err)
 			}
+			if currentState.ConfirmMode {
+				b.sendPublisherConfirm(conn, channel, currentState.PublishSeq, err == nil)
+			}
+			return nil, nil
 		case uint16(constants.BASIC_GET):
-			vhost := b.VHosts["/"]
-			queue := request.Content.(*message.BasicGetMessage).Queue
+			content := request.Content.(*message.BasicGetMessage)
+			if err := b.checkPermission(conn, vhost.ReadAccess, "queue", content.Queue); err != nil {
+				return nil, err
+			}
+			vhost := b.connectionVHost(conn)
+			queue := content.Queue
 			channelId := request.Channel
 			messageCount, err := vhost.GetMessageCount(queue)
 			if err != nil {
@@ -586,26 +975,24 @@ func (b *Broker) processRequest(conn net.Conn, newState *amqp.ChannelState) (int
 			}
 
 			if messageCount == 0 {
-				reserved1 := amqp.KeyValue{
-					Key:   amqp.INT_LONG,
-					Value: uint32(0),
-				}
-				frame := amqp.ResponseMethodMessage{
-					Channel:  channelId,
-					ClassID:  request.ClassID,
-					MethodID: uint16(constants.BASIC_GET_EMPTY),
-					Content:  amqp.ContentList{KeyValuePairs: []amqp.KeyValue{reserved1}},
-				}.FormatMethodFrame()
-				shared.SendFrame(conn, frame)
-				return nil, nil
+				return nil, b.sendGetEmpty(conn, channelId)
 			}
 
-			msg := vhost.GetMessage(queue)
+			msg, deliveryTag, err := vhost.GetMessage(conn, channelId, queue, content.NoAck)
+			if err != nil {
+				return nil, err
+			}
+			if msg == nil {
+				// drained by a consumer in the meantime
+				return nil, b.sendGetEmpty(conn, channelId)
+			}
+			remaining, _ := vhost.GetMessageCount(queue)
 			msgGetOk := &message.BasicGetOk{
-				DeliveryTag: 1,
-				Redelivered: false,
-				Exchange:    msg.Exchange,
-				RoutingKey:  msg.RoutingKey,
+				DeliveryTag:  deliveryTag,
+				Redelivered:  msg.Redelivered,
+				Exchange:     msg.Exchange,
+				RoutingKey:   msg.RoutingKey,
+				MessageCount: uint32(remaining),
 			}
 
 			frame := amqp.ResponseMethodMessage{
@@ -615,61 +1002,184 @@ func (b *Broker) processRequest(conn net.Conn, newState *amqp.ChannelState) (int
 				Content:  *amqp.EncodeGetOkToContentList(msgGetOk),
 			}.FormatMethodFrame()
 
-			err = shared.SendFrame(conn, frame)
-			if err != nil {
-				fmt.Printf("[DEBUG] Error sending frame: %v\n", err)
-				return nil, err
-			}
-
 			responseContent := amqp.ResponseContent{
 				Channel: channelId,
 				ClassID: request.ClassID,
 				Weight:  0,
 				Message: *msg,
 			}
-			frame = responseContent.FormatHeaderFrame()
-			shared.SendFrame(conn, frame)
-			frame = responseContent.FormatBodyFrame()
-			shared.SendFrame(conn, frame)
+			frames := [][]byte{frame, responseContent.FormatHeaderFrame()}
+			frames = append(frames, responseContent.FormatBodyFrames(shared.FrameMax(conn))...)
+			err = shared.SendFrames(conn, frames...)
+			if err != nil {
+				fmt.Printf("[DEBUG] Error sending frame: %v\n", err)
+				return nil, err
+			}
 			return nil, nil
+
 		case uint16(constants.BASIC_ACK):
-			// if len(parts) != 2 {
-			// 	return common.CommandResponse{Status: "ERROR", Message: "Invalid command"}, nil
-			// }
-			// msgID := parts[1]
-			// // get queue from consumerID
-			// consumer, ok := b.Consumers[consumerID]
-			// if !ok {
-			// 	return common.CommandResponse{Status: "ERROR", Message: "Consumer not found"}, nil
-			// }
-			// queue := consumer.Queue
-			// b.acknowledge(queue, consumerID, msgID)
-			// return common.CommandResponse{Status: "OK", Message: fmt.Sprintf("Message ID %s acknowledged", msgID)}, nil
+			content, ok := request.Content.(*message.BasicAckMessage)
+			if !ok {
+				return nil, fmt.Errorf("Invalid content type for BasicAckMessage")
+			}
+			if b.bufferTxAck(conn, request) {
+				return nil, nil
+			}
+			return nil, b.connectionVHost(conn).Ack(conn, request.Channel, content.DeliveryTag, content.Multiple)
 
 		case uint16(constants.BASIC_REJECT):
+			content, ok := request.Content.(*message.BasicRejectMessage)
+			if !ok {
+				return nil, fmt.Errorf("Invalid content type for BasicRejectMessage")
+			}
+			if b.bufferTxAck(conn, request) {
+				return nil, nil
+			}
+			return nil, b.connectionVHost(conn).Reject(conn, request.Channel, content.DeliveryTag, content.Requeue)
+
+		case uint16(constants.BASIC_NACK):
+			content, ok := request.Content.(*message.BasicNackMessage)
+			if !ok {
+				return nil, fmt.Errorf("Invalid content type for BasicNackMessage")
+			}
+			if b.bufferTxAck(conn, request) {
+				return nil, nil
+			}
+			return nil, b.connectionVHost(conn).Nack(conn, request.Channel, content.DeliveryTag, content.Multiple, content.Requeue)
+
 		case uint16(constants.BASIC_RECOVER_ASYNC):
 		case uint16(constants.BASIC_RECOVER):
 		default:
-			return nil, fmt.Errorf("unsupported command")
+			return nil, amqp.NewError(constants.NOT_IMPLEMENTED, "unsupported command")
+		}
+	case uint16(constants.CONFIRM):
+		switch request.MethodID {
+		case uint16(confirm.SELECT):
+			content, ok := request.Content.(*message.ConfirmSelectMessage)
+			if !ok {
+				return nil, fmt.Errorf("Invalid content type for ConfirmSelectMessage")
+			}
+			currentState := b.getCurrentState(conn, request.Channel)
+			if currentState == nil {
+				return nil, amqp.NewError(constants.CHANNEL_ERROR, "channel %d not open", request.Channel)
+			}
+			if currentState.TxMode {
+				return nil, amqp.NewError(constants.PRECONDITION_FAILED, "cannot switch from tx to confirm mode")
+			}
+			currentState.ConfirmMode = true
+			if !content.NoWait {
+				frame := amqp.ResponseMethodMessage{
+					Channel:  request.Channel,
+					ClassID:  request.ClassID,
+					MethodID: uint16(confirm.SELECT_OK),
+					Content:  amqp.ContentList{},
+				}.FormatMethodFrame()
+				shared.SendFrame(conn, frame)
+			}
+			return nil, nil
+		default:
+			return nil, amqp.NewError(constants.NOT_IMPLEMENTED, "unsupported command")
 		}
 	case uint16(constants.TX):
 		// Handle transaction-related commands
+		currentState := b.getCurrentState(conn, request.Channel)
+		if currentState == nil {
+			return nil, amqp.NewError(constants.CHANNEL_ERROR, "channel %d not open", request.Channel)
+		}
+		var replyMethod tx.TxMethod
 		switch request.MethodID {
 		case uint16(tx.SELECT):
-			// Handle transaction selection
+			if currentState.ConfirmMode {
+				return nil, amqp.NewError(constants.PRECONDITION_FAILED, "cannot switch from confirm to tx mode")
+			}
+			currentState.TxMode = true
+			replyMethod = tx.SELECT_OK
 		case uint16(tx.COMMIT):
-			// Handle transaction commit
+			if !currentState.TxMode {
+				return nil, amqp.NewError(constants.PRECONDITION_FAILED, "channel is not transactional")
+			}
+			publishes, acks := currentState.TxPublishes, currentState.TxAcks
+			currentState.TxPublishes, currentState.TxAcks = nil, nil
+			if err := b.connectionVHost(conn).CommitTx(conn, request.Channel, publishes, acks); err != nil {
+				return nil, err
+			}
+			replyMethod = tx.COMMIT_OK
 		case uint16(tx.ROLLBACK):
-			// Handle transaction rollback
+			if !currentState.TxMode {
+				return nil, amqp.NewError(constants.PRECONDITION_FAILED, "channel is not transactional")
+			}
+			currentState.TxPublishes, currentState.TxAcks = nil, nil
+			replyMethod = tx.ROLLBACK_OK
 		default:
-			return nil, fmt.Errorf("unsupported command")
+			return nil, amqp.NewError(constants.NOT_IMPLEMENTED, "unsupported command")
 		}
+		frame := amqp.ResponseMethodMessage{
+			Channel:  request.Channel,
+			ClassID:  request.ClassID,
+			MethodID: uint16(replyMethod),
+			Content:  amqp.ContentList{},
+		}.FormatMethodFrame()
+		shared.SendFrame(conn, frame)
+		return nil, nil
 	default:
-		return nil, fmt.Errorf("unsupported command")
+		return nil, amqp.NewError(constants.NOT_IMPLEMENTED, "unsupported command")
 	}
 	return nil, nil
 }
 
+func (b *Broker) sendGetEmpty(conn net.Conn, channel uint16) error {
+	reserved1 := amqp.KeyValue{
+		Key:   amqp.STRING_SHORT,
+		Value: "",
+	}
+	frame := amqp.ResponseMethodMessage{
+		Channel:  channel,
+		ClassID:  uint16(constants.BASIC),
+		MethodID: uint16(constants.BASIC_GET_EMPTY),
+		Content:  amqp.ContentList{KeyValuePairs: []amqp.KeyValue{reserved1}},
+	}.FormatMethodFrame()
+	return shared.SendFrame(conn, frame)
+}
+
+// bufferTxAck holds back an ack, nack or reject received on a transactional
+// channel until tx.commit. It returns false if the channel is not
+// transactional.
+func (b *Broker) bufferTxAck(conn net.Conn, request *amqp.RequestMethodMessage) bool {
+	currentState := b.getCurrentState(conn, request.Channel)
+	if currentState == nil || !currentState.TxMode {
+		return false
+	}
+	currentState.TxAcks = append(currentState.TxAcks, request)
+	return true
+}
+
+// sendPublisherConfirm acks or nacks the publish with the given sequence
+// number on a channel in confirm mode
+func (b *Broker) sendPublisherConfirm(conn net.Conn, channel uint16, seq uint64, ack bool) error {
+	methodID := uint16(constants.BASIC_ACK)
+	if !ack {
+		methodID = uint16(constants.BASIC_NACK)
+	}
+	frame := amqp.ResponseMethodMessage{
+		Channel:  channel,
+		ClassID:  uint16(constants.BASIC),
+		MethodID: methodID,
+		Content: amqp.ContentList{
+			KeyValuePairs: []amqp.KeyValue{
+				{ // delivery-tag
+					Key:   amqp.INT_LONG_LONG,
+					Value: seq,
+				},
+				{ // multiple
+					Key:   amqp.BIT,
+					Value: false,
+				},
+			},
+		},
+	}.FormatMethodFrame()
+	return shared.SendFrame(conn, frame)
+}
+
 func (b *Broker) updateCurrentState(conn net.Conn, channel uint16, newState *amqp.ChannelState) {
 	fmt.Println("Updating current state on channel ", channel)
 	currentState := b.getCurrentState(conn, channel)
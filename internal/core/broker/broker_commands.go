@@ -2,6 +2,7 @@ package broker
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/andrelcunha/ottermq/internal/core/vhost"
 	. "github.com/andrelcunha/ottermq/pkg/common"
@@ -42,6 +43,30 @@ func mapListConnectionsDTO(connections []ConnectionInfo) []ConnectionInfoDTO {
 	return listConnectonsDTO
 }
 
+func ListVHosts(b *Broker) []VHostDTO {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	connections := make(map[string]int)
+	for _, c := range b.Connections {
+		connections[c.VHostName]++
+	}
+	vhosts := make([]VHostDTO, 0, len(b.VHosts))
+	for _, vh := range b.VHosts {
+		exchanges, queues := vh.Counts()
+		vhosts = append(vhosts, VHostDTO{
+			Name:        vh.Name,
+			Id:          vh.Id,
+			Exchanges:   exchanges,
+			Queues:      queues,
+			Connections: connections[vh.Name],
+		})
+	}
+	sort.Slice(vhosts, func(i, j int) bool {
+		return vhosts[i].Name < vhosts[j].Name
+	})
+	return vhosts
+}
+
 func ListExchanges(b *Broker) []ExchangeDTO {
 	exchanges := make([]ExchangeDTO, 0, b.GetTotalExchanges())
 	b.mu.Lock()
@@ -68,16 +93,39 @@ func ListQueues(b *Broker) []QueueDTO {
 		vhost := b.VHosts[vhostName]
 		for _, queue := range b.VHosts[vhost.Name].Queues {
 			queues = append(queues, QueueDTO{
-				VHostName: vhost.Name,
-				VHostId:   vhost.Id,
-				Name:      queue.Name,
-				Messages:  queue.Len(),
+				VHostName:          vhost.Name,
+				VHostId:            vhost.Id,
+				Name:               queue.Name,
+				Messages:           queue.Len(),
+				MessagesByPriority: queue.LenByPriority(),
 			})
 		}
 	}
 	return queues
 }
 
+func ListConsumers(b *Broker) []ConsumerDTO {
+	consumers := make([]ConsumerDTO, 0)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, vhost := range b.VHosts {
+		for _, consumer := range vhost.GetConsumers() {
+			consumers = append(consumers, ConsumerDTO{
+				VHostName:     vhost.Name,
+				VHostId:       vhost.Id,
+				ConsumerTag:   consumer.ID,
+				Queue:         consumer.Queue,
+				Connection:    consumer.SessionID,
+				Channel:       consumer.Channel,
+				AckRequired:   !consumer.NoAck,
+				Exclusive:     consumer.Exclusive,
+				PrefetchCount: consumer.PrefetchCount,
+			})
+		}
+	}
+	return consumers
+}
+
 func ListBindings(b *Broker, vhostName, exchangeName string) map[string][]string {
 	vh := b.GetVHostFromName(vhostName)
 	b.mu.Lock()
@@ -110,6 +158,10 @@ func ListBindings(b *Broker, vhostName, exchangeName string) map[string][]string
 		}
 		bindings["fanout"] = queues
 		return bindings
+	case vhost.TOPIC:
+		return vh.GetTopicBindings(exchange)
+	case vhost.HEADERS:
+		return vh.GetHeadersBindings(exchange)
 	}
 	return nil
 }
@@ -1,5 +1,124 @@
 package broker
 
+import (
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrelcunha/ottermq/config"
+	"github.com/andrelcunha/ottermq/pkg/persistdb"
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+// startBroker starts a broker on a free port, with a data directory of its
+// own and an admin user guest, and returns it with a connection of guest
+func startBroker(t *testing.T) (*Broker, *amqp091.Connection) {
+	log.SetOutput(io.Discard)
+	dir := t.TempDir()
+	persistdb.SetDbPath(filepath.Join(dir, "ottermq.db"))
+	persistdb.InitDB()
+	persistdb.AddDefaultRoles()
+	persistdb.AddDefaultPermissions()
+	if err := persistdb.AddUser(persistdb.UserCreateDTO{Username: "guest", Password: "guest", RoleID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	b := NewBroker(&config.Config{
+		Port:                 port,
+		Host:                 "localhost",
+		Username:             "guest",
+		Password:             "guest",
+		HeartbeatIntervalMax: 10,
+		ChannelMax:           5,
+		FrameMax:             131072,
+		DataDir:              dir,
+	})
+	b.VHosts["/"].Users["guest"] = &persistdb.User{Username: "guest", RoleID: 1}
+	go b.Start()
+	t.Cleanup(b.Shutdown)
+
+	var conn *amqp091.Connection
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err = amqp091.Dial("amqp://guest:guest@" + net.JoinHostPort("localhost", port) + "/")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("broker not listening on port %s: %v", port, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return b, conn
+}
+
+// newTestBroker returns a broker that is not started, with a data directory
+// of its own
+func newTestBroker(t *testing.T) *Broker {
+	log.SetOutput(io.Discard)
+	dir := t.TempDir()
+	persistdb.SetDbPath(filepath.Join(dir, "ottermq.db"))
+	persistdb.InitDB()
+	persistdb.AddDefaultRoles()
+	b := NewBroker(&config.Config{DataDir: dir})
+	t.Cleanup(b.Shutdown)
+	return b
+}
+
+// TestCreateVHostUsers checks only the administrators of the default vhost
+// get access to a new vhost
+func TestCreateVHostUsers(t *testing.T) {
+	b := newTestBroker(t)
+	b.VHosts["/"].Users["admin"] = &persistdb.User{Username: "admin", RoleID: persistdb.AdminRoleID}
+	b.VHosts["/"].Users["user"] = &persistdb.User{Username: "user", RoleID: 2}
+
+	vh, err := b.CreateVHost("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !vh.HasUser("admin") {
+		t.Error("administrator without access to the new vhost")
+	}
+	if vh.HasUser("user") {
+		t.Error("user given access to the new vhost")
+	}
+}
+
+// TestExchangeDeleteErrors checks a failed exchange.delete closes the channel
+// with the exception instead of leaving the client waiting for a reply
+func TestExchangeDeleteErrors(t *testing.T) {
+	_, conn := startBroker(t)
+	for name, code := range map[string]int{
+		"missing": amqp091.NotFound,
+		"":        amqp091.AccessRefused,
+	} {
+		ch, err := conn.Channel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.ExchangeDelete(name, false, false); !isReplyCode(err, code) {
+			t.Errorf("deleting exchange %q: %v, want reply code %d", name, err, code)
+		}
+	}
+	// the connection survives the channel errors
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.Close()
+}
+
 // import (
 // 	"testing"
 // 	"time"
@@ -1,8 +1,14 @@
 package broker
 
 import (
-	// "github.com/andrelcunha/ottermq/pkg/persistdb"
+	"fmt"
+	"log"
+	"net"
+
 	"github.com/andrelcunha/ottermq/internal/core/vhost"
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/connection/constants"
+	"github.com/andrelcunha/ottermq/pkg/persistdb"
 )
 
 func (b *Broker) GetVHostFromName(vhostName string) *vhost.VHost {
@@ -14,10 +20,123 @@ func (b *Broker) GetVHostFromName(vhostName string) *vhost.VHost {
 	return nil
 }
 
+// CreateVHost adds a new vhost. The administrators of the default vhost get
+// access to it.
+func (b *Broker) CreateVHost(name string) (*vhost.VHost, error) {
+	if name == "" {
+		return nil, fmt.Errorf("vhost name is required")
+	}
+	b.mu.Lock()
+	if _, ok := b.VHosts[name]; ok {
+		b.mu.Unlock()
+		return nil, fmt.Errorf("vhost %s already exists", name)
+	}
+	vh := b.newVHost(name)
+	if defaultVHost, ok := b.VHosts["/"]; ok {
+		for username, user := range defaultVHost.Users {
+			if user.IsAdmin() {
+				vh.Users[username] = user
+			}
+		}
+	}
+	b.VHosts[name] = vh
+	b.mu.Unlock()
+	if err := persistdb.SaveVHost(name); err != nil {
+		log.Printf("Failed to persist vhost %s: %v", name, err)
+	}
+	b.loadPermissions(vh)
+	log.Printf("VHost %s created", name)
+	return vh, nil
+}
+
+// restoreVHosts adds back the vhosts created before the broker restarted.
+// Like CreateVHost, it gives the administrators of the default vhost access
+// to them.
+func (b *Broker) restoreVHosts() error {
+	names, err := persistdb.GetVHosts()
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, name := range names {
+		if _, ok := b.VHosts[name]; ok {
+			continue
+		}
+		vh := b.newVHost(name)
+		if defaultVHost, ok := b.VHosts["/"]; ok {
+			for username, user := range defaultVHost.Users {
+				if user.IsAdmin() {
+					vh.Users[username] = user
+				}
+			}
+		}
+		b.VHosts[name] = vh
+	}
+	return nil
+}
+
+// DeleteVHost removes the vhost with its exchanges and queues, and closes
+// the connections opened on it. The default vhost cannot be deleted.
+func (b *Broker) DeleteVHost(name string) error {
+	if name == "/" {
+		return fmt.Errorf("cannot delete the default vhost")
+	}
+	b.mu.Lock()
+	vh, ok := b.VHosts[name]
+	if !ok {
+		b.mu.Unlock()
+		return fmt.Errorf("vhost %s not found", name)
+	}
+	delete(b.VHosts, name)
+	conns := make([]net.Conn, 0)
+	for conn, info := range b.Connections {
+		if info.VHostName == name {
+			conns = append(conns, conn)
+		}
+	}
+	b.mu.Unlock()
+
+	vh.Close()
+	if b.store != nil {
+		for _, queue := range b.store.Queues(name) {
+			if err := b.store.DeleteQueue(name, queue); err != nil {
+				log.Printf("Failed to delete the messages of queue %s: %v", queue, err)
+			}
+		}
+	}
+	if err := persistdb.DeleteVHostPermissions(name); err != nil {
+		log.Printf("Failed to delete permissions of vhost %s: %v", name, err)
+	}
+	if err := persistdb.DeleteVHost(name); err != nil {
+		log.Printf("Failed to delete persisted vhost %s: %v", name, err)
+	}
+
+	closeErr := amqp.NewError(constants.CONNECTION_FORCED, "vhost '%s' is deleted", name)
+	for _, conn := range conns {
+		b.sendConnectionClose(conn, closeErr, 0, 0)
+		conn.Close()
+	}
+	log.Printf("VHost %s deleted", name)
+	return nil
+}
+
 func (b *Broker) Shutdown() {
+	// closing a connection cleans it up, which takes the lock
+	b.mu.Lock()
+	conns := make([]net.Conn, 0, len(b.Connections))
 	for conn := range b.Connections {
+		conns = append(conns, conn)
+	}
+	b.mu.Unlock()
+	for _, conn := range conns {
 		conn.Close()
 	}
+	if b.store != nil {
+		if err := b.store.Close(); err != nil {
+			log.Printf("Failed to close the message store: %v", err)
+		}
+	}
 }
 
 func (b *Broker) GetTotalQueues() int {
@@ -26,11 +145,7 @@ func (b *Broker) GetTotalQueues() int {
 	total := 0
 	for vhostName := range b.VHosts {
 		vhost := b.VHosts[vhostName]
-		for _, queue := range b.VHosts[vhost.Name].Queues {
-			if queue.Name != "" {
-				total++
-			}
-		}
+		total += len(vhost.Queues)
 	}
 	return total
 }
@@ -0,0 +1,51 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+// TestPublisherConfirms publishes on a channel in confirm mode: the publishes
+// are confirmed in sequence, and a publish refused by a full queue with the
+// reject-publish overflow is nacked without closing the channel
+func TestPublisherConfirms(t *testing.T) {
+	_, conn := startBroker(t)
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ch.QueueDeclare("q", false, false, false, false, amqp091.Table{
+		"x-max-length": int32(2),
+		"x-overflow":   "reject-publish",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		t.Fatal(err)
+	}
+	confirms := ch.NotifyPublish(make(chan amqp091.Confirmation, 4))
+
+	for i := 0; i < 3; i++ {
+		if err := ch.Publish("", "q", false, false, amqp091.Publishing{Body: []byte("m")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, ack := range []bool{true, true, false} {
+		select {
+		case confirm := <-confirms:
+			if confirm.DeliveryTag != uint64(i+1) || confirm.Ack != ack {
+				t.Fatalf("confirmation %+v, want tag %d ack %v", confirm, i+1, ack)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("no confirmation of publish %d", i+1)
+		}
+	}
+
+	// the channel is still open after the nack
+	if _, err := ch.QueueDeclarePassive("q", false, false, false, false, nil); err != nil {
+		t.Fatalf("channel closed by a nacked publish: %v", err)
+	}
+}
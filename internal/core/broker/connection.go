@@ -5,6 +5,8 @@ import (
 	"net"
 
 	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/connection/constants"
+	"github.com/andrelcunha/ottermq/pkg/connection/shared"
 )
 
 func (b *Broker) checkChannel(conn net.Conn, channel uint16) bool {
@@ -29,3 +31,55 @@ func (b *Broker) removeChannel(conn net.Conn, channel uint16) {
 	defer b.mu.Unlock()
 	delete(b.Connections[conn].Channels, channel)
 }
+
+// closeChannel removes the channel and releases its consumers
+func (b *Broker) closeChannel(conn net.Conn, channel uint16) {
+	b.removeChannel(conn, channel)
+	// the consumers of a channel are all on the vhost of its connection
+	if vh := b.connectionVHost(conn); vh != nil {
+		vh.CleanupChannel(conn, channel)
+	}
+}
+
+func (b *Broker) sendChannelClose(conn net.Conn, channel uint16, amqpErr *amqp.Error, classID, methodID uint16) error {
+	frame := amqp.ResponseMethodMessage{
+		Channel:  channel,
+		ClassID:  uint16(constants.CHANNEL),
+		MethodID: uint16(constants.CHANNEL_CLOSE),
+		Content:  closeContent(amqpErr, classID, methodID),
+	}.FormatMethodFrame()
+	return shared.SendFrame(conn, frame)
+}
+
+func (b *Broker) sendConnectionClose(conn net.Conn, amqpErr *amqp.Error, classID, methodID uint16) error {
+	frame := amqp.ResponseMethodMessage{
+		Channel:  0,
+		ClassID:  uint16(constants.CONNECTION),
+		MethodID: uint16(constants.CONNECTION_CLOSE),
+		Content:  closeContent(amqpErr, classID, methodID),
+	}.FormatMethodFrame()
+	return shared.SendFrame(conn, frame)
+}
+
+func closeContent(amqpErr *amqp.Error, classID, methodID uint16) amqp.ContentList {
+	return amqp.ContentList{
+		KeyValuePairs: []amqp.KeyValue{
+			{ // reply-code
+				Key:   amqp.INT_SHORT,
+				Value: uint16(amqpErr.ReplyCode),
+			},
+			{ // reply-text
+				Key:   amqp.STRING_SHORT,
+				Value: amqpErr.ReplyText,
+			},
+			{ // class-id
+				Key:   amqp.INT_SHORT,
+				Value: classID,
+			},
+			{ // method-id
+				Key:   amqp.INT_SHORT,
+				Value: methodID,
+			},
+		},
+	}
+}
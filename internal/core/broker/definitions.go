@@ -0,0 +1,262 @@
+package broker
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/andrelcunha/ottermq/internal/core/vhost"
+	. "github.com/andrelcunha/ottermq/pkg/common"
+	"github.com/andrelcunha/ottermq/pkg/connection/utils"
+	"github.com/andrelcunha/ottermq/pkg/persistdb"
+)
+
+// ExportDefinitions returns the vhosts, users with their password hashes,
+// permissions and the durable exchanges, queues and bindings of the broker
+func (b *Broker) ExportDefinitions() (*Definitions, error) {
+	defs := &Definitions{
+		VHosts:           []VHostDefinition{},
+		Users:            []UserDefinition{},
+		Permissions:      []PermissionDefinition{},
+		TopicPermissions: []TopicPermissionDefinition{},
+		Exchanges:        []ExchangeDefinition{},
+		Queues:           []QueueDefinition{},
+		Bindings:         []BindingDefinition{},
+		Policies:         []interface{}{},
+	}
+
+	b.mu.Lock()
+	vhostNames := make([]string, 0, len(b.VHosts))
+	for name := range b.VHosts {
+		vhostNames = append(vhostNames, name)
+	}
+	b.mu.Unlock()
+	sort.Strings(vhostNames)
+
+	for _, name := range vhostNames {
+		defs.VHosts = append(defs.VHosts, VHostDefinition{Name: name})
+		exchanges, queues, bindings, err := persistdb.GetTopology(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range exchanges {
+			args, err := decodeDefinitionArguments(record.Arguments)
+			if err != nil {
+				return nil, fmt.Errorf("exchange %s in vhost %s: %v", record.Name, name, err)
+			}
+			defs.Exchanges = append(defs.Exchanges, ExchangeDefinition{
+				Name:       record.Name,
+				VHost:      name,
+				Type:       record.Type,
+				Durable:    true,
+				AutoDelete: record.AutoDelete,
+				Internal:   record.Internal,
+				Arguments:  args,
+			})
+		}
+		for _, record := range queues {
+			args, err := decodeDefinitionArguments(record.Arguments)
+			if err != nil {
+				return nil, fmt.Errorf("queue %s in vhost %s: %v", record.Name, name, err)
+			}
+			defs.Queues = append(defs.Queues, QueueDefinition{
+				Name:       record.Name,
+				VHost:      name,
+				Durable:    true,
+				AutoDelete: record.AutoDelete,
+				Arguments:  args,
+			})
+		}
+		for _, record := range bindings {
+			args, err := decodeDefinitionArguments(record.Arguments)
+			if err != nil {
+				return nil, fmt.Errorf("binding of queue %s in vhost %s: %v", record.Queue, name, err)
+			}
+			defs.Bindings = append(defs.Bindings, BindingDefinition{
+				Source:          record.Exchange,
+				VHost:           name,
+				Destination:     record.Queue,
+				DestinationType: "queue",
+				RoutingKey:      record.RoutingKey,
+				Arguments:       args,
+			})
+		}
+	}
+
+	users, err := persistdb.GetUsersWithPasswords()
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		role, err := persistdb.GetRoleByID(user.RoleID)
+		if err != nil {
+			return nil, fmt.Errorf("role of user %s: %v", user.Username, err)
+		}
+		defs.Users = append(defs.Users, UserDefinition{
+			Name:         user.Username,
+			PasswordHash: user.Password,
+			Role:         role.Name,
+		})
+	}
+
+	permissions, err := persistdb.GetUserPermissions()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range permissions {
+		defs.Permissions = append(defs.Permissions, PermissionDefinition{
+			User:      p.Username,
+			VHost:     p.VHost,
+			Configure: p.Configure,
+			Write:     p.Write,
+			Read:      p.Read,
+		})
+	}
+
+	topicPermissions, err := persistdb.GetTopicPermissions()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range topicPermissions {
+		defs.TopicPermissions = append(defs.TopicPermissions, TopicPermissionDefinition{
+			User:     p.Username,
+			VHost:    p.VHost,
+			Exchange: p.Exchange,
+			Write:    p.Write,
+			Read:     p.Read,
+		})
+	}
+	return defs, nil
+}
+
+// ImportDefinitions applies the definitions on top of the current state.
+// Existing vhosts, exchanges, queues and bindings are kept, so importing the
+// same document twice is a no-op; users and permissions are overwritten.
+// An exchange or queue declared with other options than the existing one
+// fails the import.
+func (b *Broker) ImportDefinitions(defs *Definitions) error {
+	for _, def := range defs.VHosts {
+		if b.GetVHostFromName(def.Name) != nil {
+			continue
+		}
+		if _, err := b.CreateVHost(def.Name); err != nil {
+			return err
+		}
+	}
+
+	for _, def := range defs.Users {
+		if def.Name == "" || def.PasswordHash == "" {
+			return fmt.Errorf("user name and password hash are required")
+		}
+		role, err := persistdb.GetRoleByName(def.Role)
+		if err != nil {
+			return fmt.Errorf("role %s of user %s not found", def.Role, def.Name)
+		}
+		if err := persistdb.SaveUserWithHash(def.Name, def.PasswordHash, role.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, def := range defs.Permissions {
+		if err := b.SetUserPermissions(def.User, def.VHost, def.Configure, def.Write, def.Read); err != nil {
+			return fmt.Errorf("permissions of user %s in vhost %s: %v", def.User, def.VHost, err)
+		}
+	}
+	for _, def := range defs.TopicPermissions {
+		if err := b.SetTopicPermissions(def.User, def.VHost, def.Exchange, def.Write, def.Read); err != nil {
+			return fmt.Errorf("topic permissions of user %s on exchange %s in vhost %s: %v", def.User, def.Exchange, def.VHost, err)
+		}
+	}
+
+	for _, def := range defs.Exchanges {
+		vh := b.GetVHostFromName(def.VHost)
+		if vh == nil {
+			return fmt.Errorf("vhost %s of exchange %s not found", def.VHost, def.Name)
+		}
+		err := vh.DeclareExchange(def.Name, vhost.ExchangeType(def.Type), false, vhost.ExchangeOptions{
+			Durable:    def.Durable,
+			AutoDelete: def.AutoDelete,
+			Internal:   def.Internal,
+			Arguments:  JSONArguments(def.Arguments),
+		})
+		if err != nil {
+			return fmt.Errorf("exchange %s in vhost %s: %v", def.Name, def.VHost, err)
+		}
+	}
+
+	for _, def := range defs.Queues {
+		vh := b.GetVHostFromName(def.VHost)
+		if vh == nil {
+			return fmt.Errorf("vhost %s of queue %s not found", def.VHost, def.Name)
+		}
+		if def.Name == "" {
+			return fmt.Errorf("queue name is required")
+		}
+		_, err := vh.DeclareQueue(nil, def.Name, false, vhost.QueueOptions{
+			Durable:    def.Durable,
+			AutoDelete: def.AutoDelete,
+			Arguments:  JSONArguments(def.Arguments),
+		})
+		if err != nil {
+			return fmt.Errorf("queue %s in vhost %s: %v", def.Name, def.VHost, err)
+		}
+	}
+
+	for _, def := range defs.Bindings {
+		if def.DestinationType != "" && def.DestinationType != "queue" {
+			return fmt.Errorf("binding of %s %s: only queues can be bound", def.DestinationType, def.Destination)
+		}
+		vh := b.GetVHostFromName(def.VHost)
+		if vh == nil {
+			return fmt.Errorf("vhost %s of binding of queue %s not found", def.VHost, def.Destination)
+		}
+		if err := vh.BindQueue("", def.Source, def.Destination, def.RoutingKey, JSONArguments(def.Arguments)); err != nil {
+			return fmt.Errorf("binding of queue %s to exchange %s in vhost %s: %v", def.Destination, def.Source, def.VHost, err)
+		}
+	}
+
+	if len(defs.Policies) > 0 {
+		log.Printf("Ignored %d policies: policies are not supported", len(defs.Policies))
+	}
+	return nil
+}
+
+func decodeDefinitionArguments(data []byte) (map[string]interface{}, error) {
+	if len(data) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	return utils.DecodeTable(data)
+}
+
+// JSONArguments turns the JSON numbers of arguments decoded from a request
+// or an imported document back into integers where they have no fractional
+// part, as AMQP clients send them
+func JSONArguments(args map[string]interface{}) map[string]interface{} {
+	if len(args) == 0 {
+		return nil
+	}
+	converted := make(map[string]interface{}, len(args))
+	for key, value := range args {
+		converted[key] = jsonArgumentValue(value)
+	}
+	return converted
+}
+
+func jsonArgumentValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) <= math.MaxInt64 {
+			return int64(v)
+		}
+	case map[string]interface{}:
+		return JSONArguments(v)
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = jsonArgumentValue(item)
+		}
+		return converted
+	}
+	return value
+}
@@ -0,0 +1,76 @@
+package broker
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	. "github.com/andrelcunha/ottermq/pkg/common"
+)
+
+const testDefinitions = `{
+	"vhosts": [{"name": "other"}],
+	"users": [{"name": "app", "password_hash": "hash", "role": "user"}],
+	"permissions": [{"user": "app", "vhost": "other", "configure": ".*", "write": ".*", "read": ".*"}],
+	"exchanges": [{"name": "x", "vhost": "other", "type": "topic", "durable": true, "arguments": {}}],
+	"queues": [{"name": "q", "vhost": "other", "durable": true, "arguments": {"x-message-ttl": 60000, "x-max-priority": 5}}],
+	"bindings": [{"source": "x", "vhost": "other", "destination": "q", "destination_type": "queue", "routing_key": "a.#", "arguments": {}}]
+}`
+
+func TestImportDefinitionsTwice(t *testing.T) {
+	b := newTestBroker(t)
+	var exports [2][]byte
+	for i := range exports {
+		var defs Definitions
+		if err := json.Unmarshal([]byte(testDefinitions), &defs); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.ImportDefinitions(&defs); err != nil {
+			t.Fatalf("import %d: %v", i+1, err)
+		}
+		exported, err := b.ExportDefinitions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exports[i], err = json.Marshal(exported); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if string(exports[0]) != string(exports[1]) {
+		t.Fatalf("second import changed the definitions:\n%s\n%s", exports[0], exports[1])
+	}
+
+	vh := b.GetVHostFromName("other")
+	if exchanges, queues := vh.Counts(); queues != 1 {
+		t.Fatalf("%d exchange(s) and %d queue(s) after two imports", exchanges, queues)
+	}
+	if queue := vh.Queues["q"]; queue.MessageTTL != 60000 {
+		t.Fatalf("imported queue has message TTL %d, want 60000", queue.MessageTTL)
+	}
+}
+
+func TestJSONArguments(t *testing.T) {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(`{
+		"x-message-ttl": 60000,
+		"ratio": 0.5,
+		"x-dead-letter-exchange": "dlx",
+		"nested": {"n": 1},
+		"list": [2, "s"]
+	}`), &args); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"x-message-ttl":          int64(60000),
+		"ratio":                  0.5,
+		"x-dead-letter-exchange": "dlx",
+		"nested":                 map[string]interface{}{"n": int64(1)},
+		"list":                   []interface{}{int64(2), "s"},
+	}
+	if got := JSONArguments(args); !reflect.DeepEqual(got, want) {
+		t.Fatalf("JSONArguments = %#v, want %#v", got, want)
+	}
+	if got := JSONArguments(map[string]interface{}{}); got != nil {
+		t.Fatalf("JSONArguments of no arguments = %#v, want nil", got)
+	}
+}
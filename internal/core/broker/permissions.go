@@ -0,0 +1,140 @@
+package broker
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/andrelcunha/ottermq/internal/core/vhost"
+	"github.com/andrelcunha/ottermq/pkg/persistdb"
+)
+
+// SetUserPermissions grants the user the configure, write and read
+// permissions in the vhost, replacing the previous ones. The permissions are
+// stored in persistdb and apply to the open connections too.
+func (b *Broker) SetUserPermissions(username, vhostName, configure, write, read string) error {
+	vh := b.GetVHostFromName(vhostName)
+	if vh == nil {
+		return fmt.Errorf("vhost %s not found", vhostName)
+	}
+	if _, err := persistdb.GetUserByUsername(username); err != nil {
+		return fmt.Errorf("user %s not found", username)
+	}
+	permissions, err := vhost.NewPermissions(configure, write, read)
+	if err != nil {
+		return err
+	}
+	err = persistdb.SetUserPermission(persistdb.UserPermission{
+		Username:  username,
+		VHost:     vhostName,
+		Configure: configure,
+		Write:     write,
+		Read:      read,
+	})
+	if err != nil {
+		return err
+	}
+	vh.SetPermissions(username, permissions)
+	return nil
+}
+
+// ClearUserPermissions revokes the permissions of the user in the vhost
+func (b *Broker) ClearUserPermissions(username, vhostName string) error {
+	if err := persistdb.DeleteUserPermission(username, vhostName); err != nil {
+		return err
+	}
+	if vh := b.GetVHostFromName(vhostName); vh != nil {
+		vh.ClearPermissions(username)
+	}
+	return nil
+}
+
+// SetTopicPermissions restricts the routing keys the user may publish
+// (write) or bind (read) with on the topic exchange of the vhost
+func (b *Broker) SetTopicPermissions(username, vhostName, exchangeName, write, read string) error {
+	vh := b.GetVHostFromName(vhostName)
+	if vh == nil {
+		return fmt.Errorf("vhost %s not found", vhostName)
+	}
+	if exchangeName == "" {
+		return fmt.Errorf("exchange name is required")
+	}
+	if _, err := persistdb.GetUserByUsername(username); err != nil {
+		return fmt.Errorf("user %s not found", username)
+	}
+	permissions, err := vhost.NewTopicPermissions(exchangeName, write, read)
+	if err != nil {
+		return err
+	}
+	err = persistdb.SetTopicPermission(persistdb.TopicPermission{
+		Username: username,
+		VHost:    vhostName,
+		Exchange: exchangeName,
+		Write:    write,
+		Read:     read,
+	})
+	if err != nil {
+		return err
+	}
+	vh.SetTopicPermissions(username, permissions)
+	return nil
+}
+
+// ClearTopicPermissions lifts the topic restrictions of the user on the
+// exchange
+func (b *Broker) ClearTopicPermissions(username, vhostName, exchangeName string) error {
+	if err := persistdb.DeleteTopicPermission(username, vhostName, exchangeName); err != nil {
+		return err
+	}
+	if vh := b.GetVHostFromName(vhostName); vh != nil {
+		vh.ClearTopicPermissions(username, exchangeName)
+	}
+	return nil
+}
+
+// loadPermissions sets the permissions stored in persistdb for the vhost
+func (b *Broker) loadPermissions(vh *vhost.VHost) {
+	entries, err := persistdb.GetUserPermissions()
+	if err != nil {
+		log.Printf("Failed to load permissions of vhost %s: %v", vh.Name, err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.VHost != vh.Name {
+			continue
+		}
+		permissions, err := vhost.NewPermissions(entry.Configure, entry.Write, entry.Read)
+		if err != nil {
+			log.Printf("Invalid permissions of user %s in vhost %s: %v", entry.Username, entry.VHost, err)
+			continue
+		}
+		vh.SetPermissions(entry.Username, permissions)
+	}
+
+	topicEntries, err := persistdb.GetTopicPermissions()
+	if err != nil {
+		log.Printf("Failed to load topic permissions of vhost %s: %v", vh.Name, err)
+		return
+	}
+	for _, entry := range topicEntries {
+		if entry.VHost != vh.Name {
+			continue
+		}
+		permissions, err := vhost.NewTopicPermissions(entry.Exchange, entry.Write, entry.Read)
+		if err != nil {
+			log.Printf("Invalid topic permissions of user %s on exchange %s in vhost %s: %v", entry.Username, entry.Exchange, entry.VHost, err)
+			continue
+		}
+		vh.SetTopicPermissions(entry.Username, permissions)
+	}
+}
+
+// checkPermission returns ACCESS_REFUSED unless the user of the connection
+// has the access to the exchange or queue
+func (b *Broker) checkPermission(conn net.Conn, access vhost.Access, kind, name string) error {
+	vh := b.connectionVHost(conn)
+	if vh == nil {
+		return fmt.Errorf("connection not found")
+	}
+	return vh.CheckPermission(b.connectionUser(conn), access, kind, name)
+}
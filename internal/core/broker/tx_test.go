@@ -0,0 +1,129 @@
+package broker
+
+import (
+	"errors"
+	"testing"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+// messageCount returns the number of ready messages of the queue
+func messageCount(t *testing.T, conn *amqp091.Connection, queue string) int {
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Close()
+	q, err := ch.QueueDeclarePassive(queue, false, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q.Messages
+}
+
+func isReplyCode(err error, code int) bool {
+	var amqpErr *amqp091.Error
+	return errors.As(err, &amqpErr) && amqpErr.Code == code
+}
+
+func TestTxCommit(t *testing.T) {
+	_, conn := startBroker(t)
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ch.QueueDeclare("q", false, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Tx(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := ch.Publish("", "q", false, false, amqp091.Publishing{Body: []byte("m")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := messageCount(t, conn, "q"); n != 0 {
+		t.Fatalf("%d message(s) visible before the commit", n)
+	}
+	if err := ch.TxCommit(); err != nil {
+		t.Fatal(err)
+	}
+	if n := messageCount(t, conn, "q"); n != 2 {
+		t.Fatalf("%d message(s) after the commit, want 2", n)
+	}
+
+	// a commit with an unknown delivery tag applies none of its publishes
+	if err := ch.Publish("", "q", false, false, amqp091.Publishing{Body: []byte("m")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Ack(42, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.TxCommit(); !isReplyCode(err, amqp091.PreconditionFailed) {
+		t.Fatalf("committing an unknown delivery tag: %v", err)
+	}
+	if n := messageCount(t, conn, "q"); n != 2 {
+		t.Fatalf("%d message(s) after a failed commit, want 2", n)
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	_, conn := startBroker(t)
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ch.QueueDeclare("q", false, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Publish("", "q", false, false, amqp091.Publishing{Body: []byte("a")}); err != nil {
+		t.Fatal(err)
+	}
+	if n := messageCount(t, conn, "q"); n != 1 {
+		t.Fatalf("%d message(s) published, want 1", n)
+	}
+
+	if err := ch.Tx(); err != nil {
+		t.Fatal(err)
+	}
+	delivery, ok, err := ch.Get("q", false)
+	if err != nil || !ok {
+		t.Fatalf("get: ok=%v err=%v", ok, err)
+	}
+	if err := ch.Publish("", "q", false, false, amqp091.Publishing{Body: []byte("b")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := delivery.Ack(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.TxRollback(); err != nil {
+		t.Fatal(err)
+	}
+	if n := messageCount(t, conn, "q"); n != 0 {
+		t.Fatalf("%d message(s) after the rollback, want 0", n)
+	}
+	// the ack was discarded: closing the channel requeues the message
+	if err := ch.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := messageCount(t, conn, "q"); n != 1 {
+		t.Fatalf("%d message(s) after closing the channel, want 1", n)
+	}
+}
+
+func TestTxOutsideTxMode(t *testing.T) {
+	_, conn := startBroker(t)
+	for name, end := range map[string]func(*amqp091.Channel) error{
+		"commit":   (*amqp091.Channel).TxCommit,
+		"rollback": (*amqp091.Channel).TxRollback,
+	} {
+		ch, err := conn.Channel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := end(ch); !isReplyCode(err, amqp091.PreconditionFailed) {
+			t.Errorf("%s outside tx mode: %v", name, err)
+		}
+	}
+}
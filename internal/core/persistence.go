@@ -1,61 +1,564 @@
 package core
 
-// func (b *VHost) saveMessage(queueName string, msg Message) error {
-// wd, err := os.Getwd()
-// dir := filepath.Join(wd, "data", "queues", queueName)
-// if err := os.MkdirAll(dir, 0755); err != nil {
-// 	return err
-// }
-// file := filepath.Join(dir, msg.ID+".json")
-// data, err := json.Marshal(msg)
-// if err != nil {
-// 	return err
-// }
-// return os.WriteFile(file, data, 0644)
-// 	return nil
-// }
-
-// func (b *VHost) loadMessages(queueName string) ([]Message, error) {
-// dir := filepath.Join("data", "queues", queueName)
-// files, err := os.ReadDir(dir)
-// if err != nil {
-// 	return nil, err
-// }
-// var messages []Message
-// for _, file := range files {
-// 	data, err := os.ReadFile(filepath.Join(dir, file.Name()))
-// 	if err != nil {
-// 		return nil, err
-// 	}
-// 	var msg Message
-// 	if err := json.Unmarshal(data, &msg); err != nil {
-// 		return nil, err
-// 	}
-// 	messages = append(messages, msg)
-// }
-// return messages, nil
-// return nil, nil
-// }
-
-// func (b *VHost) saveBrokerState() error {
-// data, err := json.Marshal(b)
-// if err != nil {
-// 	return err
-// }
-// // get the current directory
-// wd, err := os.Getwd()
-// brokerFile := filepath.Join(wd, "data", "broker_state.json")
-// err = os.MkdirAll(filepath.Dir(brokerFile), 0755)
-// return os.WriteFile(brokerFile, data, 0644)
-// return nil
-// }
-
-// func (b *VHost) loadBrokerState() error {
-// brokerFile := filepath.Join("data", "broker_state.json")
-// data, err := os.ReadFile(brokerFile)
-// if err != nil {
-// 	return err
-// }
-// return json.Unmarshal(data, b)
-// return nil
-// }
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/connection/shared"
+)
+
+// MessageStore keeps the persistent messages of durable queues on disk.
+//
+// Messages are appended to segment files. The index is an append-only log
+// telling which queue holds which message record, and which of them were
+// deleted since. A segment file is removed once none of its messages is
+// left in a queue.
+type MessageStore struct {
+	dir string
+	mu  sync.Mutex
+
+	segment     *os.File
+	segmentID   uint32
+	segmentSize int64
+	index       *os.File
+
+	entries map[storeKey]*storeEntry
+	refs    map[uint32]int // live entries by segment
+	seq     uint64
+}
+
+const (
+	maxSegmentSize = 16 << 20
+	segmentExt     = ".seg"
+	indexFile      = "index"
+
+	indexAdd    byte = 1
+	indexDelete byte = 2
+)
+
+type storeKey struct {
+	vhost string
+	queue string
+	id    string
+}
+
+type storeEntry struct {
+	segment uint32
+	offset  int64
+	seq     uint64 // position in the queue
+}
+
+// OpenMessageStore opens the store in dir, creating it if needed. The index
+// is replayed and compacted, and the segments without live messages are
+// removed.
+func OpenMessageStore(dir string) (*MessageStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &MessageStore{
+		dir:     dir,
+		entries: make(map[storeKey]*storeEntry),
+		refs:    make(map[uint32]int),
+	}
+	if err := s.replayIndex(); err != nil {
+		return nil, err
+	}
+	if err := s.compactIndex(); err != nil {
+		return nil, err
+	}
+	lastSegment, err := s.removeUnusedSegments()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.openSegment(lastSegment + 1); err != nil {
+		return nil, err
+	}
+	log.Printf("Message store opened with %d message(s)", len(s.entries))
+	return s, nil
+}
+
+// Close closes the segment and index files
+func (s *MessageStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.segment.Close()
+	if indexErr := s.index.Close(); err == nil {
+		err = indexErr
+	}
+	return err
+}
+
+// Write stores the message for the queues of the vhost. The message is on
+// disk when Write returns.
+func (s *MessageStore) Write(vhost string, queues []string, msg amqp.Message) error {
+	record, err := encodeStoredMessage(msg)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.segmentSize > 0 && s.segmentSize+int64(len(record)) > maxSegmentSize {
+		if err := s.segment.Close(); err != nil {
+			return err
+		}
+		if err := s.openSegment(s.segmentID + 1); err != nil {
+			return err
+		}
+	}
+	offset := s.segmentSize
+	if _, err := s.segment.Write(frameRecord(record)); err != nil {
+		return err
+	}
+	s.segmentSize += int64(len(record)) + 8
+	if err := s.segment.Sync(); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, queue := range queues {
+		buf.Write(frameRecord(encodeIndexRecord(indexAdd, s.segmentID, offset, storeKey{vhost, queue, msg.ID})))
+	}
+	if _, err := s.index.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := s.index.Sync(); err != nil {
+		return err
+	}
+	for _, queue := range queues {
+		s.add(storeKey{vhost, queue, msg.ID}, s.segmentID, offset)
+	}
+	return nil
+}
+
+// Delete marks the message of the queue as deleted. Unknown messages are
+// ignored.
+func (s *MessageStore) Delete(vhost, queue, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.delete(storeKey{vhost, queue, id})
+}
+
+// DeleteQueue marks every message of the queue as deleted
+func (s *MessageStore) DeleteQueue(vhost, queue string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.entries {
+		if key.vhost == vhost && key.queue == queue {
+			if err := s.delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Queues returns the names of the queues of the vhost holding messages
+func (s *MessageStore) Queues(vhost string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[string]bool)
+	queues := make([]string, 0)
+	for key := range s.entries {
+		if key.vhost == vhost && !seen[key.queue] {
+			seen[key.queue] = true
+			queues = append(queues, key.queue)
+		}
+	}
+	sort.Strings(queues)
+	return queues
+}
+
+// Messages reads the messages of the queue in publishing order
+func (s *MessageStore) Messages(vhost, queue string) ([]amqp.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]*storeEntry, 0)
+	for key, entry := range s.entries {
+		if key.vhost == vhost && key.queue == queue {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seq < entries[j].seq
+	})
+
+	files := make(map[uint32]*os.File)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	messages := make([]amqp.Message, 0, len(entries))
+	for _, entry := range entries {
+		f, ok := files[entry.segment]
+		if !ok {
+			var err error
+			f, err = os.Open(s.segmentPath(entry.segment))
+			if err != nil {
+				return nil, err
+			}
+			files[entry.segment] = f
+		}
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		// a message larger than a segment is alone in its own, so the
+		// record is only bounded by the end of the file
+		size := info.Size() - entry.offset
+		record, err := readRecord(io.NewSectionReader(f, entry.offset, size), size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message at %d in segment %d: %v", entry.offset, entry.segment, err)
+		}
+		msg, err := decodeStoredMessage(record)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func (s *MessageStore) add(key storeKey, segment uint32, offset int64) {
+	if _, ok := s.entries[key]; ok {
+		return
+	}
+	s.seq++
+	s.entries[key] = &storeEntry{segment: segment, offset: offset, seq: s.seq}
+	s.refs[segment]++
+}
+
+// delete must be called with the store mutex held
+func (s *MessageStore) delete(key storeKey) error {
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	if _, err := s.index.Write(frameRecord(encodeIndexRecord(indexDelete, 0, 0, key))); err != nil {
+		return err
+	}
+	delete(s.entries, key)
+	s.refs[entry.segment]--
+	if s.refs[entry.segment] == 0 {
+		delete(s.refs, entry.segment)
+		if entry.segment != s.segmentID {
+			if err := os.Remove(s.segmentPath(entry.segment)); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove segment %d: %v", entry.segment, err)
+			}
+		}
+	}
+	return nil
+}
+
+// replayIndex rebuilds the live entries from the index. A torn record at
+// the end of the index, left by a crash, is ignored.
+func (s *MessageStore) replayIndex() error {
+	f, err := os.Open(filepath.Join(s.dir, indexFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	for {
+		record, err := readRecord(reader, maxSegmentSize)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			log.Printf("Ignoring the end of the message index: %v", err)
+			return nil
+		}
+		op, segment, offset, key, err := decodeIndexRecord(record)
+		if err != nil {
+			log.Printf("Ignoring the end of the message index: %v", err)
+			return nil
+		}
+		switch op {
+		case indexAdd:
+			s.add(key, segment, offset)
+		case indexDelete:
+			if entry, ok := s.entries[key]; ok {
+				delete(s.entries, key)
+				s.refs[entry.segment]--
+				if s.refs[entry.segment] == 0 {
+					delete(s.refs, entry.segment)
+				}
+			}
+		}
+	}
+}
+
+// compactIndex rewrites the index with the live entries only
+func (s *MessageStore) compactIndex() error {
+	keys := make([]storeKey, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return s.entries[keys[i]].seq < s.entries[keys[j]].seq
+	})
+
+	path := filepath.Join(s.dir, indexFile)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	for _, key := range keys {
+		entry := s.entries[key]
+		if _, err := writer.Write(frameRecord(encodeIndexRecord(indexAdd, entry.segment, entry.offset, key))); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	s.index, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	return err
+}
+
+// removeUnusedSegments deletes the segments without live entries and
+// returns the highest segment id found
+func (s *MessageStore) removeUnusedSegments() (uint32, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+	var last uint32
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 32)
+		if err != nil {
+			continue
+		}
+		if uint32(id) > last {
+			last = uint32(id)
+		}
+		if s.refs[uint32(id)] == 0 {
+			if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return last, nil
+}
+
+func (s *MessageStore) openSegment(id uint32) error {
+	f, err := os.OpenFile(s.segmentPath(id), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.segment = f
+	s.segmentID = id
+	s.segmentSize = info.Size()
+	return nil
+}
+
+func (s *MessageStore) segmentPath(id uint32) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%08d%s", id, segmentExt))
+}
+
+// Records are framed as:
+// 0-3: payload length (uint32)
+// 4-7: payload CRC-32 (uint32)
+// 8-: payload
+func frameRecord(payload []byte) []byte {
+	framed := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint32(framed[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(framed[4:8], crc32.ChecksumIEEE(payload))
+	copy(framed[8:], payload)
+	return framed
+}
+
+// readRecord reads a record of at most maxLength bytes, its header included
+func readRecord(r io.Reader, maxLength int64) ([]byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated record header")
+		}
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if int64(length)+8 > maxLength {
+		return nil, fmt.Errorf("record too large: %d", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, errors.New("truncated record")
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errors.New("record checksum mismatch")
+	}
+	return payload, nil
+}
+
+// Index record payload:
+// 0: operation (add or delete)
+// 1-4: segment (uint32)
+// 5-12: offset in the segment (uint64)
+// 13-: vhost, queue and message id (strings)
+func encodeIndexRecord(op byte, segment uint32, offset int64, key storeKey) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(op)
+	binary.Write(&buf, binary.BigEndian, segment)
+	binary.Write(&buf, binary.BigEndian, uint64(offset))
+	writeStoreString(&buf, key.vhost)
+	writeStoreString(&buf, key.queue)
+	writeStoreString(&buf, key.id)
+	return buf.Bytes()
+}
+
+func decodeIndexRecord(record []byte) (byte, uint32, int64, storeKey, error) {
+	var key storeKey
+	if len(record) < 13 {
+		return 0, 0, 0, key, errors.New("index record too short")
+	}
+	op := record[0]
+	segment := binary.BigEndian.Uint32(record[1:5])
+	offset := int64(binary.BigEndian.Uint64(record[5:13]))
+	r := bytes.NewReader(record[13:])
+	var err error
+	if key.vhost, err = readStoreString(r); err != nil {
+		return 0, 0, 0, key, err
+	}
+	if key.queue, err = readStoreString(r); err != nil {
+		return 0, 0, 0, key, err
+	}
+	if key.id, err = readStoreString(r); err != nil {
+		return 0, 0, 0, key, err
+	}
+	return op, segment, offset, key, nil
+}
+
+// Message record payload:
+// id, exchange and routing key (strings)
+// property flags (uint16) and properties (bytes)
+// body (bytes)
+// time the message was enqueued at, in Unix nanoseconds (int64), 0 when
+// unset
+func encodeStoredMessage(msg amqp.Message) ([]byte, error) {
+	props, flags, err := msg.Properties.EncodeBasicProperties()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writeStoreString(&buf, msg.ID)
+	writeStoreString(&buf, msg.Exchange)
+	writeStoreString(&buf, msg.RoutingKey)
+	binary.Write(&buf, binary.BigEndian, flags)
+	writeStoreBytes(&buf, props)
+	writeStoreBytes(&buf, msg.Body)
+	var enqueuedAt int64
+	if !msg.EnqueuedAt.IsZero() {
+		enqueuedAt = msg.EnqueuedAt.UnixNano()
+	}
+	binary.Write(&buf, binary.BigEndian, enqueuedAt)
+	return buf.Bytes(), nil
+}
+
+func decodeStoredMessage(record []byte) (amqp.Message, error) {
+	var msg amqp.Message
+	r := bytes.NewReader(record)
+	var err error
+	if msg.ID, err = readStoreString(r); err != nil {
+		return msg, err
+	}
+	if msg.Exchange, err = readStoreString(r); err != nil {
+		return msg, err
+	}
+	if msg.RoutingKey, err = readStoreString(r); err != nil {
+		return msg, err
+	}
+	var flags uint16
+	if err := binary.Read(r, binary.BigEndian, &flags); err != nil {
+		return msg, err
+	}
+	encodedProps, err := readStoreBytes(r)
+	if err != nil {
+		return msg, err
+	}
+	props, err := shared.DecodeBasicProperties(flags, encodedProps)
+	if err != nil {
+		return msg, err
+	}
+	msg.Properties = *props
+	if msg.Body, err = readStoreBytes(r); err != nil {
+		return msg, err
+	}
+	var enqueuedAt int64
+	if err := binary.Read(r, binary.BigEndian, &enqueuedAt); err != nil {
+		return msg, err
+	}
+	if enqueuedAt != 0 {
+		msg.EnqueuedAt = time.Unix(0, enqueuedAt)
+	}
+	return msg, nil
+}
+
+func writeStoreString(buf *bytes.Buffer, value string) {
+	writeStoreBytes(buf, []byte(value))
+}
+
+func writeStoreBytes(buf *bytes.Buffer, value []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(value)))
+	buf.Write(value)
+}
+
+func readStoreString(r *bytes.Reader) (string, error) {
+	value, err := readStoreBytes(r)
+	return string(value), err
+}
+
+func readStoreBytes(r *bytes.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if int(length) > r.Len() {
+		return nil, errors.New("truncated field")
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
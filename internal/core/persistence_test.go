@@ -1,97 +1,134 @@
 package core
 
-// import (
-// 	"testing"
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
 
-// 	"github.com/andrelcunha/ottermq/config"
-// )
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp/message"
+)
 
-// var conf = &config.Config{
-// 	Port:              "5672",
-// 	Host:              "localhost",
-// 	HeartBeatInterval: 5,
-// }
+var enqueuedAt = time.Unix(1700000000, 123)
 
-// func TestSaveMessage(t *testing.T) {
+func persistentMessage(id, body string) amqp.Message {
+	return amqp.Message{
+		ID:         id,
+		Body:       []byte(body),
+		Exchange:   "",
+		RoutingKey: "q",
+		EnqueuedAt: enqueuedAt,
+		Properties: message.BasicProperties{
+			DeliveryMode: 2,
+			ContentType:  "text/plain",
+			Headers:      map[string]interface{}{"n": int32(1)},
+		},
+	}
+}
 
-// b := NewBroker(conf)
-// queueName := "testQueue"
-// msg := Message{
-// 	ID:      "testMessage1",
-// 	Content: "Hello, world!",
-// }
-// err := b.saveMessage(queueName, msg)
-// if err != nil {
-// 	t.Fatalf("Failed to save message: %v", err)
-// }
+func TestMessageStoreRecovery(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenMessageStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"m1", "m2", "m3"} {
+		if err := store.Write("/", []string{"q", "other"}, persistentMessage(id, "body-"+id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete("/", "q", "m2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteQueue("/", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
 
-// // Verify the message file exists
-// file := filepath.Join("data", "queues", queueName, "testMessage1.json")
-// if _, err := os.Stat(file); os.IsNotExist(err) {
-// 	t.Fatalf("Expected message file %s to exist", file)
-// }
-// }
+	store, err = OpenMessageStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if queues := store.Queues("/"); len(queues) != 1 || queues[0] != "q" {
+		t.Fatalf("Queues() = %v, want [q]", queues)
+	}
+	messages, err := store.Messages("/", "q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].ID != "m1" || messages[1].ID != "m3" {
+		t.Fatalf("Messages() = %v, want m1 and m3", messages)
+	}
+	msg := messages[1]
+	if string(msg.Body) != "body-m3" || msg.RoutingKey != "q" || msg.Properties.ContentType != "text/plain" ||
+		msg.Properties.DeliveryMode != 2 || msg.Properties.Headers["n"] != int32(1) || !msg.EnqueuedAt.Equal(enqueuedAt) {
+		t.Errorf("recovered message = %+v", msg)
+	}
+}
 
-// func TestLoadMessages(t *testing.T) {
-// // Remove the data/queues directory to ensure a clean state
-// err := os.RemoveAll(filepath.Join("data", "queues"))
-// if err != nil {
-// 	t.Fatalf("Failed to remove data/queues directory: %v", err)
-// }
+func TestMessageStoreIgnoresTornIndex(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenMessageStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Write("/", []string{"q"}, persistentMessage("m1", "body")); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
 
-// b := NewBroker(conf)
-// queueName := "testQueue"
-// msg := Message{
-// 	ID:      "testMessage1",
-// 	Content: "Hello, world!",
-// }
-// // Save a message to load later
-// b.saveMessage(queueName, msg)
+	// a crash in the middle of an index write leaves a partial record
+	index, err := os.OpenFile(filepath.Join(dir, indexFile), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Write([]byte{0, 0, 0, 40, 1, 2})
+	index.Close()
 
-// messages, err := b.loadMessages(queueName)
-// if err != nil {
-// 	t.Fatalf("Failed to load messages: %v", err)
-// }
-// if len(messages) < 1 {
-// 	t.Fatalf("Expected 1 message, but got %d", len(messages))
-// }
-// if messages[0].Content != "Hello, world!" {
-// 	t.Fatalf("Expected message content 'Hello, world!', but got '%s'", messages[0].Content)
-// }
-// }
+	store, err = OpenMessageStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	messages, err := store.Messages("/", "q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].ID != "m1" {
+		t.Fatalf("Messages() = %v, want m1", messages)
+	}
+}
 
-// func TestSaveBrokerState(t *testing.T) {
-// b := NewBroker(conf)
-// b.createQueue("testQueue")
-// err := b.saveBrokerState()
-// if err != nil {
-// 	t.Fatalf("Failed to save broker state: %v", err)
-// }
+func TestMessageStoreRecoversMessagesLargerThanASegment(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenMessageStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := persistentMessage("large", strings.Repeat("x", maxSegmentSize+1024))
+	for _, msg := range []amqp.Message{persistentMessage("m1", "before"), large, persistentMessage("m2", "after")} {
+		if err := store.Write("/", []string{"q"}, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
 
-// // Verify the broker state file exists
-// file := filepath.Join("data", "broker_state.json")
-// if _, err := os.Stat(file); os.IsNotExist(err) {
-// 	t.Fatalf("Expected broker state file %s to exist", file)
-// }
-// }
-
-// func TestLoadBrokerState(t *testing.T) {
-// b := NewBroker(conf)
-// b.createQueue("testQueue")
-// err := b.saveBrokerState()
-// if err != nil {
-// 	t.Fatalf("Failed to save broker state: %v", err)
-// }
-
-// // Create a new broker instance and load the state
-// b2 := NewBroker(conf)
-// err = b2.loadBrokerState()
-// if err != nil {
-// 	t.Fatalf("Failed to load broker state: %v", err)
-// }
-
-// // Verify the state was loaded correctly
-// if _, ok := b2.Queues["testQueue"]; !ok {
-// 	t.Fatalf("Expected queue 'testQueue' to exist after loading state")
-// }
-// }
+	store, err = OpenMessageStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	messages, err := store.Messages("/", "q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 || messages[1].ID != "large" || len(messages[1].Body) != len(large.Body) ||
+		string(messages[2].Body) != "after" {
+		t.Fatalf("recovered %d messages, want m1, large and m2", len(messages))
+	}
+}
@@ -0,0 +1,137 @@
+package vhost
+
+import (
+	"log"
+	"net"
+	"sort"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/connection/constants"
+)
+
+// Ack acknowledges the delivery tag on the channel. With multiple set, every
+// unacked message up to and including the tag is acknowledged; a zero tag
+// with multiple acknowledges all of them.
+func (vh *VHost) Ack(conn net.Conn, channel uint16, deliveryTag uint64, multiple bool) error {
+	entries, err := vh.takeUnacked(conn, channel, deliveryTag, multiple)
+	if err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] Acknowledged %d message(s) on channel %d", len(entries), channel)
+	vh.forgetUnacked(entries)
+	vh.releaseUnacked(SessionID(conn), channel, entries)
+	return nil
+}
+
+// Nack rejects one or more deliveries. Rejected messages are either put back
+// at the head of their queue or dead-lettered.
+func (vh *VHost) Nack(conn net.Conn, channel uint16, deliveryTag uint64, multiple, requeue bool) error {
+	entries, err := vh.takeUnacked(conn, channel, deliveryTag, multiple)
+	if err != nil {
+		return err
+	}
+	if requeue {
+		vh.requeueUnacked(entries)
+	} else {
+		log.Printf("[DEBUG] Discarded %d rejected message(s) on channel %d", len(entries), channel)
+		vh.rejectUnacked(entries)
+	}
+	vh.releaseUnacked(SessionID(conn), channel, entries)
+	return nil
+}
+
+// Reject rejects a single delivery
+func (vh *VHost) Reject(conn net.Conn, channel uint16, deliveryTag uint64, requeue bool) error {
+	return vh.Nack(conn, channel, deliveryTag, false, requeue)
+}
+
+// takeUnacked removes the deliveries matched by the tag from the channel's
+// ledger and returns them ordered by delivery tag.
+func (vh *VHost) takeUnacked(conn net.Conn, channel uint16, deliveryTag uint64, multiple bool) ([]*UnackedMessage, error) {
+	vh.mu.Lock()
+	state := vh.getChannelDeliveryState(SessionID(conn), channel)
+	vh.mu.Unlock()
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return takeFromLedger(state.Unacked, deliveryTag, multiple)
+}
+
+// takeFromLedger removes the deliveries matched by the tag from the ledger
+func takeFromLedger(ledger map[uint64]*UnackedMessage, deliveryTag uint64, multiple bool) ([]*UnackedMessage, error) {
+	if !multiple || deliveryTag != 0 {
+		if _, ok := ledger[deliveryTag]; !ok {
+			return nil, amqp.NewError(constants.PRECONDITION_FAILED, "unknown delivery tag %d", deliveryTag)
+		}
+	}
+	if !multiple {
+		entry := ledger[deliveryTag]
+		delete(ledger, deliveryTag)
+		return []*UnackedMessage{entry}, nil
+	}
+
+	entries := make([]*UnackedMessage, 0)
+	for tag, entry := range ledger {
+		if deliveryTag == 0 || tag <= deliveryTag {
+			entries = append(entries, entry)
+			delete(ledger, tag)
+		}
+	}
+	sortUnacked(entries)
+	return entries, nil
+}
+
+// requeueUnacked puts the messages back at the head of their original queues,
+// keeping their original order, and marks them as redelivered.
+func (vh *VHost) requeueUnacked(entries []*UnackedMessage) {
+	queues := make(map[*Queue]bool)
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		msg := entry.Message
+		msg.Redelivered = true
+		entry.Queue.ReQueue(msg)
+		queues[entry.Queue] = true
+	}
+	for queue := range queues {
+		vh.scheduleExpiry(queue)
+		vh.dispatch(queue)
+	}
+}
+
+// releaseUnacked frees the prefetch slots held by the deliveries and resumes
+// the consumers of the channel.
+func (vh *VHost) releaseUnacked(sessionID string, channel uint16, entries []*UnackedMessage) {
+	vh.mu.Lock()
+	state, ok := vh.ChannelDeliveries[channelKey(sessionID, channel)]
+	for _, entry := range entries {
+		if entry.consumer == nil {
+			continue
+		}
+		entry.consumer.unacked--
+		if ok {
+			state.consumerUnacked--
+		}
+	}
+	vh.mu.Unlock()
+	vh.resumeChannel(sessionID, channel)
+}
+
+func sortUnacked(entries []*UnackedMessage) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeliveryTag < entries[j].DeliveryTag
+	})
+}
+
+// drainUnacked empties the channel's ledger and returns its deliveries
+// ordered by delivery tag.
+func (state *ChannelDeliveryState) drainUnacked() []*UnackedMessage {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	entries := make([]*UnackedMessage, 0, len(state.Unacked))
+	for _, entry := range state.Unacked {
+		entries = append(entries, entry)
+	}
+	state.Unacked = make(map[uint64]*UnackedMessage)
+	sortUnacked(entries)
+	return entries
+}
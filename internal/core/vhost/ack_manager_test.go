@@ -0,0 +1,146 @@
+package vhost
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/connection/constants"
+)
+
+// replyCode returns the reply code of an amqp error, 0 for any other error
+func replyCode(err error) constants.ReplyCode {
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) {
+		return amqpErr.ReplyCode
+	}
+	return 0
+}
+
+// newTestQueue declares the queue and pushes messages with the given ids
+func newTestQueue(t *testing.T, vh *VHost, name string, ids ...string) *Queue {
+	queue, err := vh.DeclareQueue(nil, name, false, QueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		queue.Push(amqp.Message{ID: id})
+	}
+	return queue
+}
+
+// get gets the next message of the queue on the channel, acknowledged later
+func get(t *testing.T, vh *VHost, channel uint16, queue string) (*amqp.Message, uint64) {
+	msg, tag, err := vh.GetMessage(nil, channel, queue, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg == nil {
+		t.Fatalf("queue %s is empty", queue)
+	}
+	return msg, tag
+}
+
+// unackedTags returns the delivery tags left in the channel's ledger
+func unackedTags(vh *VHost, channel uint16) []uint64 {
+	vh.mu.Lock()
+	state := vh.getChannelDeliveryState(SessionID(nil), channel)
+	vh.mu.Unlock()
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	tags := make([]uint64, 0, len(state.Unacked))
+	for tag := range state.Unacked {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	return tags
+}
+
+func TestAckMultiple(t *testing.T) {
+	vh := NewVhost("/")
+	newTestQueue(t, vh, "q", "m1", "m2", "m3", "m4", "m5")
+	for want := uint64(1); want <= 5; want++ {
+		if _, tag := get(t, vh, 1, "q"); tag != want {
+			t.Fatalf("delivery tag %d, want %d", tag, want)
+		}
+	}
+
+	if err := vh.Ack(nil, 1, 3, true); err != nil {
+		t.Fatal(err)
+	}
+	if tags := unackedTags(vh, 1); len(tags) != 2 || tags[0] != 4 || tags[1] != 5 {
+		t.Fatalf("unacked tags %v after acking up to 3, want [4 5]", tags)
+	}
+	if err := vh.Ack(nil, 1, 0, true); err != nil {
+		t.Fatal(err)
+	}
+	if tags := unackedTags(vh, 1); len(tags) != 0 {
+		t.Fatalf("unacked tags %v after acking all", tags)
+	}
+}
+
+func TestAckUnknownTag(t *testing.T) {
+	vh := NewVhost("/")
+	newTestQueue(t, vh, "q", "m1", "m2")
+	_, tag := get(t, vh, 1, "q")
+
+	if err := vh.Ack(nil, 1, tag+1, false); replyCode(err) != constants.PRECONDITION_FAILED {
+		t.Fatalf("acking an unknown tag: %v", err)
+	}
+	if err := vh.Ack(nil, 1, tag, false); err != nil {
+		t.Fatal(err)
+	}
+	// a tag cannot be acknowledged twice
+	if err := vh.Ack(nil, 1, tag, false); replyCode(err) != constants.PRECONDITION_FAILED {
+		t.Fatalf("acking a tag twice: %v", err)
+	}
+	// nor on another channel
+	_, tag = get(t, vh, 1, "q")
+	if err := vh.Reject(nil, 2, tag, true); replyCode(err) != constants.PRECONDITION_FAILED {
+		t.Fatalf("rejecting the tag of another channel: %v", err)
+	}
+}
+
+func TestNackAndRejectRequeue(t *testing.T) {
+	vh := NewVhost("/")
+	newTestQueue(t, vh, "q", "m1", "m2")
+
+	msg, tag := get(t, vh, 1, "q")
+	if msg.Redelivered {
+		t.Fatal("first delivery marked redelivered")
+	}
+	if err := vh.Nack(nil, 1, tag, false, true); err != nil {
+		t.Fatal(err)
+	}
+	msg, tag = get(t, vh, 1, "q")
+	if msg.ID != "m1" || !msg.Redelivered {
+		t.Fatalf("after nack got %s redelivered=%v, want m1 redelivered", msg.ID, msg.Redelivered)
+	}
+	if err := vh.Reject(nil, 1, tag, true); err != nil {
+		t.Fatal(err)
+	}
+	msg, _ = get(t, vh, 1, "q")
+	if msg.ID != "m1" || !msg.Redelivered {
+		t.Fatalf("after reject got %s redelivered=%v, want m1 redelivered", msg.ID, msg.Redelivered)
+	}
+}
+
+func TestCleanupChannelRequeuesInOrder(t *testing.T) {
+	vh := NewVhost("/")
+	queue := newTestQueue(t, vh, "q", "m1", "m2", "m3", "m4")
+	for i := 0; i < 3; i++ {
+		get(t, vh, 1, "q")
+	}
+
+	vh.CleanupChannel(nil, 1)
+	for _, id := range []string{"m1", "m2", "m3"} {
+		msg := queue.Pop()
+		if msg == nil || msg.ID != id || !msg.Redelivered {
+			t.Fatalf("popped %+v, want %s redelivered", msg, id)
+		}
+	}
+	if msg := queue.Pop(); msg == nil || msg.ID != "m4" || msg.Redelivered {
+		t.Fatalf("popped %+v, want m4 not redelivered", msg)
+	}
+}
@@ -0,0 +1,356 @@
+package vhost
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp/message"
+	"github.com/andrelcunha/ottermq/pkg/connection/constants"
+	"github.com/andrelcunha/ottermq/pkg/connection/shared"
+	"github.com/google/uuid"
+)
+
+// SessionID identifies the connection a consumer belongs to. A nil
+// connection is the broker itself, e.g. importing definitions.
+func SessionID(conn net.Conn) string {
+	if conn == nil {
+		return ""
+	}
+	return conn.RemoteAddr().String()
+}
+
+func consumerKey(sessionID string, channel uint16, consumerTag string) string {
+	return fmt.Sprintf("%s/%d/%s", sessionID, channel, consumerTag)
+}
+
+func channelKey(sessionID string, channel uint16) string {
+	return fmt.Sprintf("%s/%d", sessionID, channel)
+}
+
+// RegisterConsumer subscribes a new consumer to the queue. The consumer does not
+// receive messages until StartConsumer is called, so the caller can reply
+// basic.consume-ok before the first basic.deliver.
+func (vh *VHost) RegisterConsumer(conn net.Conn, channel uint16, queueName, consumerTag string, noAck, exclusive bool) (*Consumer, error) {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	queue, ok := vh.Queues[queueName]
+	if !ok {
+		return nil, amqp.NewError(constants.NOT_FOUND, "no queue '%s' in vhost '%s'", queueName, vh.Name)
+	}
+	sessionID := SessionID(conn)
+	if err := vh.checkQueueOwner(queue, sessionID); err != nil {
+		return nil, err
+	}
+	for _, c := range queue.consumers {
+		if c.Exclusive {
+			return nil, amqp.NewError(constants.ACCESS_REFUSED, "queue '%s' in vhost '%s' in exclusive use", queueName, vh.Name)
+		}
+	}
+	if exclusive && len(queue.consumers) > 0 {
+		return nil, amqp.NewError(constants.ACCESS_REFUSED, "cannot obtain exclusive access to locked queue '%s' in vhost '%s'", queueName, vh.Name)
+	}
+
+	if consumerTag == "" {
+		consumerTag = "amq.ctag-" + uuid.New().String()
+	}
+	key := consumerKey(sessionID, channel, consumerTag)
+	if _, ok := vh.Consumers[key]; ok {
+		return nil, amqp.NewError(constants.NOT_ALLOWED, "attempt to reuse consumer tag '%s'", consumerTag)
+	}
+
+	state := vh.getChannelDeliveryState(sessionID, channel)
+	consumer := &Consumer{
+		ID:            consumerTag,
+		Queue:         queueName,
+		SessionID:     sessionID,
+		Channel:       channel,
+		NoAck:         noAck,
+		Exclusive:     exclusive,
+		PrefetchCount: state.PrefetchCount,
+		Conn:          conn,
+	}
+	vh.Consumers[key] = consumer
+	if vh.ConsumerSessions[sessionID] == nil {
+		vh.ConsumerSessions[sessionID] = make(map[string]bool)
+	}
+	vh.ConsumerSessions[sessionID][key] = true
+	queue.consumers = append(queue.consumers, consumer)
+	return consumer, nil
+}
+
+// GetConsumers returns a snapshot of the consumers of the vhost
+func (vh *VHost) GetConsumers() []Consumer {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	consumers := make([]Consumer, 0, len(vh.Consumers))
+	for _, consumer := range vh.Consumers {
+		consumers = append(consumers, *consumer)
+	}
+	return consumers
+}
+
+// StartConsumer activates a registered consumer and pushes any message
+// already waiting in its queue.
+func (vh *VHost) StartConsumer(consumer *Consumer) {
+	vh.mu.Lock()
+	consumer.active = true
+	queue, ok := vh.Queues[consumer.Queue]
+	vh.mu.Unlock()
+	if ok {
+		vh.dispatch(queue)
+	}
+}
+
+// CancelConsumer removes the consumer with the given tag from the channel.
+func (vh *VHost) CancelConsumer(conn net.Conn, channel uint16, consumerTag string) error {
+	defer vh.writeTopology()
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	key := consumerKey(SessionID(conn), channel, consumerTag)
+	if _, ok := vh.Consumers[key]; !ok {
+		return fmt.Errorf("consumer %s not found", consumerTag)
+	}
+	vh.removeConsumer(key)
+	return nil
+}
+
+// removeConsumer must be called with the vhost mutex held
+func (vh *VHost) removeConsumer(key string) {
+	consumer, ok := vh.Consumers[key]
+	if !ok {
+		return
+	}
+	if queue, ok := vh.Queues[consumer.Queue]; ok {
+		for i, c := range queue.consumers {
+			if c == consumer {
+				queue.consumers = append(queue.consumers[:i], queue.consumers[i+1:]...)
+				break
+			}
+		}
+		// an auto-delete queue goes away with its last consumer
+		if queue.AutoDelete && len(queue.consumers) == 0 {
+			vh.removeQueue(queue)
+		}
+	}
+	consumer.active = false
+	delete(vh.Consumers, key)
+	delete(vh.ConsumerSessions[consumer.SessionID], key)
+	if len(vh.ConsumerSessions[consumer.SessionID]) == 0 {
+		delete(vh.ConsumerSessions, consumer.SessionID)
+	}
+}
+
+// notifyConsumerCancel tells the client that the server cancelled the
+// consumer, e.g. because its queue was deleted.
+func (vh *VHost) notifyConsumerCancel(consumer *Consumer) {
+	vh.mu.Lock()
+	state := vh.getChannelDeliveryState(consumer.SessionID, consumer.Channel)
+	vh.mu.Unlock()
+
+	frame := amqp.ResponseMethodMessage{
+		Channel:  consumer.Channel,
+		ClassID:  uint16(constants.BASIC),
+		MethodID: uint16(constants.BASIC_CANCEL),
+		Content: amqp.ContentList{
+			KeyValuePairs: []amqp.KeyValue{
+				{ // consumer_tag
+					Key:   amqp.STRING_SHORT,
+					Value: consumer.ID,
+				},
+				{ // no_wait
+					Key:   amqp.BIT,
+					Value: true,
+				},
+			},
+		},
+	}.FormatMethodFrame()
+	// do not interleave with a delivery in progress on the channel
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if err := shared.SendFrame(consumer.Conn, frame); err != nil {
+		log.Printf("Failed to notify cancellation of consumer %s: %v", consumer.ID, err)
+	}
+}
+
+// SetQos applies basic.qos to the channel. A global prefetch count is shared
+// by all consumers of the channel, otherwise it limits each consumer started
+// on the channel afterwards.
+func (vh *VHost) SetQos(conn net.Conn, channel uint16, prefetchCount uint16, global bool) {
+	vh.mu.Lock()
+	state := vh.getChannelDeliveryState(SessionID(conn), channel)
+	if global {
+		state.GlobalPrefetchCount = prefetchCount
+	} else {
+		state.PrefetchCount = prefetchCount
+	}
+	vh.mu.Unlock()
+	// a raised limit may unblock the channel's consumers
+	vh.resumeChannel(SessionID(conn), channel)
+}
+
+// pickConsumer picks the next active consumer with room for one more
+// delivery, in round-robin order, and returns its position. The caller
+// advances nextConsumer once a message was actually handed to it.
+// It must be called with the vhost mutex held.
+func (vh *VHost) pickConsumer(q *Queue) (*Consumer, int) {
+	for i := 0; i < len(q.consumers); i++ {
+		idx := (q.nextConsumer + i) % len(q.consumers)
+		consumer := q.consumers[idx]
+		if consumer.active && vh.hasPrefetchRoom(consumer) {
+			return consumer, idx
+		}
+	}
+	return nil, 0
+}
+
+// hasPrefetchRoom tells whether the consumer is below its prefetch limits.
+// It must be called with the vhost mutex held.
+func (vh *VHost) hasPrefetchRoom(consumer *Consumer) bool {
+	if consumer.NoAck {
+		return true
+	}
+	if consumer.PrefetchCount > 0 && consumer.unacked >= int(consumer.PrefetchCount) {
+		return false
+	}
+	state, ok := vh.ChannelDeliveries[channelKey(consumer.SessionID, consumer.Channel)]
+	if ok && state.GlobalPrefetchCount > 0 && state.consumerUnacked >= int(state.GlobalPrefetchCount) {
+		return false
+	}
+	return true
+}
+
+// resumeChannel dispatches to the queues consumed on the channel, after
+// deliveries were acknowledged or the prefetch limits changed.
+func (vh *VHost) resumeChannel(sessionID string, channel uint16) {
+	vh.mu.Lock()
+	queues := make(map[*Queue]bool)
+	for key := range vh.ConsumerSessions[sessionID] {
+		consumer, ok := vh.Consumers[key]
+		if !ok || consumer.Channel != channel {
+			continue
+		}
+		if queue, ok := vh.Queues[consumer.Queue]; ok {
+			queues[queue] = true
+		}
+	}
+	vh.mu.Unlock()
+	for queue := range queues {
+		vh.dispatch(queue)
+	}
+}
+
+func (vh *VHost) getChannelDeliveryState(sessionID string, channel uint16) *ChannelDeliveryState {
+	key := channelKey(sessionID, channel)
+	state, ok := vh.ChannelDeliveries[key]
+	if !ok {
+		state = &ChannelDeliveryState{Unacked: make(map[uint64]*UnackedMessage)}
+		vh.ChannelDeliveries[key] = state
+	}
+	return state
+}
+
+// dispatch pushes the queue's messages to its consumers until either the
+// queue is empty or no consumer is able to take a message.
+func (vh *VHost) dispatch(queue *Queue) {
+	for {
+		delivered, expired := vh.dispatchOne(queue)
+		// dead-lettering may dispatch again, to this very queue
+		vh.expireMessages(queue, expired)
+		if !delivered {
+			return
+		}
+	}
+}
+
+// dispatchOne hands the message at the head of the queue to the next consumer
+// able to take it and reports whether it did. It also returns the expired
+// messages it found at the head, for the caller to dead-letter. The message
+// is popped and sent under the dispatch mutex of the queue, so concurrent
+// dispatchers deliver the messages in queue order.
+func (vh *VHost) dispatchOne(queue *Queue) (bool, []amqp.Message) {
+	queue.dispatchMu.Lock()
+	defer queue.dispatchMu.Unlock()
+
+	vh.mu.Lock()
+	consumer, idx := vh.pickConsumer(queue)
+	if consumer == nil {
+		vh.mu.Unlock()
+		return false, nil
+	}
+	msg, expired := vh.popMessage(queue)
+	if msg == nil {
+		vh.mu.Unlock()
+		return false, expired
+	}
+	queue.nextConsumer = idx + 1
+	state := vh.getChannelDeliveryState(consumer.SessionID, consumer.Channel)
+	if !consumer.NoAck {
+		// hold the prefetch slot until the delivery is acknowledged
+		consumer.unacked++
+		state.consumerUnacked++
+	}
+	vh.mu.Unlock()
+
+	if err := vh.deliver(consumer, state, queue, msg); err != nil {
+		log.Printf("Failed to deliver message to consumer %s: %v", consumer.ID, err)
+		queue.ReQueue(*msg)
+		vh.scheduleExpiry(queue)
+		if !consumer.NoAck {
+			vh.mu.Lock()
+			consumer.unacked--
+			state.consumerUnacked--
+			vh.mu.Unlock()
+		}
+		return false, expired
+	}
+	if consumer.NoAck {
+		vh.forgetMessage(queue, msg)
+	}
+	return true, expired
+}
+
+// deliver sends basic.deliver followed by the content header and body frames.
+// Unless the consumer is no-ack, the message is kept in the channel's unacked
+// ledger once it was sent.
+func (vh *VHost) deliver(consumer *Consumer, state *ChannelDeliveryState, queue *Queue, msg *amqp.Message) error {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	deliveryTag := state.LastDeliveryTag + 1
+	deliver := &message.BasicDeliver{
+		ConsumerTag: consumer.ID,
+		DeliveryTag: deliveryTag,
+		Redelivered: msg.Redelivered,
+		Exchange:    msg.Exchange,
+		RoutingKey:  msg.RoutingKey,
+	}
+	frame := amqp.ResponseMethodMessage{
+		Channel:  consumer.Channel,
+		ClassID:  uint16(constants.BASIC),
+		MethodID: uint16(constants.BASIC_DELIVER),
+		Content:  *amqp.EncodeDeliverToContentList(deliver),
+	}.FormatMethodFrame()
+	responseContent := amqp.ResponseContent{
+		Channel: consumer.Channel,
+		ClassID: uint16(constants.BASIC),
+		Weight:  0,
+		Message: *msg,
+	}
+	frames := [][]byte{frame, responseContent.FormatHeaderFrame()}
+	frames = append(frames, responseContent.FormatBodyFrames(shared.FrameMax(consumer.Conn))...)
+	if err := shared.SendFrames(consumer.Conn, frames...); err != nil {
+		return err
+	}
+	state.LastDeliveryTag = deliveryTag
+	if !consumer.NoAck {
+		state.Unacked[deliveryTag] = &UnackedMessage{
+			DeliveryTag: deliveryTag,
+			ConsumerTag: consumer.ID,
+			Queue:       queue,
+			Message:     *msg,
+			consumer:    consumer,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package vhost
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/connection/constants"
+	"github.com/andrelcunha/ottermq/pkg/connection/shared"
+)
+
+// TestDispatchKeepsQueueOrder dispatches a queue from several goroutines at
+// once and checks its consumer gets the messages in the order they were
+// published.
+func TestDispatchKeepsQueueOrder(t *testing.T) {
+	const total = 2000
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	vh := NewVhost("/")
+	queue := NewQueue("q")
+	vh.Queues["q"] = queue
+	consumer, err := vh.RegisterConsumer(server, 1, "q", "c", true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vh.StartConsumer(consumer)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					vh.dispatch(queue)
+				}
+			}
+		}()
+	}
+	go func() {
+		for i := 0; i < total; i++ {
+			queue.Push(amqp.Message{ID: strconv.Itoa(i), Body: []byte(strconv.Itoa(i))})
+			vh.dispatch(queue)
+		}
+	}()
+
+	reader := shared.NewFrameReader(client, 0)
+	for next := 0; next < total; {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame[0] != byte(constants.TYPE_BODY) {
+			continue
+		}
+		if body := string(frame[7:]); body != strconv.Itoa(next) {
+			t.Fatalf("delivery %d has body %s", next, body)
+		}
+		next++
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// TestPrefetchLimit consumes with a prefetch count of 2: delivery stops after
+// two unacked messages and resumes once one is acknowledged
+func TestPrefetchLimit(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	vh := NewVhost("/")
+	queue := newTestQueue(t, vh, "q", "m1", "m2", "m3", "m4")
+	vh.SetQos(server, 1, 2, false)
+	consumer, err := vh.RegisterConsumer(server, 1, "q", "c", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sends to the pipe block until they are read
+	go vh.StartConsumer(consumer)
+
+	reader := shared.NewFrameReader(client, 0)
+	// readDelivery reads the frames of the next delivery, false when none
+	// comes in time
+	readDelivery := func() bool {
+		client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		for {
+			frame, err := reader.ReadFrame()
+			if err != nil {
+				return false
+			}
+			// the messages have no body: the header frame ends a delivery
+			if frame[0] == byte(constants.TYPE_HEADER) {
+				return true
+			}
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if !readDelivery() {
+			t.Fatalf("delivery %d not received", i+1)
+		}
+	}
+	if readDelivery() {
+		t.Fatal("third delivery over the prefetch count")
+	}
+	if queue.Len() != 2 {
+		t.Fatalf("%d message(s) left in the queue, want 2", queue.Len())
+	}
+
+	go vh.Ack(server, 1, 1, false)
+	if !readDelivery() {
+		t.Fatal("delivery not resumed after an ack")
+	}
+	if readDelivery() {
+		t.Fatal("delivery over the prefetch count after an ack")
+	}
+}
@@ -0,0 +1,158 @@
+package vhost
+
+import (
+	"log"
+	"time"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/google/uuid"
+)
+
+// reasons a message is dead-lettered for, recorded in its x-death header
+const (
+	deathRejected = "rejected" // basic.reject or basic.nack without requeue
+	deathExpired  = "expired"  // its TTL elapsed
+	deathMaxLen   = "maxlen"   // the queue went over its length limit
+)
+
+// deadLetter removes messages from the queue for good. When the queue has a
+// dead-letter exchange, they are republished to it with an x-death record of
+// the reason; messages the exchange cannot route are dropped.
+func (vh *VHost) deadLetter(queue *Queue, messages []amqp.Message, reason string) {
+	if len(messages) == 0 {
+		return
+	}
+	if !queue.hasDeadLetterExchange {
+		for i := range messages {
+			vh.forgetMessage(queue, &messages[i])
+		}
+		return
+	}
+
+	now := time.Now()
+	deadLettered := make([]amqp.Message, len(messages))
+	routes := make([][]*Queue, len(messages))
+	vh.mu.Lock()
+	for i := range messages {
+		msg := deadLetterMessage(queue, messages[i], reason, now)
+		targets, err := vh.route(msg.Exchange, msg.RoutingKey, msg.Properties.Headers)
+		if err != nil {
+			log.Printf("Failed to dead-letter message of queue %s: %v", queue.Name, err)
+		}
+		deadLettered[i] = msg
+		routes[i] = withoutDeathCycles(targets, msg.Properties.Headers)
+	}
+	vh.mu.Unlock()
+
+	touched := make(map[*Queue]bool)
+	for i, targets := range routes {
+		// the copy is on disk before the original is deleted
+		if err := vh.storeMessage(targets, deadLettered[i]); err != nil {
+			log.Printf("Failed to store dead-lettered message of queue %s: %v", queue.Name, err)
+			continue
+		}
+		for _, target := range targets {
+			if vh.enqueue(target, deadLettered[i]) {
+				touched[target] = true
+			}
+		}
+		vh.forgetMessage(queue, &messages[i])
+	}
+	for target := range touched {
+		vh.scheduleExpiry(target)
+		vh.dispatch(target)
+	}
+	log.Printf("[DEBUG] Dead-lettered %d message(s) of queue %s (%s)", len(messages), queue.Name, reason)
+}
+
+// rejectUnacked dead-letters the deliveries rejected without requeue
+func (vh *VHost) rejectUnacked(entries []*UnackedMessage) {
+	for _, entry := range entries {
+		vh.deadLetter(entry.Queue, []amqp.Message{entry.Message}, deathRejected)
+	}
+}
+
+// deadLetterMessage returns the copy of msg republished to the queue's
+// dead-letter exchange. The x-death header gets an entry per queue and
+// reason, counting how many times it happened; the latest one comes first.
+// The per-message TTL is dropped so the copy does not expire at once.
+func deadLetterMessage(queue *Queue, msg amqp.Message, reason string, now time.Time) amqp.Message {
+	headers := make(map[string]interface{}, len(msg.Properties.Headers)+4)
+	for key, value := range msg.Properties.Headers {
+		headers[key] = value
+	}
+
+	death := map[string]interface{}{
+		"reason":       reason,
+		"queue":        queue.Name,
+		"exchange":     msg.Exchange,
+		"routing-keys": []interface{}{msg.RoutingKey},
+		"count":        int64(1),
+		"time":         now,
+	}
+	if msg.Properties.Expiration != "" {
+		death["original-expiration"] = msg.Properties.Expiration
+	}
+	deaths := []interface{}{death}
+	previous, _ := headers["x-death"].([]interface{})
+	for _, entry := range previous {
+		table, ok := entry.(map[string]interface{})
+		if ok && table["queue"] == queue.Name && table["reason"] == reason {
+			count, _ := toInt64(table["count"])
+			death["count"] = count + 1
+			continue
+		}
+		deaths = append(deaths, entry)
+	}
+	headers["x-death"] = deaths
+	if _, ok := headers["x-first-death-reason"]; !ok {
+		headers["x-first-death-reason"] = reason
+		headers["x-first-death-queue"] = queue.Name
+		headers["x-first-death-exchange"] = msg.Exchange
+	}
+
+	routingKey := msg.RoutingKey
+	if queue.DeadLetterRoutingKey != "" {
+		routingKey = queue.DeadLetterRoutingKey
+	}
+	props := msg.Properties
+	props.Headers = headers
+	props.Expiration = ""
+	return amqp.Message{
+		ID:         uuid.New().String(),
+		Body:       msg.Body,
+		Properties: props,
+		Exchange:   queue.DeadLetterExchange,
+		RoutingKey: routingKey,
+		EnqueuedAt: now,
+	}
+}
+
+// withoutDeathCycles leaves out the queues the message was already
+// dead-lettered from, unless a consumer rejected it on the way: such a
+// message would otherwise go round forever
+func withoutDeathCycles(targets []*Queue, headers map[string]interface{}) []*Queue {
+	deaths, _ := headers["x-death"].([]interface{})
+	visited := make(map[string]bool)
+	for _, entry := range deaths {
+		table, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if table["reason"] == deathRejected {
+			return targets
+		}
+		if name, ok := table["queue"].(string); ok {
+			visited[name] = true
+		}
+	}
+	kept := targets[:0]
+	for _, target := range targets {
+		if visited[target.Name] {
+			log.Printf("Dropped message dead-lettered in a cycle through queue %s", target.Name)
+			continue
+		}
+		kept = append(kept, target)
+	}
+	return kept
+}
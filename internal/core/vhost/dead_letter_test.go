@@ -0,0 +1,60 @@
+package vhost
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp/message"
+)
+
+func TestDeadLetterMessage(t *testing.T) {
+	work := NewQueue("work")
+	work.DeadLetterExchange = "dlx"
+	retry := NewQueue("retry")
+	retry.DeadLetterExchange = ""
+	retry.DeadLetterRoutingKey = "work"
+
+	msg := amqp.Message{
+		RoutingKey: "work",
+		Properties: message.BasicProperties{Expiration: "100", Headers: map[string]interface{}{"k": "v"}},
+	}
+	now := time.Now()
+	msg = deadLetterMessage(work, msg, deathRejected, now)
+	if msg.Exchange != "dlx" || msg.RoutingKey != "work" || msg.Properties.Expiration != "" {
+		t.Fatalf("dead-lettered message = %+v", msg)
+	}
+	msg = deadLetterMessage(retry, msg, deathExpired, now)
+	msg = deadLetterMessage(work, msg, deathRejected, now)
+
+	deaths := msg.Properties.Headers["x-death"].([]interface{})
+	if len(deaths) != 2 {
+		t.Fatalf("x-death = %v, want 2 entries", deaths)
+	}
+	latest := deaths[0].(map[string]interface{})
+	if latest["queue"] != "work" || latest["reason"] != deathRejected || latest["count"] != int64(2) {
+		t.Errorf("latest x-death = %v, want work rejected twice", latest)
+	}
+	if msg.Properties.Headers["x-first-death-reason"] != deathRejected || msg.Properties.Headers["k"] != "v" {
+		t.Errorf("headers = %v", msg.Properties.Headers)
+	}
+}
+
+func TestWithoutDeathCycles(t *testing.T) {
+	q := NewQueue("q")
+	expired := map[string]interface{}{
+		"x-death": []interface{}{map[string]interface{}{"queue": "q", "reason": deathExpired}},
+	}
+	if targets := withoutDeathCycles([]*Queue{q}, expired); len(targets) != 0 {
+		t.Errorf("expiry cycle kept %v", targets)
+	}
+	rejected := map[string]interface{}{
+		"x-death": []interface{}{
+			map[string]interface{}{"queue": "q", "reason": deathExpired},
+			map[string]interface{}{"queue": "other", "reason": deathRejected},
+		},
+	}
+	if targets := withoutDeathCycles([]*Queue{q}, rejected); len(targets) != 1 {
+		t.Errorf("cycle with a rejection dropped")
+	}
+}
@@ -0,0 +1,121 @@
+package vhost
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/connection/constants"
+)
+
+// CheckExpiration validates the expiration property of a published message:
+// a number of milliseconds
+func CheckExpiration(expiration string) error {
+	if expiration == "" {
+		return nil
+	}
+	if _, ok := parseExpiration(expiration); !ok {
+		return amqp.NewError(constants.PRECONDITION_FAILED, "invalid expiration '%s'", expiration)
+	}
+	return nil
+}
+
+func parseExpiration(expiration string) (time.Duration, bool) {
+	ms, err := strconv.ParseInt(expiration, 10, 64)
+	if err != nil || ms < 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// messageExpiry returns when a message enqueued at now expires in the queue:
+// the earliest of the queue's TTL and the message's expiration. The zero time
+// means never.
+func (q *Queue) messageExpiry(msg *amqp.Message, now time.Time) time.Time {
+	var expiry time.Time
+	if q.MessageTTL >= 0 {
+		expiry = now.Add(time.Duration(q.MessageTTL) * time.Millisecond)
+	}
+	if msg.Properties.Expiration == "" {
+		return expiry
+	}
+	if ttl, ok := parseExpiration(msg.Properties.Expiration); ok {
+		if at := now.Add(ttl); expiry.IsZero() || at.Before(expiry) {
+			expiry = at
+		}
+	}
+	return expiry
+}
+
+func isExpired(msg *amqp.Message, now time.Time) bool {
+	return !msg.ExpiresAt.IsZero() && !msg.ExpiresAt.After(now)
+}
+
+// scheduleExpiry arms the queue's timer for the earliest expiry among the
+// messages at the head of its priority levels, unless it is already armed to
+// fire earlier. Only the heads are looked at: a message behind one expires
+// once it reaches the head, or when it is about to be delivered.
+func (vh *VHost) scheduleExpiry(queue *Queue) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	at := queue.nextExpiry()
+	if at.IsZero() {
+		return
+	}
+	if queue.expiryTimer != nil {
+		if !queue.expiryAt.After(at) {
+			return
+		}
+		queue.expiryTimer.Stop()
+	}
+	queue.expiryAt = at
+	queue.expiryTimer = time.AfterFunc(time.Until(at), func() {
+		vh.expireHead(queue)
+	})
+}
+
+// expireHead drops the expired messages at the head of the queue and arms
+// the timer for the next one
+func (vh *VHost) expireHead(queue *Queue) {
+	vh.mu.Lock()
+	queue.mu.Lock()
+	queue.expiryTimer = nil
+	queue.mu.Unlock()
+	if vh.Queues[queue.Name] != queue {
+		// deleted in the meantime
+		vh.mu.Unlock()
+		return
+	}
+	expired := queue.PopExpired(time.Now())
+	vh.mu.Unlock()
+
+	vh.expireMessages(queue, expired)
+	vh.scheduleExpiry(queue)
+}
+
+// popMessage pops the next message of the queue that has not expired. The
+// expired messages popped on the way are returned for expireMessages. It
+// must be called with the vhost mutex held.
+func (vh *VHost) popMessage(queue *Queue) (*amqp.Message, []amqp.Message) {
+	var expired []amqp.Message
+	now := time.Now()
+	for {
+		msg := queue.Pop()
+		if msg == nil || !isExpired(msg, now) {
+			// the new head may expire before the timer fires
+			vh.scheduleExpiry(queue)
+			return msg, expired
+		}
+		expired = append(expired, *msg)
+	}
+}
+
+// expireMessages dead-letters the expired messages of the queue
+func (vh *VHost) expireMessages(queue *Queue, expired []amqp.Message) {
+	if len(expired) == 0 {
+		return
+	}
+	log.Printf("[DEBUG] %d message(s) expired in queue %s", len(expired), queue.Name)
+	vh.deadLetter(queue, expired, deathExpired)
+}
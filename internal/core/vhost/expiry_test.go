@@ -0,0 +1,58 @@
+package vhost
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp/message"
+)
+
+func TestMessageExpiry(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		queueTTL   int
+		expiration string
+		want       time.Duration // -1 for never
+	}{
+		{"no ttl", -1, "", -1},
+		{"queue ttl", 1000, "", time.Second},
+		{"message ttl", -1, "500", 500 * time.Millisecond},
+		{"message ttl shorter", 1000, "500", 500 * time.Millisecond},
+		{"queue ttl shorter", 1000, "5000", time.Second},
+		{"zero ttl", 0, "", 0},
+	}
+	for _, tt := range tests {
+		q := NewQueue("q")
+		q.MessageTTL = tt.queueTTL
+		msg := amqp.Message{Properties: message.BasicProperties{Expiration: tt.expiration}}
+		got := q.messageExpiry(&msg, now)
+		if tt.want < 0 {
+			if !got.IsZero() {
+				t.Errorf("%s: expiry = %v, want never", tt.name, got)
+			}
+		} else if !got.Equal(now.Add(tt.want)) {
+			t.Errorf("%s: expiry = %v, want %v", tt.name, got.Sub(now), tt.want)
+		}
+	}
+}
+
+func TestPopExpired(t *testing.T) {
+	q := NewQueue("q")
+	q.Push(amqp.Message{ID: "short", Properties: message.BasicProperties{Expiration: "0"}})
+	q.Push(amqp.Message{ID: "long", Properties: message.BasicProperties{Expiration: "60000"}})
+	q.Push(amqp.Message{ID: "behind", Properties: message.BasicProperties{Expiration: "0"}})
+
+	// only the head is expired; the message behind the long-lived one waits
+	expired := q.PopExpired(time.Now().Add(time.Millisecond))
+	if len(expired) != 1 || expired[0].ID != "short" {
+		t.Fatalf("PopExpired() = %v, want [short]", expired)
+	}
+	if q.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", q.Len())
+	}
+	if err := CheckExpiration("later"); err == nil {
+		t.Error("CheckExpiration(later) succeeded")
+	}
+}
@@ -0,0 +1,159 @@
+package vhost
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/connection/constants"
+)
+
+// HeadersBinding binds a queue to a headers exchange. The arguments hold the
+// header values to match and the x-match mode.
+type HeadersBinding struct {
+	Queue     *Queue
+	Arguments map[string]interface{}
+}
+
+const (
+	xMatchAll      = "all"
+	xMatchAny      = "any"
+	xMatchAllWithX = "all-with-x"
+	xMatchAnyWithX = "any-with-x"
+)
+
+// headersMatchMode returns the x-match mode of the binding arguments,
+// defaulting to "all".
+func headersMatchMode(args map[string]interface{}) (string, error) {
+	value, ok := args["x-match"]
+	if !ok {
+		return xMatchAll, nil
+	}
+	mode, _ := value.(string)
+	switch mode {
+	case xMatchAll, xMatchAny, xMatchAllWithX, xMatchAnyWithX:
+		return mode, nil
+	}
+	return "", amqp.NewError(constants.PRECONDITION_FAILED, "invalid x-match value %v", value)
+}
+
+// Matches reports whether the message headers satisfy the binding. Binding
+// arguments starting with "x-" only take part in the -with-x modes, and an
+// argument with a void value only requires the header to be present.
+func (hb *HeadersBinding) Matches(headers map[string]interface{}) bool {
+	mode, err := headersMatchMode(hb.Arguments)
+	if err != nil {
+		return false
+	}
+	withX := mode == xMatchAllWithX || mode == xMatchAnyWithX
+	matchAny := mode == xMatchAny || mode == xMatchAnyWithX
+
+	for key, expected := range hb.Arguments {
+		if key == "x-match" || (!withX && strings.HasPrefix(key, "x-")) {
+			continue
+		}
+		actual, ok := headers[key]
+		matched := ok && (expected == nil || headerValuesEqual(expected, actual))
+		if matchAny && matched {
+			return true
+		}
+		if !matchAny && !matched {
+			return false
+		}
+	}
+	// every argument matched for "all", none did for "any"
+	return !matchAny
+}
+
+// headersArgsEqual tells whether two sets of binding arguments are the same.
+// An empty table and a missing one are equivalent.
+func headersArgsEqual(a, b map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		other, ok := b[key]
+		if !ok || !headerValuesEqual(value, other) {
+			return false
+		}
+	}
+	return true
+}
+
+// headerValuesEqual compares two field values, ignoring the width of
+// numeric types since clients encode the same number differently.
+func headerValuesEqual(a, b interface{}) bool {
+	if x, ok := toInt64(a); ok {
+		y, ok := toInt64(b)
+		return ok && x == y
+	}
+	if x, ok := toFloat64(a); ok {
+		y, ok := toFloat64(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// matchHeaders returns the queues whose bindings match the headers, each
+// queue at most once.
+func matchHeaders(bindings []*HeadersBinding, headers map[string]interface{}) []*Queue {
+	seen := make(map[string]bool)
+	queues := make([]*Queue, 0)
+	for _, binding := range bindings {
+		if seen[binding.Queue.Name] || !binding.Matches(headers) {
+			continue
+		}
+		seen[binding.Queue.Name] = true
+		queues = append(queues, binding.Queue)
+	}
+	return queues
+}
+
+// headersBindingKey renders the binding arguments as a sorted "key=value"
+// list, used to list the bindings of a headers exchange.
+func headersBindingKey(args map[string]interface{}) string {
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", key, args[key]))
+	}
+	return strings.Join(pairs, ",")
+}
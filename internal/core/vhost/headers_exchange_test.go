@@ -0,0 +1,30 @@
+package vhost
+
+import "testing"
+
+func TestHeadersBindingMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    map[string]interface{}
+		headers map[string]interface{}
+		want    bool
+	}{
+		{"all default", map[string]interface{}{"a": "1", "b": "2"}, map[string]interface{}{"a": "1", "b": "2", "c": "3"}, true},
+		{"all missing", map[string]interface{}{"x-match": "all", "a": "1", "b": "2"}, map[string]interface{}{"a": "1"}, false},
+		{"all empty", map[string]interface{}{"x-match": "all"}, map[string]interface{}{}, true},
+		{"any one", map[string]interface{}{"x-match": "any", "a": "1", "b": "2"}, map[string]interface{}{"b": "2"}, true},
+		{"any none", map[string]interface{}{"x-match": "any", "a": "1"}, map[string]interface{}{"a": "2"}, false},
+		{"numeric width", map[string]interface{}{"n": int32(5)}, map[string]interface{}{"n": int64(5)}, true},
+		{"void means present", map[string]interface{}{"k": nil}, map[string]interface{}{"k": "anything"}, true},
+		{"x- ignored", map[string]interface{}{"x-match": "all", "x-src": "a"}, map[string]interface{}{}, true},
+		{"all-with-x", map[string]interface{}{"x-match": "all-with-x", "x-src": "a"}, map[string]interface{}{}, false},
+		{"any-with-x", map[string]interface{}{"x-match": "any-with-x", "x-src": "a", "b": "2"}, map[string]interface{}{"x-src": "a"}, true},
+		{"invalid mode", map[string]interface{}{"x-match": "some"}, map[string]interface{}{}, false},
+	}
+	for _, tt := range tests {
+		binding := &HeadersBinding{Queue: NewQueue("q"), Arguments: tt.args}
+		if got := binding.Matches(tt.headers); got != tt.want {
+			t.Errorf("%s: Matches(%v) = %v, want %v", tt.name, tt.headers, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package vhost
+
+import "github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+
+// ringChunkSize is the number of messages in a chunk of a messageRing
+const ringChunkSize = 256
+
+type ringChunk [ringChunkSize]*amqp.Message
+
+// messageRing is a FIFO of messages stored in fixed-size chunks, themselves
+// kept in a circular slice. Pushing at either end and popping at the front
+// are O(1) and only allocate when a chunk fills up; the chunk released last
+// is kept for reuse, so a queue hovering around a chunk boundary does not
+// allocate chunks at all. The zero value is an empty ring.
+type messageRing struct {
+	chunks []*ringChunk // circular, nchunk of them from first on are in use
+	first  int          // index in chunks of the chunk holding the front
+	nchunk int          // chunks in use
+	head   int          // index of the front message in the first chunk
+	length int
+	spare  *ringChunk
+}
+
+func (r *messageRing) Len() int {
+	return r.length
+}
+
+// slot returns the slot of the message at position i from the front
+func (r *messageRing) slot(i int) **amqp.Message {
+	p := r.head + i
+	return &r.chunks[(r.first+p/ringChunkSize)%len(r.chunks)][p%ringChunkSize]
+}
+
+// Front returns the message at the front, nil when the ring is empty
+func (r *messageRing) Front() *amqp.Message {
+	if r.length == 0 {
+		return nil
+	}
+	return *r.slot(0)
+}
+
+// PushBack appends the message at the back
+func (r *messageRing) PushBack(msg *amqp.Message) {
+	if (r.head+r.length)/ringChunkSize >= r.nchunk {
+		r.addChunk(r.first + r.nchunk)
+	}
+	*r.slot(r.length) = msg
+	r.length++
+}
+
+// PushFront puts the message at the front
+func (r *messageRing) PushFront(msg *amqp.Message) {
+	switch {
+	case r.length == 0 && r.nchunk > 0:
+		// fill the chunk in use from its end
+		r.head = ringChunkSize
+	case r.head == 0:
+		r.addChunk(r.first - 1)
+		r.first = (r.first - 1 + len(r.chunks)) % len(r.chunks)
+		r.head = ringChunkSize
+	}
+	r.head--
+	r.length++
+	*r.slot(0) = msg
+}
+
+// PopFront removes the message at the front, nil when the ring is empty
+func (r *messageRing) PopFront() *amqp.Message {
+	if r.length == 0 {
+		return nil
+	}
+	slot := r.slot(0)
+	msg := *slot
+	*slot = nil
+	r.head++
+	r.length--
+	switch {
+	case r.length == 0:
+		// start over at the beginning of the first chunk
+		for r.nchunk > 1 {
+			r.releaseChunk((r.first + r.nchunk - 1) % len(r.chunks))
+		}
+		r.head = 0
+	case r.head == ringChunkSize:
+		r.releaseChunk(r.first)
+		r.first = (r.first + 1) % len(r.chunks)
+		r.head = 0
+	}
+	return msg
+}
+
+// Each calls fn on the messages from front to back
+func (r *messageRing) Each(fn func(msg *amqp.Message)) {
+	for i := 0; i < r.length; i++ {
+		fn(*r.slot(i))
+	}
+}
+
+// Reset empties the ring, dropping its chunks
+func (r *messageRing) Reset() {
+	*r = messageRing{}
+}
+
+// addChunk puts a chunk at the given position, right before the first chunk
+// or right after the last one, growing the circular slice when it is full
+func (r *messageRing) addChunk(pos int) {
+	if r.nchunk == len(r.chunks) {
+		grown := make([]*ringChunk, max(2*len(r.chunks), 4))
+		// one free slot before the first chunk and the rest after the last
+		for i := 0; i < r.nchunk; i++ {
+			grown[i+1] = r.chunks[(r.first+i)%len(r.chunks)]
+		}
+		pos -= r.first - 1
+		r.chunks = grown
+		r.first = 1
+	}
+	chunk := r.spare
+	r.spare = nil
+	if chunk == nil {
+		chunk = new(ringChunk)
+	}
+	r.chunks[(pos+len(r.chunks))%len(r.chunks)] = chunk
+	r.nchunk++
+}
+
+// releaseChunk takes the empty chunk at index i out of use, keeping it as
+// the spare
+func (r *messageRing) releaseChunk(i int) {
+	r.spare = r.chunks[i]
+	r.chunks[i] = nil
+	r.nchunk--
+}
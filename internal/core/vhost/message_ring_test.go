@@ -0,0 +1,74 @@
+package vhost
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+)
+
+// TestMessageRing checks the ring against a slice over random operations
+// crossing the chunk boundaries in both directions
+func TestMessageRing(t *testing.T) {
+	var ring messageRing
+	var want []string
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		id := strconv.Itoa(i)
+		switch op := rnd.Intn(10); {
+		case op < 4:
+			ring.PushBack(&amqp.Message{ID: id})
+			want = append(want, id)
+		case op < 6:
+			ring.PushFront(&amqp.Message{ID: id})
+			want = append([]string{id}, want...)
+		default:
+			msg := ring.PopFront()
+			if len(want) == 0 {
+				if msg != nil {
+					t.Fatalf("PopFront() on an empty ring = %s", msg.ID)
+				}
+				continue
+			}
+			if msg == nil || msg.ID != want[0] {
+				t.Fatalf("step %d: PopFront() = %v, want %s", i, msg, want[0])
+			}
+			want = want[1:]
+		}
+		if ring.Len() != len(want) {
+			t.Fatalf("step %d: Len() = %d, want %d", i, ring.Len(), len(want))
+		}
+		if ring.nchunk > len(want)/ringChunkSize+2 {
+			t.Fatalf("step %d: %d chunks in use for %d messages", i, ring.nchunk, len(want))
+		}
+	}
+	i := 0
+	ring.Each(func(msg *amqp.Message) {
+		if msg.ID != want[i] {
+			t.Fatalf("Each() message %d = %s, want %s", i, msg.ID, want[i])
+		}
+		i++
+	})
+}
+
+func TestQueueNotEmpty(t *testing.T) {
+	q := NewQueue("q")
+	wait := q.NotEmpty()
+	select {
+	case <-wait:
+		t.Fatal("NotEmpty() closed on an empty queue")
+	default:
+	}
+	go q.Push(amqp.Message{ID: "a"})
+	<-wait
+	if msg := q.Pop(); msg == nil || msg.ID != "a" {
+		t.Fatalf("Pop() = %v, want a", msg)
+	}
+	q.ReQueue(amqp.Message{ID: "a"})
+	select {
+	case <-q.NotEmpty():
+	default:
+		t.Fatal("NotEmpty() open on a queue with a message")
+	}
+}
@@ -0,0 +1,35 @@
+package vhost
+
+import (
+	"errors"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+)
+
+// ErrPublishRejected is returned when a queue with a reject-publish overflow
+// is full. It does not close the channel; in confirm mode the publish is
+// nacked.
+var ErrPublishRejected = errors.New("message rejected: queue is full")
+
+// enqueue offers the message to the queue and reports whether it was
+// accepted. It must be called without the vhost mutex held.
+func (vh *VHost) enqueue(queue *Queue, msg amqp.Message) bool {
+	accepted, dropped := queue.Offer(msg)
+	return vh.settleOffer(queue, msg, accepted, dropped)
+}
+
+// settleOffer handles the outcome of queue.Offer: the messages dropped from
+// the head and a refused message are dead-lettered or forgotten. It must be
+// called without the vhost mutex held.
+func (vh *VHost) settleOffer(queue *Queue, msg amqp.Message, accepted bool, dropped []amqp.Message) bool {
+	if !accepted {
+		if queue.Overflow == overflowRejectPublishDLX {
+			vh.deadLetter(queue, []amqp.Message{msg}, deathMaxLen)
+		} else {
+			vh.forgetMessage(queue, &msg)
+		}
+		return false
+	}
+	vh.deadLetter(queue, dropped, deathMaxLen)
+	return true
+}
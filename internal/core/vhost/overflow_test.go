@@ -0,0 +1,45 @@
+package vhost
+
+import (
+	"testing"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+)
+
+func TestOfferDropHead(t *testing.T) {
+	q := NewQueue("q")
+	q.MaxLength = 2
+	for _, body := range []string{"a", "b", "c"} {
+		accepted, dropped := q.Offer(amqp.Message{ID: body, Body: []byte(body)})
+		if !accepted {
+			t.Fatalf("Offer(%s) refused with drop-head", body)
+		}
+		if body == "c" && (len(dropped) != 1 || dropped[0].ID != "a") {
+			t.Errorf("Offer(c) dropped %v, want a", dropped)
+		}
+	}
+	if q.Len() != 2 || q.Bytes() != 2 {
+		t.Errorf("Len() = %d, Bytes() = %d, want 2 and 2", q.Len(), q.Bytes())
+	}
+	if msg := q.Pop(); msg == nil || msg.ID != "b" {
+		t.Errorf("Pop() = %v, want b", msg)
+	}
+}
+
+func TestOfferRejectPublish(t *testing.T) {
+	q := NewQueue("q")
+	q.MaxLengthBytes = 5
+	q.Overflow = overflowRejectPublish
+	if accepted, _ := q.Offer(amqp.Message{Body: []byte("1234")}); !accepted {
+		t.Fatal("Offer refused a message within the limit")
+	}
+	if accepted, _ := q.Offer(amqp.Message{Body: []byte("56")}); accepted {
+		t.Error("Offer accepted a message over the byte limit")
+	}
+	if accepted, _ := q.Offer(amqp.Message{Body: []byte("5")}); !accepted {
+		t.Error("Offer refused a message filling the queue exactly")
+	}
+	if q.Len() != 2 || q.Bytes() != 5 {
+		t.Errorf("Len() = %d, Bytes() = %d, want 2 and 5", q.Len(), q.Bytes())
+	}
+}
@@ -0,0 +1,191 @@
+package vhost
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/connection/constants"
+)
+
+// Access is the kind of operation checked against a user's permissions
+type Access int
+
+const (
+	// declaring, deleting or purging a resource
+	ConfigureAccess Access = iota
+	// publishing to an exchange, binding a queue
+	WriteAccess
+	// consuming from a queue, binding from an exchange
+	ReadAccess
+)
+
+// name of the default exchange in permission checks
+const default_exchange_resource = "amq.default"
+
+// Permissions holds the configure, write and read regular expressions of a
+// user in a vhost. A resource is allowed when its name matches the
+// expression; an empty expression allows nothing.
+type Permissions struct {
+	Configure string `json:"configure"`
+	Write     string `json:"write"`
+	Read      string `json:"read"`
+
+	configure *regexp.Regexp
+	write     *regexp.Regexp
+	read      *regexp.Regexp
+}
+
+// NewPermissions compiles the configure, write and read expressions
+func NewPermissions(configure, write, read string) (*Permissions, error) {
+	p := &Permissions{Configure: configure, Write: write, Read: read}
+	var err error
+	if p.configure, err = compilePermission(configure); err != nil {
+		return nil, fmt.Errorf("invalid configure permission: %v", err)
+	}
+	if p.write, err = compilePermission(write); err != nil {
+		return nil, fmt.Errorf("invalid write permission: %v", err)
+	}
+	if p.read, err = compilePermission(read); err != nil {
+		return nil, fmt.Errorf("invalid read permission: %v", err)
+	}
+	return p, nil
+}
+
+func compilePermission(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+// Allows tells whether the access to the named resource is granted
+func (p *Permissions) Allows(access Access, name string) bool {
+	var re *regexp.Regexp
+	switch access {
+	case ConfigureAccess:
+		re = p.configure
+	case WriteAccess:
+		re = p.write
+	case ReadAccess:
+		re = p.read
+	}
+	return re != nil && re.MatchString(name)
+}
+
+// SetPermissions replaces the permissions of the user in the vhost
+func (vh *VHost) SetPermissions(username string, p *Permissions) {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	vh.Permissions[username] = p
+}
+
+// ClearPermissions removes the permissions of the user in the vhost
+func (vh *VHost) ClearPermissions(username string) {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	delete(vh.Permissions, username)
+}
+
+// CheckPermission returns ACCESS_REFUSED unless the user may access the
+// resource. kind is "exchange" or "queue". The administrators of the vhost
+// are not restricted; other users need a permission entry.
+func (vh *VHost) CheckPermission(username string, access Access, kind, name string) error {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	if vh.Users[username].IsAdmin() {
+		return nil
+	}
+	if kind == "exchange" && (name == "" || name == default_exchange) {
+		name = default_exchange_resource
+	}
+	if p, ok := vh.Permissions[username]; ok && p.Allows(access, name) {
+		return nil
+	}
+	return amqp.NewError(constants.ACCESS_REFUSED, "access to %s '%s' in vhost '%s' refused for user '%s'", kind, name, vh.Name, username)
+}
+
+// TopicPermissions restricts the routing keys a user may publish with
+// (write) or bind with (read) on a topic exchange. A user without topic
+// permissions on an exchange is not restricted.
+type TopicPermissions struct {
+	Exchange string `json:"exchange"`
+	Write    string `json:"write"`
+	Read     string `json:"read"`
+
+	write *regexp.Regexp
+	read  *regexp.Regexp
+}
+
+// NewTopicPermissions compiles the write and read expressions
+func NewTopicPermissions(exchange, write, read string) (*TopicPermissions, error) {
+	p := &TopicPermissions{Exchange: exchange, Write: write, Read: read}
+	var err error
+	if p.write, err = compilePermission(write); err != nil {
+		return nil, fmt.Errorf("invalid write permission: %v", err)
+	}
+	if p.read, err = compilePermission(read); err != nil {
+		return nil, fmt.Errorf("invalid read permission: %v", err)
+	}
+	return p, nil
+}
+
+// Allows tells whether the routing key is granted for publishing (write)
+// or binding (read)
+func (p *TopicPermissions) Allows(access Access, routingKey string) bool {
+	var re *regexp.Regexp
+	switch access {
+	case WriteAccess:
+		re = p.write
+	case ReadAccess:
+		re = p.read
+	}
+	return re != nil && re.MatchString(routingKey)
+}
+
+// SetTopicPermissions replaces the topic permissions of the user on the
+// exchange
+func (vh *VHost) SetTopicPermissions(username string, p *TopicPermissions) {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	if vh.TopicPermissions[username] == nil {
+		vh.TopicPermissions[username] = make(map[string]*TopicPermissions)
+	}
+	vh.TopicPermissions[username][p.Exchange] = p
+}
+
+// ClearTopicPermissions removes the topic permissions of the user on the
+// exchange
+func (vh *VHost) ClearTopicPermissions(username, exchangeName string) {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	delete(vh.TopicPermissions[username], exchangeName)
+	if len(vh.TopicPermissions[username]) == 0 {
+		delete(vh.TopicPermissions, username)
+	}
+}
+
+// CheckTopicPermission returns ACCESS_REFUSED unless the user may publish
+// (write) or bind (read) with the routing key on the exchange
+func (vh *VHost) CheckTopicPermission(username string, access Access, exchangeName, routingKey string) error {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	return vh.checkTopicPermission(username, access, exchangeName, routingKey)
+}
+
+// checkTopicPermission only applies to topic exchanges. An empty username
+// is the broker itself. It must be called with the vhost mutex held.
+func (vh *VHost) checkTopicPermission(username string, access Access, exchangeName, routingKey string) error {
+	if username == "" {
+		return nil
+	}
+	exchange, ok := vh.Exchanges[exchangeName]
+	if !ok || exchange.Typ != TOPIC {
+		return nil
+	}
+	p, ok := vh.TopicPermissions[username][exchangeName]
+	if !ok || p.Allows(access, routingKey) {
+		return nil
+	}
+	return amqp.NewError(constants.ACCESS_REFUSED, "access to topic '%s' in exchange '%s' in vhost '%s' refused for user '%s'", routingKey, exchangeName, vh.Name, username)
+}
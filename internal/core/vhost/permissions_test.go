@@ -0,0 +1,78 @@
+package vhost
+
+import (
+	"testing"
+
+	"github.com/andrelcunha/ottermq/pkg/persistdb"
+)
+
+func TestCheckPermission(t *testing.T) {
+	vh := NewVhost("/")
+	vh.Users["admin"] = &persistdb.User{Username: "admin", RoleID: persistdb.AdminRoleID}
+	vh.Users["user"] = &persistdb.User{Username: "user", RoleID: 2}
+	p, err := NewPermissions(`^app\.`, `^(app\..*|amq\.default)$`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vh.SetPermissions("app", p)
+
+	tests := []struct {
+		name     string
+		username string
+		access   Access
+		kind     string
+		resource string
+		allowed  bool
+	}{
+		{"configure match", "app", ConfigureAccess, "queue", "app.q", true},
+		{"configure mismatch", "app", ConfigureAccess, "queue", "other.q", false},
+		{"default exchange", "app", WriteAccess, "exchange", "", true},
+		{"empty expression", "app", ReadAccess, "queue", "app.q", false},
+		{"administrator", "admin", ReadAccess, "queue", "any", true},
+		{"user without permissions", "user", ReadAccess, "queue", "any", false},
+		{"unknown user", "nobody", ReadAccess, "queue", "app.q", false},
+	}
+	for _, tt := range tests {
+		err := vh.CheckPermission(tt.username, tt.access, tt.kind, tt.resource)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: CheckPermission(%s, %s) = %v, want allowed %v", tt.name, tt.username, tt.resource, err, tt.allowed)
+		}
+	}
+
+	if _, err := NewPermissions("(", "", ""); err == nil {
+		t.Errorf("NewPermissions with an invalid expression: expected an error")
+	}
+}
+
+func TestCheckTopicPermission(t *testing.T) {
+	vh := NewVhost("/")
+	vh.CreateExchange("events", TOPIC)
+	vh.CreateExchange("direct", DIRECT)
+	p, err := NewTopicPermissions("events", `^app\.`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vh.SetTopicPermissions("app", p)
+
+	tests := []struct {
+		name       string
+		username   string
+		access     Access
+		exchange   string
+		routingKey string
+		allowed    bool
+	}{
+		{"write match", "app", WriteAccess, "events", "app.created", true},
+		{"write mismatch", "app", WriteAccess, "events", "billing.created", false},
+		{"empty expression", "app", ReadAccess, "events", "app.#", false},
+		{"not a topic exchange", "app", WriteAccess, "direct", "billing", true},
+		{"no topic permissions", "other", WriteAccess, "events", "billing.created", true},
+		{"broker", "", WriteAccess, "events", "billing.created", true},
+	}
+	for _, tt := range tests {
+		err := vh.CheckTopicPermission(tt.username, tt.access, tt.exchange, tt.routingKey)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: CheckTopicPermission(%s, %s, %s) = %v, want allowed %v", tt.name, tt.username, tt.exchange, tt.routingKey, err, tt.allowed)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package vhost
+
+import (
+	"log"
+
+	"github.com/andrelcunha/ottermq/internal/core"
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+)
+
+// delivery mode of the messages kept on disk
+const persistentDeliveryMode = 2
+
+// SetMessageStore makes the vhost keep the persistent messages of its
+// durable queues in the store. It must be called before the vhost is used.
+func (vh *VHost) SetMessageStore(store *core.MessageStore) {
+	vh.store = store
+}
+
+// isPersistent tells whether the message is kept on disk while in the queue
+func (vh *VHost) isPersistent(queue *Queue, msg *amqp.Message) bool {
+	return vh.store != nil && queue.Durable && msg.Properties.DeliveryMode == persistentDeliveryMode
+}
+
+// storeMessage writes a persistent message for the durable queues it is
+// routed to. It is called before the message is pushed to the queues.
+func (vh *VHost) storeMessage(queues []*Queue, msg amqp.Message) error {
+	names := make([]string, 0)
+	for _, queue := range queues {
+		if vh.isPersistent(queue, &msg) {
+			names = append(names, queue.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return vh.store.Write(vh.Name, names, msg)
+}
+
+// forgetMessage deletes from the store a message that left its queue for good
+func (vh *VHost) forgetMessage(queue *Queue, msg *amqp.Message) {
+	if !vh.isPersistent(queue, msg) {
+		return
+	}
+	if err := vh.store.Delete(vh.Name, queue.Name, msg.ID); err != nil {
+		log.Printf("Failed to delete message %s of queue %s from the store: %v", msg.ID, queue.Name, err)
+	}
+}
+
+// forgetUnacked deletes the settled deliveries from the store
+func (vh *VHost) forgetUnacked(entries []*UnackedMessage) {
+	for _, entry := range entries {
+		vh.forgetMessage(entry.Queue, &entry.Message)
+	}
+}
+
+// forgetQueue deletes the messages of a deleted queue from the store
+func (vh *VHost) forgetQueue(queue *Queue) {
+	if vh.store == nil || !queue.Durable {
+		return
+	}
+	if err := vh.store.DeleteQueue(vh.Name, queue.Name); err != nil {
+		log.Printf("Failed to delete the messages of queue %s from the store: %v", queue.Name, err)
+	}
+}
+
+// RecoverMessages enqueues the stored messages back to their queues, in the
+// order they were published. They keep the expiry they had before the
+// restart, and go through the length limits of their queue like a publish.
+// A queue missing from the vhost is declared again as durable.
+func (vh *VHost) RecoverMessages() error {
+	if vh.store == nil {
+		return nil
+	}
+	// read every queue before enqueuing: a message dropped on overflow is
+	// dead-lettered, which writes it again for another queue
+	names := vh.store.Queues(vh.Name)
+	stored := make([][]amqp.Message, len(names))
+	for i, name := range names {
+		messages, err := vh.store.Messages(vh.Name, name)
+		if err != nil {
+			return err
+		}
+		stored[i] = messages
+	}
+	for i, name := range names {
+		vh.mu.Lock()
+		queue, ok := vh.Queues[name]
+		if !ok {
+			queue = NewQueue(name)
+			queue.Durable = true
+			vh.Queues[name] = queue
+			defaultExchange := vh.Exchanges[default_exchange]
+			defaultExchange.Bindings[name] = append(defaultExchange.Bindings[name], queue)
+		}
+		vh.mu.Unlock()
+		recovered := 0
+		for _, msg := range stored[i] {
+			if vh.enqueue(queue, msg) {
+				recovered++
+			}
+		}
+		vh.scheduleExpiry(queue)
+		log.Printf("Recovered %d of %d message(s) of queue %s in vhost %s", recovered, len(stored[i]), name, vh.Name)
+	}
+	return nil
+}
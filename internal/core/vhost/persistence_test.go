@@ -0,0 +1,62 @@
+package vhost
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andrelcunha/ottermq/internal/core"
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp/message"
+)
+
+// TestRecoverMessages recovers stored messages into a queue with a TTL and a
+// length limit: they keep their expiry and the oldest go over the limit
+func TestRecoverMessages(t *testing.T) {
+	store, err := core.OpenMessageStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	enqueuedAt := time.Now().Add(-time.Minute)
+	for _, id := range []string{"m1", "m2", "m3"} {
+		msg := amqp.Message{
+			ID:         id,
+			RoutingKey: "q",
+			Properties: message.BasicProperties{DeliveryMode: persistentDeliveryMode},
+			EnqueuedAt: enqueuedAt,
+		}
+		if err := store.Write("/", []string{"q"}, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	vh := NewVhost("/")
+	vh.SetMessageStore(store)
+	_, err = vh.DeclareQueue(nil, "q", false, QueueOptions{
+		Durable:   true,
+		Arguments: map[string]interface{}{"x-message-ttl": int32(3600000), "x-max-length": int32(2)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vh.RecoverMessages(); err != nil {
+		t.Fatal(err)
+	}
+
+	queue := vh.Queues["q"]
+	for _, id := range []string{"m2", "m3"} {
+		msg := queue.Pop()
+		if msg == nil || msg.ID != id {
+			t.Fatalf("popped %v, want %s", msg, id)
+		}
+		if want := enqueuedAt.Add(time.Hour); !msg.ExpiresAt.Equal(want) {
+			t.Errorf("%s expires at %v, want %v", id, msg.ExpiresAt, want)
+		}
+	}
+	if msg := queue.Pop(); msg != nil {
+		t.Fatalf("popped %s over the length limit", msg.ID)
+	}
+	if messages, _ := store.Messages("/", "q"); len(messages) != 2 {
+		t.Errorf("%d message(s) left in the store, want 2", len(messages))
+	}
+}
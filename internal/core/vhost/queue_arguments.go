@@ -0,0 +1,96 @@
+package vhost
+
+import (
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/connection/constants"
+)
+
+// queue.declare arguments understood by the broker
+const (
+	argMessageTTL           = "x-message-ttl"             // milliseconds a message may stay in the queue
+	argDeadLetterExchange   = "x-dead-letter-exchange"    // exchange the dead messages are republished to
+	argDeadLetterRoutingKey = "x-dead-letter-routing-key" // replaces their routing key when set
+	argMaxLength            = "x-max-length"              // most ready messages the queue holds
+	argMaxLengthBytes       = "x-max-length-bytes"        // most bytes of bodies the queue holds
+	argOverflow             = "x-overflow"                // what happens to a publish going over the limits
+	argMaxPriority          = "x-max-priority"            // highest message priority the queue orders by
+)
+
+// maxPriority is the highest x-max-priority, the range of the priority
+// property
+const maxPriority = 255
+
+// x-overflow modes
+const (
+	overflowDropHead         = "drop-head"          // the oldest messages are dropped or dead-lettered
+	overflowRejectPublish    = "reject-publish"     // the new message is refused
+	overflowRejectPublishDLX = "reject-publish-dlx" // the new message is refused and dead-lettered
+)
+
+// applyArguments sets the queue options carried by the declare arguments.
+// An invalid value fails with PRECONDITION_FAILED.
+func (q *Queue) applyArguments(vhostName string, args map[string]interface{}) error {
+	invalid := func(arg string, value interface{}) error {
+		return amqp.NewError(constants.PRECONDITION_FAILED,
+			"invalid arg '%s' for queue '%s' in vhost '%s': %v", arg, q.Name, vhostName, value)
+	}
+	if value, ok := args[argMessageTTL]; ok {
+		ttl, ok := toInt64(value)
+		if !ok || ttl < 0 {
+			return invalid(argMessageTTL, value)
+		}
+		q.MessageTTL = int(ttl)
+	}
+	if value, ok := args[argDeadLetterExchange]; ok {
+		exchange, ok := value.(string)
+		if !ok {
+			return invalid(argDeadLetterExchange, value)
+		}
+		q.DeadLetterExchange = exchange
+		q.hasDeadLetterExchange = true
+	}
+	if value, ok := args[argDeadLetterRoutingKey]; ok {
+		routingKey, ok := value.(string)
+		if !ok {
+			return invalid(argDeadLetterRoutingKey, value)
+		}
+		if !q.hasDeadLetterExchange {
+			return amqp.NewError(constants.PRECONDITION_FAILED,
+				"invalid arg '%s' for queue '%s' in vhost '%s': requires '%s'",
+				argDeadLetterRoutingKey, q.Name, vhostName, argDeadLetterExchange)
+		}
+		q.DeadLetterRoutingKey = routingKey
+	}
+	if value, ok := args[argMaxLength]; ok {
+		length, ok := toInt64(value)
+		if !ok || length < 0 {
+			return invalid(argMaxLength, value)
+		}
+		q.MaxLength = int(length)
+	}
+	if value, ok := args[argMaxLengthBytes]; ok {
+		length, ok := toInt64(value)
+		if !ok || length < 0 {
+			return invalid(argMaxLengthBytes, value)
+		}
+		q.MaxLengthBytes = int(length)
+	}
+	if value, ok := args[argOverflow]; ok {
+		overflow, _ := value.(string)
+		switch overflow {
+		case overflowDropHead, overflowRejectPublish, overflowRejectPublishDLX:
+			q.Overflow = overflow
+		default:
+			return invalid(argOverflow, value)
+		}
+	}
+	if value, ok := args[argMaxPriority]; ok {
+		priority, ok := toInt64(value)
+		if !ok || priority < 0 || priority > maxPriority {
+			return invalid(argMaxPriority, value)
+		}
+		q.setMaxPriority(int(priority))
+	}
+	q.Arguments = args
+	return nil
+}
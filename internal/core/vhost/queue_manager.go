@@ -2,73 +2,304 @@ package vhost
 
 import (
 	"sync"
+	"time"
 
 	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
 )
 
 type Queue struct {
-	Name       string     `json:"name"`
-	Durable    bool       `json:"durable"`
-	Exclusive  bool       `json:"exclusive"`
-	AutoDelete bool       `json:"auto_delete"`
-	MessageTTL int        `json:"message_ttl"`
-	Arguments  QueueArgs  `json:"arguments"`
-	head       *Node      `json:"-"` // pointer to the first message in the queue
-	mu         sync.Mutex `json:"-"`
+	Name       string          `json:"name"`
+	Durable    bool            `json:"durable"`
+	Exclusive  bool            `json:"exclusive"`
+	AutoDelete bool            `json:"auto_delete"`
+	MessageTTL int             `json:"message_ttl"` // milliseconds, -1 when messages do not expire
+	Arguments  QueueArgs       `json:"arguments"`
+	levels     []priorityLevel `json:"-"` // messages of each priority, the highest last
+	mu         sync.Mutex      `json:"-"`
+	owner      string          // session of the connection owning an exclusive or server-named queue
+
+	// rejected and expired messages are republished to the dead-letter
+	// exchange, when the queue has one
+	DeadLetterExchange    string `json:"dead_letter_exchange"`
+	DeadLetterRoutingKey  string `json:"dead_letter_routing_key"`
+	hasDeadLetterExchange bool
+
+	// length limits, -1 when unlimited, and what happens to a publish
+	// going over them
+	MaxLength      int    `json:"max_length"`
+	MaxLengthBytes int    `json:"max_length_bytes"`
+	Overflow       string `json:"overflow"`
+
+	// highest priority of the messages, 0 when they all share one level
+	MaxPriority int `json:"max_priority"`
+
+	// number of ready messages and the size of their bodies, guarded by mu
+	count int
+	bytes int
+
+	// timer dropping the expired messages at the head, guarded by mu
+	expiryTimer *time.Timer
+	expiryAt    time.Time
+
+	// closed when a message is pushed to the empty queue, guarded by mu
+	notEmpty chan struct{}
+
+	// consumers subscribed to the queue, served round-robin. Guarded by the vhost mutex.
+	consumers    []*Consumer `json:"-"`
+	nextConsumer int         `json:"-"`
+
+	// held from popping a message to sending it to a consumer, so the
+	// deliveries leave in queue order. Taken before the vhost mutex.
+	dispatchMu sync.Mutex
 }
 
 type QueueArgs map[string]interface{}
 
-type Node struct {
-	next *Node
-	data amqp.Message
+// closedChan is returned by NotEmpty when there is no need to wait
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// priorityLevel holds the messages of one priority in publish order. A
+// queue without x-max-priority has a single level.
+type priorityLevel struct {
+	messages messageRing
 }
 
 func NewQueue(name string) *Queue {
 	queue := &Queue{
-		Name: name,
+		Name:           name,
+		MessageTTL:     -1,
+		MaxLength:      -1,
+		MaxLengthBytes: -1,
+		Overflow:       overflowDropHead,
+		levels:         make([]priorityLevel, 1),
 		// messages: make(chan Message, 100),
 	}
 	return queue
 }
 
+// Push appends the message to the queue. Its expiry is counted from the time
+// it was enqueued at, now if unset.
 func (q *Queue) Push(msg amqp.Message) {
 	// queue.messages <- msg
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	if q.head == nil {
-		q.head = &Node{data: msg}
-		return
+	q.push(msg)
+}
+
+// push appends the message to the level of its priority. It must be called
+// with the queue mutex held.
+func (q *Queue) push(msg amqp.Message) {
+	enqueuedAt := msg.EnqueuedAt
+	if enqueuedAt.IsZero() {
+		enqueuedAt = time.Now()
 	}
+	msg.ExpiresAt = q.messageExpiry(&msg, enqueuedAt)
+	q.levels[q.priority(&msg)].messages.PushBack(&msg)
+	q.count++
+	q.bytes += len(msg.Body)
+	q.signal()
+}
 
+// priority returns the level of the message, its priority capped to the
+// queue's maximum
+func (q *Queue) priority(msg *amqp.Message) int {
+	return min(int(msg.Properties.Priority), q.MaxPriority)
 }
 
+// setMaxPriority makes the queue keep a level per priority from 0 up to the
+// given one. It must be called before any message is pushed.
+func (q *Queue) setMaxPriority(priority int) {
+	q.MaxPriority = priority
+	q.levels = make([]priorityLevel, priority+1)
+}
+
+// Offer appends the message while keeping the queue within its length
+// limits. With the drop-head overflow the message is always accepted and
+// the messages dropped to make room, the oldest of the lowest priority
+// first, are returned; with the reject-publish overflows a message that does
+// not fit is refused.
+func (q *Queue) Offer(msg amqp.Message) (accepted bool, dropped []amqp.Message) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.Overflow != overflowDropHead {
+		if !q.fits(len(msg.Body)) {
+			return false, nil
+		}
+		q.push(msg)
+		return true, nil
+	}
+	q.push(msg)
+	for q.count > 0 && q.overLimit() {
+		for i := range q.levels {
+			if q.levels[i].messages.Len() > 0 {
+				dropped = append(dropped, *q.popLevel(i))
+				break
+			}
+		}
+	}
+	return true, dropped
+}
+
+// fits reports whether a message of the given size can be added without
+// going over the length limits. It must be called with the queue mutex held.
+func (q *Queue) fits(size int) bool {
+	return (q.MaxLength < 0 || q.count < q.MaxLength) &&
+		(q.MaxLengthBytes < 0 || q.bytes+size <= q.MaxLengthBytes)
+}
+
+// overLimit reports whether the queue holds more than its length limits
+// allow. It must be called with the queue mutex held.
+func (q *Queue) overLimit() bool {
+	return (q.MaxLength >= 0 && q.count > q.MaxLength) ||
+		(q.MaxLengthBytes >= 0 && q.bytes > q.MaxLengthBytes)
+}
+
+// Pop removes the oldest message of the highest priority, nil when the
+// queue is empty
 func (q *Queue) Pop() *amqp.Message {
 	// return <-queue.messages
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	if q.head == nil {
-		return nil
+	for i := len(q.levels) - 1; i >= 0; i-- {
+		if q.levels[i].messages.Len() > 0 {
+			return q.popLevel(i)
+		}
 	}
-	head := q.head
-	q.head = head.next
-	return &head.data
+	return nil
+}
+
+// popLevel removes the message at the head of a non-empty level. It must be
+// called with the queue mutex held.
+func (q *Queue) popLevel(i int) *amqp.Message {
+	msg := q.levels[i].messages.PopFront()
+	q.count--
+	q.bytes -= len(msg.Body)
+	return msg
 }
 
+// ReQueue puts the message back at the head of its priority level. It keeps
+// the expiry it had.
 func (q *Queue) ReQueue(msg amqp.Message) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	node := &Node{data: msg}
-	node.next = q.head
-	q.head = node
+	q.levels[q.priority(&msg)].messages.PushFront(&msg)
+	q.count++
+	q.bytes += len(msg.Body)
+	q.signal()
+}
+
+// NotEmpty returns a channel closed once the queue has a ready message; it
+// is closed already when the queue is not empty. A consumer waiting for
+// messages blocks on it instead of polling:
+//
+//	for msg = q.Pop(); msg == nil; msg = q.Pop() {
+//		<-q.NotEmpty()
+//	}
+func (q *Queue) NotEmpty() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.count > 0 {
+		return closedChan
+	}
+	if q.notEmpty == nil {
+		q.notEmpty = make(chan struct{})
+	}
+	return q.notEmpty
+}
+
+// signal wakes up the consumers waiting on NotEmpty. It must be called with
+// the queue mutex held.
+func (q *Queue) signal() {
+	if q.notEmpty != nil {
+		close(q.notEmpty)
+		q.notEmpty = nil
+	}
 }
 
 func (q *Queue) Len() int {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	count := 0
-	for node := q.head; node != nil; node = node.next {
-		count++
+	return q.count
+}
+
+// LenByPriority returns the number of ready messages of each priority that
+// has any. It is nil for a queue without x-max-priority.
+func (q *Queue) LenByPriority() map[int]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.MaxPriority == 0 {
+		return nil
+	}
+	counts := make(map[int]int)
+	for i := range q.levels {
+		if n := q.levels[i].messages.Len(); n > 0 {
+			counts[i] = n
+		}
+	}
+	return counts
+}
+
+// Bytes returns the total size of the bodies of the ready messages
+func (q *Queue) Bytes() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.bytes
+}
+
+// Purge removes every message from the queue and returns how many there were
+func (q *Queue) Purge() int {
+	return len(q.Drain())
+}
+
+// PopExpired removes the messages at the head of each priority level that
+// expired by now and returns them
+func (q *Queue) PopExpired(now time.Time) []amqp.Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var expired []amqp.Message
+	for i := len(q.levels) - 1; i >= 0; i-- {
+		for q.levels[i].messages.Len() > 0 && isExpired(q.levels[i].messages.Front(), now) {
+			expired = append(expired, *q.popLevel(i))
+		}
+	}
+	return expired
+}
+
+// nextExpiry returns the earliest expiry among the heads of the priority
+// levels, zero when none of them expires. It must be called with the queue
+// mutex held.
+func (q *Queue) nextExpiry() time.Time {
+	var next time.Time
+	for i := range q.levels {
+		head := q.levels[i].messages.Front()
+		if head == nil {
+			continue
+		}
+		at := head.ExpiresAt
+		if !at.IsZero() && (next.IsZero() || at.Before(next)) {
+			next = at
+		}
+	}
+	return next
+}
+
+// Drain removes every message from the queue and returns them, the highest
+// priority first
+func (q *Queue) Drain() []amqp.Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	messages := make([]amqp.Message, 0, q.count)
+	for i := len(q.levels) - 1; i >= 0; i-- {
+		q.levels[i].messages.Each(func(msg *amqp.Message) {
+			messages = append(messages, *msg)
+		})
+		q.levels[i].messages.Reset()
 	}
-	return count
+	q.count = 0
+	q.bytes = 0
+	return messages
 }
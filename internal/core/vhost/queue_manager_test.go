@@ -0,0 +1,108 @@
+package vhost
+
+import (
+	"testing"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp/message"
+)
+
+func priorityMessage(id string, priority uint8) amqp.Message {
+	return amqp.Message{ID: id, Properties: message.BasicProperties{Priority: priority}}
+}
+
+func TestPriorityQueueOrder(t *testing.T) {
+	q := NewQueue("q")
+	q.setMaxPriority(5)
+	q.Push(priorityMessage("a", 0))
+	q.Push(priorityMessage("b", 3))
+	q.Push(priorityMessage("c", 9)) // capped to 5
+	q.Push(priorityMessage("d", 3))
+	q.ReQueue(priorityMessage("e", 3))
+
+	counts := q.LenByPriority()
+	if q.Len() != 5 || counts[0] != 1 || counts[3] != 3 || counts[5] != 1 {
+		t.Fatalf("Len() = %d, LenByPriority() = %v", q.Len(), counts)
+	}
+	got := ""
+	for msg := q.Pop(); msg != nil; msg = q.Pop() {
+		got += msg.ID
+	}
+	if got != "cebda" {
+		t.Errorf("popped %s, want cebda", got)
+	}
+}
+
+func TestPriorityQueueDropsLowestPriority(t *testing.T) {
+	q := NewQueue("q")
+	q.setMaxPriority(2)
+	q.MaxLength = 2
+	q.Offer(priorityMessage("a", 2))
+	q.Offer(priorityMessage("b", 1))
+	_, dropped := q.Offer(priorityMessage("c", 2))
+	if len(dropped) != 1 || dropped[0].ID != "b" {
+		t.Errorf("dropped %v, want b", dropped)
+	}
+}
+
+const benchmarkMessages = 1000000
+
+func BenchmarkQueuePushPop(b *testing.B) {
+	msg := amqp.Message{ID: "m", Body: make([]byte, 64)}
+	q := NewQueue("q")
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		for i := 0; i < benchmarkMessages; i++ {
+			q.Push(msg)
+		}
+		for i := 0; i < benchmarkMessages; i++ {
+			q.Pop()
+		}
+	}
+	b.ReportMetric(float64(b.N)*benchmarkMessages/b.Elapsed().Seconds(), "msgs/s")
+}
+
+func BenchmarkQueueRequeue(b *testing.B) {
+	msg := amqp.Message{ID: "m", Body: make([]byte, 64)}
+	q := NewQueue("q")
+	for i := 0; i < benchmarkMessages; i++ {
+		q.Push(msg)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i := 0; i < benchmarkMessages; i++ {
+			q.ReQueue(*q.Pop())
+		}
+		if q.Len() != benchmarkMessages {
+			b.Fatalf("Len() = %d", q.Len())
+		}
+	}
+	b.ReportMetric(float64(b.N)*benchmarkMessages/b.Elapsed().Seconds(), "msgs/s")
+}
+
+// BenchmarkQueueProducerConsumer has a consumer blocking on NotEmpty while
+// a producer publishes
+func BenchmarkQueueProducerConsumer(b *testing.B) {
+	msg := amqp.Message{ID: "m", Body: make([]byte, 64)}
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		q := NewQueue("q")
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for received := 0; received < benchmarkMessages; {
+				if q.Pop() == nil {
+					<-q.NotEmpty()
+					continue
+				}
+				received++
+			}
+		}()
+		for i := 0; i < benchmarkMessages; i++ {
+			q.Push(msg)
+		}
+		<-done
+	}
+	b.ReportMetric(float64(b.N)*benchmarkMessages/b.Elapsed().Seconds(), "msgs/s")
+}
@@ -0,0 +1,166 @@
+package vhost
+
+import (
+	"sort"
+	"strings"
+)
+
+// TopicTrie holds the bindings of a topic exchange. Patterns are dot-separated
+// words where "*" matches exactly one word and "#" matches zero or more words.
+// Each word of a pattern is a level of the trie, so binding, unbinding and
+// matching only walk the words involved instead of every binding.
+// It is not safe for concurrent use: the vhost mutex guards it.
+type TopicTrie struct {
+	root *topicNode
+}
+
+type topicNode struct {
+	children map[string]*topicNode
+	queues   map[string]*Queue // queues bound with the pattern ending here
+}
+
+func newTopicNode() *topicNode {
+	return &topicNode{
+		children: make(map[string]*topicNode),
+		queues:   make(map[string]*Queue),
+	}
+}
+
+func NewTopicTrie() *TopicTrie {
+	return &TopicTrie{root: newTopicNode()}
+}
+
+func splitTopic(key string) []string {
+	if key == "" {
+		return nil
+	}
+	return strings.Split(key, ".")
+}
+
+// Bind adds the queue under the pattern. It returns false if the binding
+// already exists.
+func (t *TopicTrie) Bind(pattern string, queue *Queue) bool {
+	node := t.root
+	for _, word := range splitTopic(pattern) {
+		child, ok := node.children[word]
+		if !ok {
+			child = newTopicNode()
+			node.children[word] = child
+		}
+		node = child
+	}
+	if _, ok := node.queues[queue.Name]; ok {
+		return false
+	}
+	node.queues[queue.Name] = queue
+	return true
+}
+
+// Unbind removes the queue from the pattern and prunes the nodes left empty.
+// It returns false if there was no such binding.
+func (t *TopicTrie) Unbind(pattern, queueName string) bool {
+	words := splitTopic(pattern)
+	path := make([]*topicNode, 0, len(words)+1)
+	node := t.root
+	path = append(path, node)
+	for _, word := range words {
+		child, ok := node.children[word]
+		if !ok {
+			return false
+		}
+		node = child
+		path = append(path, node)
+	}
+	if _, ok := node.queues[queueName]; !ok {
+		return false
+	}
+	delete(node.queues, queueName)
+
+	for i := len(words); i > 0; i-- {
+		node := path[i]
+		if len(node.queues) > 0 || len(node.children) > 0 {
+			break
+		}
+		delete(path[i-1].children, words[i-1])
+	}
+	return true
+}
+
+// UnbindQueue removes every binding of the queue and reports whether it had
+// any
+func (t *TopicTrie) UnbindQueue(queueName string) bool {
+	unbound := false
+	for pattern, queues := range t.Bindings() {
+		for _, name := range queues {
+			if name == queueName && t.Unbind(pattern, queueName) {
+				unbound = true
+			}
+		}
+	}
+	return unbound
+}
+
+// Match returns the queues bound with a pattern matching the routing key,
+// each queue at most once.
+func (t *TopicTrie) Match(routingKey string) []*Queue {
+	words := splitTopic(routingKey)
+	matched := make(map[string]*Queue)
+	// the same node can be reached at the same position through different
+	// "#" expansions: visit it once
+	visited := make(map[*topicNode]map[int]bool)
+	var walk func(node *topicNode, pos int)
+	walk = func(node *topicNode, pos int) {
+		if visited[node] == nil {
+			visited[node] = make(map[int]bool)
+		}
+		if visited[node][pos] {
+			return
+		}
+		visited[node][pos] = true
+
+		if hash, ok := node.children["#"]; ok {
+			for i := pos; i <= len(words); i++ {
+				walk(hash, i)
+			}
+		}
+		if pos == len(words) {
+			for name, queue := range node.queues {
+				matched[name] = queue
+			}
+			return
+		}
+		if child, ok := node.children[words[pos]]; ok {
+			walk(child, pos+1)
+		}
+		if star, ok := node.children["*"]; ok {
+			walk(star, pos+1)
+		}
+	}
+	walk(t.root, 0)
+
+	queues := make([]*Queue, 0, len(matched))
+	for _, queue := range matched {
+		queues = append(queues, queue)
+	}
+	return queues
+}
+
+// Bindings returns the queue names bound under each pattern
+func (t *TopicTrie) Bindings() map[string][]string {
+	bindings := make(map[string][]string)
+	var walk func(node *topicNode, words []string)
+	walk = func(node *topicNode, words []string) {
+		if len(node.queues) > 0 {
+			pattern := strings.Join(words, ".")
+			for name := range node.queues {
+				bindings[pattern] = append(bindings[pattern], name)
+			}
+			sort.Strings(bindings[pattern])
+		}
+		for word, child := range node.children {
+			walk(child, append(words, word))
+		}
+	}
+	walk(t.root, nil)
+	return bindings
+}
@@ -0,0 +1,94 @@
+package vhost
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func matchedNames(t *TopicTrie, key string) []string {
+	names := make([]string, 0)
+	for _, q := range t.Match(key) {
+		names = append(names, q.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestTopicTrieMatch(t *testing.T) {
+	trie := NewTopicTrie()
+	bindings := map[string]string{
+		"stock.usd.nyse":  "exact",
+		"stock.*.nyse":    "star",
+		"stock.#":         "stock-hash",
+		"#":               "all",
+		"*.*":             "two-words",
+		"#.nyse":          "ends-nyse",
+		"stock.#.nyse.#":  "double-hash",
+		"weather.*.today": "weather",
+	}
+	for pattern, name := range bindings {
+		if !trie.Bind(pattern, NewQueue(name)) {
+			t.Fatalf("Bind(%q) returned false", pattern)
+		}
+	}
+
+	tests := []struct {
+		key  string
+		want []string
+	}{
+		{"stock.usd.nyse", []string{"all", "double-hash", "ends-nyse", "exact", "star", "stock-hash"}},
+		{"stock.eur.nyse", []string{"all", "double-hash", "ends-nyse", "star", "stock-hash"}},
+		{"stock", []string{"all", "stock-hash"}},
+		{"stock.nyse", []string{"all", "double-hash", "ends-nyse", "stock-hash", "two-words"}},
+		{"nyse", []string{"all", "ends-nyse"}},
+		{"weather.paris.today", []string{"all", "weather"}},
+		{"weather.today", []string{"all", "two-words"}},
+		{"", []string{"all"}},
+	}
+	for _, tt := range tests {
+		if got := matchedNames(trie, tt.key); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Match(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestTopicTrieBindUnbind(t *testing.T) {
+	trie := NewTopicTrie()
+	q1 := NewQueue("q1")
+	q2 := NewQueue("q2")
+
+	trie.Bind("a.*.c", q1)
+	trie.Bind("a.*.c", q2)
+	trie.Bind("a.#", q1)
+	if trie.Bind("a.#", q1) {
+		t.Error("duplicate Bind should return false")
+	}
+
+	want := map[string][]string{"a.*.c": {"q1", "q2"}, "a.#": {"q1"}}
+	if got := trie.Bindings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Bindings() = %v, want %v", got, want)
+	}
+
+	if got := matchedNames(trie, "a.b.c"); !reflect.DeepEqual(got, []string{"q1", "q2"}) {
+		t.Errorf("Match(a.b.c) = %v", got)
+	}
+
+	if trie.Unbind("a.*.d", "q1") {
+		t.Error("Unbind of a missing pattern should return false")
+	}
+	if !trie.Unbind("a.*.c", "q2") {
+		t.Error("Unbind(a.*.c, q2) returned false")
+	}
+	if got := matchedNames(trie, "a.b.c"); !reflect.DeepEqual(got, []string{"q1"}) {
+		t.Errorf("Match(a.b.c) after unbind = %v", got)
+	}
+
+	trie.UnbindQueue("q1")
+	if got := trie.Bindings(); len(got) != 0 {
+		t.Errorf("Bindings() after UnbindQueue = %v", got)
+	}
+	if len(trie.root.children) != 0 {
+		t.Errorf("empty nodes were not pruned: %v", trie.root.children)
+	}
+}
@@ -0,0 +1,242 @@
+package vhost
+
+import (
+	"log"
+	"sync"
+
+	"github.com/andrelcunha/ottermq/pkg/connection/utils"
+	"github.com/andrelcunha/ottermq/pkg/persistdb"
+)
+
+// isDurableQueue tells whether the queue outlives a broker restart. Exclusive
+// and server-named queues belong to a connection and never do.
+func isDurableQueue(queue *Queue) bool {
+	return queue.Durable && queue.owner == ""
+}
+
+func encodeArguments(args map[string]interface{}) []byte {
+	if len(args) == 0 {
+		return nil
+	}
+	return utils.EncodeTable(args)
+}
+
+func decodeArguments(data []byte) (map[string]interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return utils.DecodeTable(data)
+}
+
+// bindingRecord describes a binding the way it is stored: a fanout binding
+// has no routing key, a headers binding is identified by its arguments.
+func (vh *VHost) bindingRecord(exchange *Exchange, queue *Queue, routingKey string, args map[string]interface{}) persistdb.BindingRecord {
+	record := persistdb.BindingRecord{
+		VHost:      vh.Name,
+		Exchange:   exchange.Name,
+		Queue:      queue.Name,
+		RoutingKey: routingKey,
+	}
+	switch exchange.Typ {
+	case FANOUT:
+		record.RoutingKey = ""
+	case HEADERS:
+		record.RoutingKey = ""
+		record.ArgumentsKey = headersBindingKey(args)
+		record.Arguments = encodeArguments(args)
+	}
+	return record
+}
+
+// topologyWrites holds the changes to the persisted exchanges, queues and
+// bindings. They are queued under the vhost mutex, so they follow the order
+// of the changes they record, and written once it is released, so the vhost
+// does not wait on the database.
+type topologyWrites struct {
+	mu      sync.Mutex // guards pending and closed
+	pending []func()
+	closed  bool       // set once the vhost is deleted, later writes are dropped
+	writeMu sync.Mutex // held while writing, so queued writes never reorder
+}
+
+// queueTopologyWrite queues a write for writeTopology. It must be called with
+// the vhost mutex held.
+func (vh *VHost) queueTopologyWrite(write func()) {
+	vh.topology.mu.Lock()
+	if !vh.topology.closed {
+		vh.topology.pending = append(vh.topology.pending, write)
+	}
+	vh.topology.mu.Unlock()
+}
+
+// writeTopology writes the queued topology changes. When it returns, the
+// changes queued before it was called are written, possibly by a concurrent
+// call. It must be called without the vhost mutex held.
+func (vh *VHost) writeTopology() {
+	vh.topology.writeMu.Lock()
+	defer vh.topology.writeMu.Unlock()
+	vh.topology.mu.Lock()
+	writes := vh.topology.pending
+	vh.topology.pending = nil
+	vh.topology.mu.Unlock()
+	for _, write := range writes {
+		write()
+	}
+}
+
+// The helpers below queue the changes to the persisted topology; they must be
+// called with the vhost mutex held, and the caller must call writeTopology
+// once it released it.
+
+func (vh *VHost) saveExchange(exchange *Exchange) {
+	if !exchange.Durable {
+		return
+	}
+	record := persistdb.ExchangeRecord{
+		VHost:      vh.Name,
+		Name:       exchange.Name,
+		Type:       string(exchange.Typ),
+		AutoDelete: exchange.AutoDelete,
+		Internal:   exchange.Internal,
+		Arguments:  encodeArguments(exchange.Arguments),
+	}
+	vh.queueTopologyWrite(func() {
+		if err := persistdb.SaveExchange(record); err != nil {
+			log.Printf("Failed to persist exchange %s: %v", record.Name, err)
+		}
+	})
+}
+
+func (vh *VHost) forgetExchange(exchange *Exchange) {
+	if !exchange.Durable {
+		return
+	}
+	vhostName, name := vh.Name, exchange.Name
+	vh.queueTopologyWrite(func() {
+		if err := persistdb.DeleteExchange(vhostName, name); err != nil {
+			log.Printf("Failed to delete persisted exchange %s: %v", name, err)
+		}
+	})
+}
+
+func (vh *VHost) saveQueue(queue *Queue) {
+	if !isDurableQueue(queue) {
+		return
+	}
+	record := persistdb.QueueRecord{
+		VHost:      vh.Name,
+		Name:       queue.Name,
+		AutoDelete: queue.AutoDelete,
+		Arguments:  encodeArguments(queue.Arguments),
+	}
+	vh.queueTopologyWrite(func() {
+		if err := persistdb.SaveQueue(record); err != nil {
+			log.Printf("Failed to persist queue %s: %v", record.Name, err)
+		}
+	})
+}
+
+func (vh *VHost) forgetQueueDefinition(queue *Queue) {
+	if !isDurableQueue(queue) {
+		return
+	}
+	vhostName, name := vh.Name, queue.Name
+	vh.queueTopologyWrite(func() {
+		if err := persistdb.DeleteQueue(vhostName, name); err != nil {
+			log.Printf("Failed to delete persisted queue %s: %v", name, err)
+		}
+	})
+}
+
+// saveBinding persists a binding between a durable exchange and a durable
+// queue
+func (vh *VHost) saveBinding(exchange *Exchange, queue *Queue, routingKey string, args map[string]interface{}) {
+	if !exchange.Durable || !isDurableQueue(queue) {
+		return
+	}
+	record := vh.bindingRecord(exchange, queue, routingKey, args)
+	vh.queueTopologyWrite(func() {
+		if err := persistdb.SaveBinding(record); err != nil {
+			log.Printf("Failed to persist binding of queue %s to exchange %s: %v", record.Queue, record.Exchange, err)
+		}
+	})
+}
+
+func (vh *VHost) forgetBinding(exchange *Exchange, queue *Queue, routingKey string, args map[string]interface{}) {
+	if !exchange.Durable || !isDurableQueue(queue) {
+		return
+	}
+	record := vh.bindingRecord(exchange, queue, routingKey, args)
+	vh.queueTopologyWrite(func() {
+		if err := persistdb.DeleteBinding(record); err != nil {
+			log.Printf("Failed to delete persisted binding of queue %s to exchange %s: %v", record.Queue, record.Exchange, err)
+		}
+	})
+}
+
+// RestoreTopology declares again the durable exchanges, queues and bindings
+// persisted for the vhost. It must be called before the vhost is used.
+func (vh *VHost) RestoreTopology() error {
+	exchanges, queues, bindings, err := persistdb.GetTopology(vh.Name)
+	if err != nil {
+		return err
+	}
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	for _, record := range exchanges {
+		if _, ok := vh.Exchanges[record.Name]; ok {
+			continue
+		}
+		args, err := decodeArguments(record.Arguments)
+		if err != nil {
+			log.Printf("Failed to decode the arguments of exchange %s: %v", record.Name, err)
+			continue
+		}
+		exchange := newExchange(record.Name, ExchangeType(record.Type))
+		exchange.Durable = true
+		exchange.AutoDelete = record.AutoDelete
+		exchange.Internal = record.Internal
+		exchange.Arguments = args
+		vh.Exchanges[record.Name] = exchange
+	}
+	for _, record := range queues {
+		if _, ok := vh.Queues[record.Name]; ok {
+			continue
+		}
+		args, err := decodeArguments(record.Arguments)
+		if err != nil {
+			log.Printf("Failed to decode the arguments of queue %s: %v", record.Name, err)
+			continue
+		}
+		queue := NewQueue(record.Name)
+		queue.Durable = true
+		queue.AutoDelete = record.AutoDelete
+		if err := queue.applyArguments(vh.Name, args); err != nil {
+			log.Printf("Failed to apply the arguments of queue %s: %v", record.Name, err)
+		}
+		vh.Queues[record.Name] = queue
+		defaultExchange := vh.Exchanges[default_exchange]
+		defaultExchange.Bindings[record.Name] = append(defaultExchange.Bindings[record.Name], queue)
+	}
+	for _, record := range bindings {
+		exchange, ok := vh.Exchanges[record.Exchange]
+		if !ok {
+			continue
+		}
+		queue, ok := vh.Queues[record.Queue]
+		if !ok {
+			continue
+		}
+		args, err := decodeArguments(record.Arguments)
+		if err != nil {
+			log.Printf("Failed to decode the arguments of a binding of queue %s: %v", record.Queue, err)
+			continue
+		}
+		if err := vh.bind(exchange, queue, record.RoutingKey, args); err != nil {
+			log.Printf("Failed to restore a binding of queue %s to exchange %s: %v", record.Queue, record.Exchange, err)
+		}
+	}
+	log.Printf("Restored %d exchange(s), %d queue(s) and %d binding(s) in vhost %s",
+		len(exchanges), len(queues), len(bindings), vh.Name)
+	return nil
+}
@@ -0,0 +1,108 @@
+package vhost
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/persistdb"
+)
+
+// TestTopologyWrites declares and removes durable resources and checks the
+// database once each call returns
+func TestTopologyWrites(t *testing.T) {
+	persistdb.SetDbPath(filepath.Join(t.TempDir(), "ottermq.db"))
+	persistdb.InitDB()
+
+	vh := NewVhost("/")
+	if err := vh.DeclareExchange("x", DIRECT, false, ExchangeOptions{Durable: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vh.DeclareQueue(nil, "q", false, QueueOptions{Durable: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vh.BindQueue("", "x", "q", "k", nil); err != nil {
+		t.Fatal(err)
+	}
+	exchanges, queues, bindings, err := persistdb.GetTopology("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exchanges) != 1 || len(queues) != 1 || len(bindings) != 1 {
+		t.Fatalf("stored %d exchange(s), %d queue(s), %d binding(s), want 1 each", len(exchanges), len(queues), len(bindings))
+	}
+
+	if err := vh.DeletBinding("x", "q", "k", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vh.DeleteQueue(nil, "q", false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := vh.DeleteExchange("x"); err != nil {
+		t.Fatal(err)
+	}
+	exchanges, queues, bindings, err = persistdb.GetTopology("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exchanges)+len(queues)+len(bindings) != 0 {
+		t.Fatalf("stored %d exchange(s), %d queue(s), %d binding(s) after deleting them", len(exchanges), len(queues), len(bindings))
+	}
+}
+
+// TestWriteTopologyReleasesVHost checks the queued writes run without the
+// vhost mutex held
+func TestWriteTopologyReleasesVHost(t *testing.T) {
+	vh := NewVhost("/")
+	written := false
+	vh.mu.Lock()
+	vh.queueTopologyWrite(func() {
+		if !vh.mu.TryLock() {
+			t.Error("vhost mutex held during a topology write")
+			return
+		}
+		vh.mu.Unlock()
+		written = true
+	})
+	vh.mu.Unlock()
+	vh.writeTopology()
+	if !written {
+		t.Fatal("queued write not run")
+	}
+}
+
+// TestClose checks a closed vhost does its pending topology writes, drops
+// the later ones and stops the expiry timers of its queues
+func TestClose(t *testing.T) {
+	vh := NewVhost("/")
+	queue, err := vh.DeclareQueue(nil, "q", false, QueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue.MessageTTL = 60000
+	queue.Push(amqp.Message{ID: "m1"})
+	vh.scheduleExpiry(queue)
+	if queue.expiryTimer == nil {
+		t.Fatal("expiry timer not armed")
+	}
+
+	written := 0
+	vh.mu.Lock()
+	vh.queueTopologyWrite(func() { written++ })
+	vh.mu.Unlock()
+	vh.Close()
+	if written != 1 {
+		t.Fatalf("pending write run %d time(s) on close, want 1", written)
+	}
+	if queue.expiryTimer != nil {
+		t.Fatal("expiry timer still armed after close")
+	}
+
+	vh.mu.Lock()
+	vh.queueTopologyWrite(func() { written++ })
+	vh.mu.Unlock()
+	vh.writeTopology()
+	if written != 1 {
+		t.Fatal("write queued after close was run")
+	}
+}
@@ -0,0 +1,135 @@
+package vhost
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp/message"
+	"github.com/google/uuid"
+)
+
+// CommitTx applies the publishes and acknowledgements buffered by a
+// transactional channel. Either all of them are applied or, when one of them
+// is invalid, none is. Published messages become visible to consumers at once.
+func (vh *VHost) CommitTx(conn net.Conn, channel uint16, publishes []amqp.Message, acks []*amqp.RequestMethodMessage) error {
+	sessionID := SessionID(conn)
+	for _, msg := range publishes {
+		if err := CheckExpiration(msg.Properties.Expiration); err != nil {
+			return err
+		}
+	}
+
+	vh.mu.Lock()
+	routes := make([][]*Queue, len(publishes))
+	for i, msg := range publishes {
+		queues, err := vh.route(msg.Exchange, msg.RoutingKey, msg.Properties.Headers)
+		if err != nil {
+			vh.mu.Unlock()
+			return err
+		}
+		routes[i] = queues
+	}
+	state := vh.getChannelDeliveryState(sessionID, channel)
+	vh.mu.Unlock()
+
+	// settle the acks on a copy of the ledger, so an unknown delivery tag
+	// leaves the channel untouched
+	state.mu.Lock()
+	ledger := make(map[uint64]*UnackedMessage, len(state.Unacked))
+	for tag, entry := range state.Unacked {
+		ledger[tag] = entry
+	}
+	var released, requeued, rejected, settled []*UnackedMessage
+	for _, ack := range acks {
+		var (
+			entries []*UnackedMessage
+			nack    bool
+			requeue bool
+			err     error
+		)
+		switch content := ack.Content.(type) {
+		case *message.BasicAckMessage:
+			entries, err = takeFromLedger(ledger, content.DeliveryTag, content.Multiple)
+		case *message.BasicNackMessage:
+			entries, err = takeFromLedger(ledger, content.DeliveryTag, content.Multiple)
+			nack, requeue = true, content.Requeue
+		case *message.BasicRejectMessage:
+			entries, err = takeFromLedger(ledger, content.DeliveryTag, false)
+			nack, requeue = true, content.Requeue
+		default:
+			err = fmt.Errorf("unexpected acknowledgement %T", ack.Content)
+		}
+		if err != nil {
+			state.mu.Unlock()
+			return err
+		}
+		released = append(released, entries...)
+		switch {
+		case requeue:
+			requeued = append(requeued, entries...)
+		case nack:
+			rejected = append(rejected, entries...)
+		default:
+			settled = append(settled, entries...)
+		}
+	}
+	// persistent messages are on disk before the commit is confirmed
+	messages := make([]amqp.Message, len(publishes))
+	now := time.Now()
+	for i, queues := range routes {
+		messages[i] = publishes[i]
+		messages[i].ID = uuid.New().String()
+		messages[i].EnqueuedAt = now
+		if err := vh.storeMessage(queues, messages[i]); err != nil {
+			state.mu.Unlock()
+			return err
+		}
+	}
+	state.Unacked = ledger
+	state.mu.Unlock()
+
+	// consumers pop under the vhost mutex, so they see all or none of the
+	// committed messages
+	type offer struct {
+		queue    *Queue
+		msg      amqp.Message
+		accepted bool
+		dropped  []amqp.Message
+	}
+	var offers []offer
+	touched := make(map[*Queue]bool)
+	vh.mu.Lock()
+	for i, queues := range routes {
+		msg := messages[i]
+		for _, queue := range queues {
+			accepted, dropped := queue.Offer(msg)
+			if accepted {
+				touched[queue] = true
+			}
+			if !accepted || len(dropped) > 0 {
+				offers = append(offers, offer{queue, msg, accepted, dropped})
+			}
+		}
+	}
+	vh.mu.Unlock()
+	// publishes refused by a full queue are dropped; the transaction is
+	// still committed
+	for _, o := range offers {
+		vh.settleOffer(o.queue, o.msg, o.accepted, o.dropped)
+	}
+	log.Printf("[DEBUG] Committed %d publish(es) and %d ack(s) on channel %d", len(publishes), len(acks), channel)
+
+	vh.forgetUnacked(settled)
+	vh.rejectUnacked(rejected)
+	sortUnacked(requeued)
+	vh.requeueUnacked(requeued)
+	vh.releaseUnacked(sessionID, channel, released)
+	for queue := range touched {
+		vh.scheduleExpiry(queue)
+		vh.dispatch(queue)
+	}
+	return nil
+}
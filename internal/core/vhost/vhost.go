@@ -1,8 +1,11 @@
 package vhost
 
 import (
+	"net"
 	"sync"
 
+	"github.com/andrelcunha/ottermq/internal/core"
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
 	"github.com/andrelcunha/ottermq/pkg/persistdb"
 	"github.com/google/uuid"
 )
@@ -15,12 +18,17 @@ type VHost struct {
 	Exchanges map[string]*Exchange       `json:"exchanges"`
 	Queues    map[string]*Queue          `json:"queues"`
 	Users     map[string]*persistdb.User `json:"users"`
+	// configure, write and read permissions by username
+	Permissions map[string]*Permissions `json:"-"`
+	// topic permissions by username and exchange
+	TopicPermissions map[string]map[string]*TopicPermissions `json:"-"`
 
-	// UnackMsgs         map[string]map[string]bool `json:"unacked_messages"`
-	Consumers         map[string]*Consumer       `json:"consumers"`
-	ConsumerSessions  map[string]string          `json:"consumer_sessions"`
-	ConsumerUnackMsgs map[string]map[string]bool `json:"consumer_unacked_messages"`
-	mu                sync.Mutex                 `json:"-"`
+	Consumers         map[string]*Consumer             `json:"consumers"`
+	ConsumerSessions  map[string]map[string]bool       `json:"consumer_sessions"`
+	ChannelDeliveries map[string]*ChannelDeliveryState `json:"-"`
+	mu                sync.Mutex                       `json:"-"`
+	store             *core.MessageStore               // persistent messages of durable queues, if any
+	topology          topologyWrites                   // changes to the persisted topology not written yet
 }
 
 type Exchange struct {
@@ -28,34 +36,77 @@ type Exchange struct {
 	Queues   map[string]*Queue   `json:"queues"`
 	Typ      ExchangeType        `json:"type"`
 	Bindings map[string][]*Queue `json:"bindings"`
+	Topics   *TopicTrie          `json:"-"` // bindings of a topic exchange
+	// bindings of a headers exchange
+	HeaderBindings []*HeadersBinding `json:"-"`
+
+	Durable    bool                   `json:"durable"`
+	AutoDelete bool                   `json:"auto_delete"` // deleted once its last binding is removed
+	Internal   bool                   `json:"internal"`
+	Arguments  map[string]interface{} `json:"arguments"`
 }
 
 type ExchangeType string
 
 const (
-	DIRECT ExchangeType = "direct"
-	FANOUT ExchangeType = "fanout"
+	DIRECT  ExchangeType = "direct"
+	FANOUT  ExchangeType = "fanout"
+	TOPIC   ExchangeType = "topic"
+	HEADERS ExchangeType = "headers"
 )
 
 type Consumer struct {
-	ID        string `json:"id"`
-	Queue     string `json:"queue"`
-	SessionID string `json:"session_id"`
+	ID            string   `json:"id"` // consumer tag
+	Queue         string   `json:"queue"`
+	SessionID     string   `json:"session_id"`
+	Channel       uint16   `json:"channel"`
+	NoAck         bool     `json:"no_ack"`
+	Exclusive     bool     `json:"exclusive"`
+	PrefetchCount uint16   `json:"prefetch_count"` // 0 means unlimited
+	Conn          net.Conn `json:"-"`
+	active        bool
+	unacked       int // guarded by the vhost mutex
+}
+
+// ChannelDeliveryState keeps the per-channel delivery tag sequence and the
+// messages delivered on the channel that are still waiting for an ack.
+// Deliveries on the same channel are serialized by its mutex.
+type ChannelDeliveryState struct {
+	LastDeliveryTag uint64
+	Unacked         map[uint64]*UnackedMessage
+	mu              sync.Mutex
+
+	// basic.qos settings and the consumer deliveries counted against them,
+	// guarded by the vhost mutex
+	PrefetchCount       uint16 // applied to consumers started afterwards
+	GlobalPrefetchCount uint16 // shared by all consumers on the channel
+	consumerUnacked     int
+}
+
+// UnackedMessage is a delivered message that goes back to Queue if the
+// channel closes before it is acknowledged.
+type UnackedMessage struct {
+	DeliveryTag uint64
+	ConsumerTag string // empty for basic.get
+	Queue       *Queue
+	Message     amqp.Message
+	consumer    *Consumer
 }
 
 func NewVhost(vhostName string) *VHost {
 	// generate a random id
 	id := uuid.New().String()
 	vh := &VHost{
-		Name:      vhostName,
-		Id:        id,
-		Exchanges: make(map[string]*Exchange),
-		Queues:    make(map[string]*Queue),
-		Users:     make(map[string]*persistdb.User),
-		// UnackMsgs:         make(map[string]map[string]bool),
+		Name:              vhostName,
+		Id:                id,
+		Exchanges:         make(map[string]*Exchange),
+		Queues:            make(map[string]*Queue),
+		Users:             make(map[string]*persistdb.User),
+		Permissions:       make(map[string]*Permissions),
+		TopicPermissions:  make(map[string]map[string]*TopicPermissions),
 		Consumers:         make(map[string]*Consumer),
-		ConsumerSessions:  make(map[string]string),
-		ConsumerUnackMsgs: make(map[string]map[string]bool),
+		ConsumerSessions:  make(map[string]map[string]bool),
+		ChannelDeliveries: make(map[string]*ChannelDeliveryState),
 		// config:            config,
 	}
 	vh.CreateExchange(default_exchange, DIRECT)
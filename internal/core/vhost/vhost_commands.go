@@ -1,51 +1,139 @@
 package vhost
 
 import (
+	"encoding/base64"
 	"fmt"
 	"log"
 	"net"
+	"time"
 
 	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
 	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp/message"
+	"github.com/andrelcunha/ottermq/pkg/connection/constants"
 	"github.com/google/uuid"
 )
 
-func (vh *VHost) subscribe(consumerID, queueName string) {
+// QueueOptions holds the properties of a queue set by queue.declare
+type QueueOptions struct {
+	Durable    bool
+	Exclusive  bool
+	AutoDelete bool
+	Arguments  map[string]interface{}
+	// the name was generated by the server: the queue belongs to the
+	// declaring connection
+	ServerNamed bool
+}
+
+// DeclareQueue implements queue.declare. A passive declare only checks that
+// the queue exists. Declaring an existing queue succeeds if the options are
+// equivalent to the ones it was created with. An exclusive queue belongs to
+// the declaring connection and is deleted when the connection closes.
+// An empty name asks the server to name the queue; such a queue also lives
+// only as long as the declaring connection.
+func (vh *VHost) DeclareQueue(conn net.Conn, name string, passive bool, opts QueueOptions) (*Queue, error) {
+	defer vh.writeTopology()
 	vh.mu.Lock()
 	defer vh.mu.Unlock()
-	// Create a new queue if it doesn't exist
-	if _, ok := vh.Queues[queueName]; !ok {
-		vh.CreateQueue(queueName)
+	sessionID := SessionID(conn)
+
+	serverNamed := opts.ServerNamed && !passive
+	if name == "" && !passive {
+		name = vh.generateQueueName()
+		serverNamed = true
 	}
-	if consumer, ok := vh.Consumers[consumerID]; ok {
-		consumer.Queue = queueName
+	queue, ok := vh.Queues[name]
+	if !ok {
+		if passive {
+			return nil, amqp.NewError(constants.NOT_FOUND, "no queue '%s' in vhost '%s'", name, vh.Name)
+		}
+		queue = NewQueue(name)
+		queue.Durable = opts.Durable
+		queue.Exclusive = opts.Exclusive
+		queue.AutoDelete = opts.AutoDelete
+		if err := queue.applyArguments(vh.Name, opts.Arguments); err != nil {
+			return nil, err
+		}
+		if opts.Exclusive || serverNamed {
+			queue.owner = sessionID
+		}
+		vh.Queues[name] = queue
+		// every queue is bound to the default exchange under its name
+		defaultExchange := vh.Exchanges[default_exchange]
+		defaultExchange.Bindings[name] = append(defaultExchange.Bindings[name], queue)
+		vh.saveQueue(queue)
+		return queue, nil
+	}
+
+	if err := vh.checkQueueOwner(queue, sessionID); err != nil {
+		return nil, err
 	}
+	if passive {
+		return queue, nil
+	}
+	if err := queue.checkEquivalent(vh.Name, opts); err != nil {
+		return nil, err
+	}
+	return queue, nil
 }
 
-// acknowledge removes the message with the given ID frrom the unackedMessages map.
-func (b *VHost) acknowledge(consumerID, msgID string) error {
-	b.mu.Lock()
-	defer b.mu.Unlock()
+// NewQueueName returns an unused name for a server-named queue
+func (vh *VHost) NewQueueName() string {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	return vh.generateQueueName()
+}
 
-	// if b.UnackMsgs[queueName] == nil {
-	// 	return fmt.Errorf("no unack messages for queue %s", queueName)
-	// }
-	// if _, ok := b.UnackMsgs[queueName][msgID]; !ok {
-	// 	return fmt.Errorf("Message ID %s not found in unack messages", msgID)
-	// }
-	// delete(b.UnackMsgs[queueName], msgID)
-	delete(b.ConsumerUnackMsgs[consumerID], msgID)
-	// b.saveBrokerState()
-	// if b.UnackMsgs[queueName] == nil {
-	// 	return fmt.Errorf("no unack messages for queue %s", queueName)
-	// }
-	// if _, ok := b.UnackMsgs[queueName][msgID]; !ok {
-	// 	return fmt.Errorf("Message ID %s not found in unack messages", msgID)
-	// }
-	// delete(b.UnackMsgs[queueName], msgID)
+// generateQueueName returns an unused name for a server-named queue.
+// It must be called with the vhost mutex held.
+func (vh *VHost) generateQueueName() string {
+	for {
+		id := uuid.New()
+		name := "amq.gen-" + base64.RawURLEncoding.EncodeToString(id[:])
+		if _, ok := vh.Queues[name]; !ok {
+			return name
+		}
+	}
+}
+
+// checkEquivalent compares the options of a redeclare to the queue's
+func (q *Queue) checkEquivalent(vhostName string, opts QueueOptions) error {
+	inequivalent := func(arg string, received, current interface{}) error {
+		return amqp.NewError(constants.PRECONDITION_FAILED,
+			"inequivalent arg '%s' for queue '%s' in vhost '%s': received '%v' but current is '%v'",
+			arg, q.Name, vhostName, received, current)
+	}
+	if opts.Durable != q.Durable {
+		return inequivalent("durable", opts.Durable, q.Durable)
+	}
+	if opts.Exclusive != q.Exclusive {
+		return inequivalent("exclusive", opts.Exclusive, q.Exclusive)
+	}
+	if opts.AutoDelete != q.AutoDelete {
+		return inequivalent("auto_delete", opts.AutoDelete, q.AutoDelete)
+	}
+	if !headersArgsEqual(opts.Arguments, q.Arguments) {
+		return inequivalent("arguments", opts.Arguments, map[string]interface{}(q.Arguments))
+	}
 	return nil
 }
 
+// checkQueueOwner refuses access to an exclusive queue from any connection
+// other than the one that declared it
+func (vh *VHost) checkQueueOwner(queue *Queue, sessionID string) error {
+	if queue.Exclusive && queue.owner != sessionID {
+		return amqp.NewError(constants.RESOURCE_LOCKED,
+			"cannot obtain exclusive access to locked queue '%s' in vhost '%s'", queue.Name, vh.Name)
+	}
+	return nil
+}
+
+// GetConsumerCount returns the number of consumers of the queue
+func (vh *VHost) GetConsumerCount(queue *Queue) int {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	return len(queue.consumers)
+}
+
 func (vh *VHost) CreateQueue(name string) (*Queue, error) {
 	vh.mu.Lock()
 	defer vh.mu.Unlock()
@@ -65,143 +153,385 @@ func (vh *VHost) GetMessageCount(name string) (int, error) {
 	defer vh.mu.Unlock()
 	queue, ok := vh.Queues[name]
 	if !ok {
-		return 0, fmt.Errorf("queue %s not found", name)
+		return 0, amqp.NewError(constants.NOT_FOUND, "no queue '%s' in vhost '%s'", name, vh.Name)
 	}
 	return queue.Len(), nil
 
 }
 
-func (b *VHost) Publish(exchangeName, routingKey string, body []byte, props *message.BasicProperties) (string, error) {
+// Publish routes the message to the queues bound to the exchange. On a topic
+// exchange, the routing key must be granted by the user's topic permissions.
+func (b *VHost) Publish(username, exchangeName, routingKey string, body []byte, props *message.BasicProperties) (string, error) {
+	if err := CheckExpiration(props.Expiration); err != nil {
+		return "", err
+	}
+	msg := newMessage(exchangeName, routingKey, body, props)
+
+	// // Save message to file
+	// err := b.saveMessage(routingKey, msg)
+	// if err != nil {
+	// 	log.Printf("Failed to save message to file: %v", err)
+	// 	return "", err
+	// }
+
 	b.mu.Lock()
-	exchange, ok := b.Exchanges[exchangeName]
+	if err := b.checkTopicPermission(username, WriteAccess, exchangeName, routingKey); err != nil {
+		b.mu.Unlock()
+		return "", err
+	}
+	queues, err := b.route(exchangeName, routingKey, props.Headers)
 	b.mu.Unlock()
-	if !ok {
-		log.Printf("Exchange %s not found", exchangeName)
-		return "", fmt.Errorf("Exchange %s not found", exchangeName)
+	if err != nil {
+		return "", err
+	}
+	msg.EnqueuedAt = time.Now()
+	// persistent messages are on disk before they are confirmed
+	if err := b.storeMessage(queues, msg); err != nil {
+		return "", err
 	}
-	msgID := uuid.New().String()
-	msg := amqp.Message{
-		ID:         msgID,
+	if len(queues) == 0 {
+		// unroutable messages are dropped
+		log.Printf("Routing key %s not found for exchange %s", routingKey, exchangeName)
+		return msg.ID, nil
+	}
+	rejected := false
+	for _, queue := range queues {
+		if !b.enqueue(queue, msg) {
+			log.Printf("Queue %s is full, message %s rejected", queue.Name, msg.ID)
+			rejected = true
+			continue
+		}
+		b.scheduleExpiry(queue)
+		b.dispatch(queue)
+	}
+	if rejected {
+		return msg.ID, ErrPublishRejected
+	}
+	return msg.ID, nil
+}
+
+func newMessage(exchangeName, routingKey string, body []byte, props *message.BasicProperties) amqp.Message {
+	return amqp.Message{
+		ID:         uuid.New().String(),
 		Body:       body,
 		Properties: *props,
 		Exchange:   exchangeName,
 		RoutingKey: routingKey,
 	}
+}
 
-	// // Save message to file
-	// err := b.saveMessage(routingKey, msg)
-	// if err != nil {
-	// 	log.Printf("Failed to save message to file: %v", err)
-	// 	return "", err
-	// }
+// route returns the queues a message published to the exchange with the
+// routing key and headers goes to. It must be called with the vhost mutex held.
+func (b *VHost) route(exchangeName, routingKey string, headers map[string]interface{}) ([]*Queue, error) {
+	lookupName := exchangeName
+	if lookupName == "" {
+		lookupName = default_exchange
+	}
+	exchange, ok := b.Exchanges[lookupName]
+	if !ok {
+		log.Printf("Exchange %s not found", exchangeName)
+		return nil, amqp.NewError(constants.NOT_FOUND, "no exchange '%s' in vhost '%s'", exchangeName, b.Name)
+	}
 
 	switch exchange.Typ {
 	case DIRECT:
-		queues, ok := exchange.Bindings[routingKey]
-		if ok {
-			for _, queue := range queues {
-				queue.Push(msg)
-			}
-			return msgID, nil
-		} else {
-			log.Printf("Routing key %s not found for exchange %s", routingKey, exchangeName)
-			return "", fmt.Errorf("Routing key %s not found for exchange %s", routingKey, exchangeName)
-		}
+		return append([]*Queue(nil), exchange.Bindings[routingKey]...), nil
 	case FANOUT:
+		queues := make([]*Queue, 0, len(exchange.Queues))
 		for _, queue := range exchange.Queues {
-			queue.Push(msg)
+			queues = append(queues, queue)
 		}
-		return msgID, nil
+		return queues, nil
+	case TOPIC:
+		return exchange.Topics.Match(routingKey), nil
+	case HEADERS:
+		return matchHeaders(exchange.HeaderBindings, headers), nil
 	}
-	return "", fmt.Errorf("Unknown exchange type")
+	return nil, fmt.Errorf("Unknown exchange type")
 }
 
-// func (b *Broker) GetMessage(queueName string) <-chan Message {
-func (b *VHost) GetMessage(queueName string) *amqp.Message {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	queue, ok := b.Queues[queueName]
+// GetMessage pops the next message for basic.get. Unless noAck is set, the
+// message is kept in the channel's unacked ledger under the returned
+// delivery tag.
+func (vh *VHost) GetMessage(conn net.Conn, channel uint16, queueName string, noAck bool) (*amqp.Message, uint64, error) {
+	vh.mu.Lock()
+	queue, ok := vh.Queues[queueName]
 	if !ok {
+		vh.mu.Unlock()
 		log.Printf("Queue %s not found", queueName)
-		return nil
+		return nil, 0, amqp.NewError(constants.NOT_FOUND, "no queue '%s' in vhost '%s'", queueName, vh.Name)
 	}
-	// select {
-	// case msg := <-queue.messages:
-	// 	if b.UnackMsgs[queueName] == nil {
-	// 		b.UnackMsgs[queueName] = make(map[string]bool)
-	// 	}
-	// 	b.UnackMsgs[queueName][msg.ID] = true
-	// 	b.ConsumerUnackMsgs[consumerID][msg.ID] = true
-	// 	return &msg
-	// default:
-	// 	log.Printf("No messages in queue %s", queueName)
-	// 	return nil
-	// }
-
-	msg := queue.Pop()
+	if err := vh.checkQueueOwner(queue, SessionID(conn)); err != nil {
+		vh.mu.Unlock()
+		return nil, 0, err
+	}
+	state := vh.getChannelDeliveryState(SessionID(conn), channel)
+	msg, expired := vh.popMessage(queue)
+	vh.mu.Unlock()
+	vh.expireMessages(queue, expired)
 	if msg == nil {
 		log.Printf("No messages in queue %s", queueName)
-		return nil
+		return nil, 0, nil
+	}
+	if noAck {
+		vh.forgetMessage(queue, msg)
 	}
 
-	// if b.UnackMsgs[queueName] == nil {
-	// 	b.UnackMsgs[queueName] = make(map[string]bool)
-	// }
-	// b.ConsumerUnackMsgs[consumerID][msg.ID] = true
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.LastDeliveryTag++
+	if !noAck {
+		state.Unacked[state.LastDeliveryTag] = &UnackedMessage{
+			DeliveryTag: state.LastDeliveryTag,
+			Queue:       queue,
+			Message:     *msg,
+		}
+	}
+	return msg, state.LastDeliveryTag, nil
+}
+
+// DeleteQueue deletes the queue along with its messages, bindings and
+// consumers, and returns the number of messages it held. With ifUnused the
+// queue must have no consumers, with ifEmpty it must have no messages.
+func (vh *VHost) DeleteQueue(conn net.Conn, name string, ifUnused, ifEmpty bool) (uint32, error) {
+	defer vh.writeTopology()
+	vh.mu.Lock()
+	queue, ok := vh.Queues[name]
+	if !ok {
+		vh.mu.Unlock()
+		return 0, amqp.NewError(constants.NOT_FOUND, "no queue '%s' in vhost '%s'", name, vh.Name)
+	}
+	if err := vh.checkQueueOwner(queue, SessionID(conn)); err != nil {
+		vh.mu.Unlock()
+		return 0, err
+	}
+	if ifUnused && len(queue.consumers) > 0 {
+		vh.mu.Unlock()
+		return 0, amqp.NewError(constants.PRECONDITION_FAILED, "queue '%s' in vhost '%s' in use", name, vh.Name)
+	}
+	if ifEmpty && queue.Len() > 0 {
+		vh.mu.Unlock()
+		return 0, amqp.NewError(constants.PRECONDITION_FAILED, "queue '%s' in vhost '%s' not empty", name, vh.Name)
+	}
+	count, consumers := vh.removeQueue(queue)
+	vh.mu.Unlock()
 
-	return msg
+	for _, consumer := range consumers {
+		vh.notifyConsumerCancel(consumer)
+	}
+	return uint32(count), nil
 }
 
-func (b *VHost) deleteQueue(name string) error {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	// Check if the queue exists
-	_, ok := b.Queues[name]
+// removeQueue deletes the queue with its messages, bindings and consumers.
+// It returns the number of messages dropped and the consumers removed. It
+// must be called with the vhost mutex held.
+func (vh *VHost) removeQueue(queue *Queue) (int, []*Consumer) {
+	// the queue leaves the vhost first, so removing its consumers does not
+	// trigger an auto-delete
+	delete(vh.Queues, queue.Name)
+	consumers := append([]*Consumer(nil), queue.consumers...)
+	for _, consumer := range consumers {
+		vh.removeConsumer(consumerKey(consumer.SessionID, consumer.Channel, consumer.ID))
+	}
+	queue.consumers = nil
+	vh.removeQueueBindings(queue)
+	vh.forgetQueue(queue)
+	vh.forgetQueueDefinition(queue)
+	count := queue.Purge()
+	log.Printf("[DEBUG] Queue %s deleted", queue.Name)
+	return count, consumers
+}
+
+// PurgeQueue removes the messages of the queue that were not delivered yet
+// and returns how many there were
+func (vh *VHost) PurgeQueue(conn net.Conn, name string) (uint32, error) {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	queue, ok := vh.Queues[name]
 	if !ok {
-		return fmt.Errorf("queue %s not found", name)
+		return 0, amqp.NewError(constants.NOT_FOUND, "no queue '%s' in vhost '%s'", name, vh.Name)
+	}
+	if err := vh.checkQueueOwner(queue, SessionID(conn)); err != nil {
+		return 0, err
 	}
+	messages := queue.Drain()
+	for i := range messages {
+		vh.forgetMessage(queue, &messages[i])
+	}
+	return uint32(len(messages)), nil
+}
 
-	delete(b.Queues, name)
-	// b.saveBrokerState()
-	return nil
+// removeQueueBindings removes the queue from every exchange of the vhost.
+// An auto-delete exchange goes away when it loses its last binding that way.
+// It must be called with the vhost mutex held.
+func (vh *VHost) removeQueueBindings(queue *Queue) {
+	for _, exchange := range vh.Exchanges {
+		unbound := false
+		for routingKey, queues := range exchange.Bindings {
+			kept := queues[:0]
+			for _, q := range queues {
+				if q != queue {
+					kept = append(kept, q)
+				}
+			}
+			unbound = unbound || len(kept) < len(queues)
+			if len(kept) == 0 {
+				delete(exchange.Bindings, routingKey)
+			} else {
+				exchange.Bindings[routingKey] = kept
+			}
+		}
+		if _, ok := exchange.Queues[queue.Name]; ok {
+			delete(exchange.Queues, queue.Name)
+			unbound = true
+		}
+		if exchange.Topics != nil && exchange.Topics.UnbindQueue(queue.Name) {
+			unbound = true
+		}
+		kept := exchange.HeaderBindings[:0]
+		for _, binding := range exchange.HeaderBindings {
+			if binding.Queue != queue {
+				kept = append(kept, binding)
+			}
+		}
+		unbound = unbound || len(kept) < len(exchange.HeaderBindings)
+		exchange.HeaderBindings = kept
+		if unbound {
+			vh.autoDeleteExchange(exchange)
+		}
+	}
 }
 
-func (vh *VHost) CreateExchange(name string, typ ExchangeType) error {
+// hasBindings tells whether any queue is bound to the exchange
+func (e *Exchange) hasBindings() bool {
+	if len(e.Bindings) > 0 || len(e.Queues) > 0 || len(e.HeaderBindings) > 0 {
+		return true
+	}
+	return e.Topics != nil && len(e.Topics.Bindings()) > 0
+}
+
+// autoDeleteExchange deletes an auto-delete exchange once its last binding
+// is gone. It must be called with the vhost mutex held.
+func (vh *VHost) autoDeleteExchange(exchange *Exchange) {
+	if !exchange.AutoDelete || exchange.hasBindings() {
+		return
+	}
+	delete(vh.Exchanges, exchange.Name)
+	vh.forgetExchange(exchange)
+	log.Printf("[DEBUG] Auto-delete exchange %s deleted", exchange.Name)
+}
+
+// ExchangeOptions holds the properties of an exchange set by exchange.declare
+type ExchangeOptions struct {
+	Durable    bool
+	AutoDelete bool
+	Internal   bool
+	Arguments  map[string]interface{}
+}
+
+// DeclareExchange implements exchange.declare. A passive declare only checks
+// that the exchange exists. Declaring an existing exchange succeeds if the
+// type and options are equivalent to the ones it was created with.
+func (vh *VHost) DeclareExchange(name string, typ ExchangeType, passive bool, opts ExchangeOptions) error {
+	defer vh.writeTopology()
 	vh.mu.Lock()
 	defer vh.mu.Unlock()
-	// Check if the exchange already exists
-	if _, ok := vh.Exchanges[name]; ok {
-		return fmt.Errorf("exchange %s already exists", name)
+	lookupName := name
+	if lookupName == "" {
+		lookupName = default_exchange
+	}
+
+	exchange, ok := vh.Exchanges[lookupName]
+	if !ok {
+		if passive {
+			return amqp.NewError(constants.NOT_FOUND, "no exchange '%s' in vhost '%s'", name, vh.Name)
+		}
+		switch typ {
+		case DIRECT, FANOUT, TOPIC, HEADERS:
+		default:
+			return amqp.NewError(constants.COMMAND_INVALID, "unknown exchange type '%s'", typ)
+		}
+		exchange = newExchange(name, typ)
+		exchange.Durable = opts.Durable
+		exchange.AutoDelete = opts.AutoDelete
+		exchange.Internal = opts.Internal
+		exchange.Arguments = opts.Arguments
+		vh.Exchanges[name] = exchange
+		vh.saveExchange(exchange)
+		return nil
+	}
+	if passive {
+		return nil
+	}
+
+	inequivalent := func(arg string, received, current interface{}) error {
+		return amqp.NewError(constants.PRECONDITION_FAILED,
+			"inequivalent arg '%s' for exchange '%s' in vhost '%s': received '%v' but current is '%v'",
+			arg, name, vh.Name, received, current)
+	}
+	if typ != exchange.Typ {
+		return inequivalent("type", typ, exchange.Typ)
+	}
+	if opts.Durable != exchange.Durable {
+		return inequivalent("durable", opts.Durable, exchange.Durable)
 	}
+	if opts.AutoDelete != exchange.AutoDelete {
+		return inequivalent("auto_delete", opts.AutoDelete, exchange.AutoDelete)
+	}
+	if opts.Internal != exchange.Internal {
+		return inequivalent("internal", opts.Internal, exchange.Internal)
+	}
+	if !headersArgsEqual(opts.Arguments, exchange.Arguments) {
+		return inequivalent("arguments", opts.Arguments, exchange.Arguments)
+	}
+	return nil
+}
 
+func newExchange(name string, typ ExchangeType) *Exchange {
 	exchange := &Exchange{
 		Name:     name,
 		Typ:      typ,
 		Queues:   make(map[string]*Queue),
 		Bindings: make(map[string][]*Queue),
 	}
-	vh.Exchanges[name] = exchange
+	if typ == TOPIC {
+		exchange.Topics = NewTopicTrie()
+	}
+	return exchange
+}
+
+func (vh *VHost) CreateExchange(name string, typ ExchangeType) error {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	// Check if the exchange already exists
+	if _, ok := vh.Exchanges[name]; ok {
+		return fmt.Errorf("exchange %s already exists", name)
+	}
+
+	vh.Exchanges[name] = newExchange(name, typ)
 	return nil
 }
 
 func (vh *VHost) DeleteExchange(name string) error {
+	defer vh.writeTopology()
 	vh.mu.Lock()
 	defer vh.mu.Unlock()
-	// If the exchange is the default exchange, return an error
-	if name == "default" {
-		return fmt.Errorf("cannot delete default exchange")
+	if name == "" || name == default_exchange {
+		return amqp.NewError(constants.ACCESS_REFUSED, "operation not permitted on the default exchange")
 	}
-
-	// Check if the exchange exists
-	_, ok := vh.Exchanges[name]
+	exchange, ok := vh.Exchanges[name]
 	if !ok {
-		return fmt.Errorf("exchange %s not found", name)
+		return amqp.NewError(constants.NOT_FOUND, "no exchange '%s' in vhost '%s'", name, vh.Name)
 	}
 	delete(vh.Exchanges, name)
+	vh.forgetExchange(exchange)
 	return nil
 }
 
-func (vh *VHost) BindQueue(exchangeName, queueName, routingKey string) error {
+// BindQueue binds the queue to the exchange. The arguments are only used by
+// headers exchanges, where they hold the headers to match.
+func (vh *VHost) BindQueue(username, exchangeName, queueName, routingKey string, args map[string]interface{}) error {
+	defer vh.writeTopology()
 	vh.mu.Lock()
 	defer vh.mu.Unlock()
 	if exchangeName == "" {
@@ -211,114 +541,147 @@ func (vh *VHost) BindQueue(exchangeName, queueName, routingKey string) error {
 	// Find the exchange
 	exchange, ok := vh.Exchanges[exchangeName]
 	if !ok {
-		return fmt.Errorf("Exchange %s not found", exchangeName)
+		return amqp.NewError(constants.NOT_FOUND, "no exchange '%s' in vhost '%s'", exchangeName, vh.Name)
 	}
 
 	// Find the queue
 	queue, ok := vh.Queues[queueName]
 	if !ok {
-		return fmt.Errorf("Queue %s not found", queueName)
+		return amqp.NewError(constants.NOT_FOUND, "no queue '%s' in vhost '%s'", queueName, vh.Name)
 	}
+	if err := vh.checkTopicPermission(username, ReadAccess, exchangeName, routingKey); err != nil {
+		return err
+	}
+	if err := vh.bind(exchange, queue, routingKey, args); err != nil {
+		return err
+	}
+	vh.saveBinding(exchange, queue, routingKey, args)
+	return nil
+}
 
+// bind adds the binding to the exchange; binding twice is a no-op. It must
+// be called with the vhost mutex held.
+func (vh *VHost) bind(exchange *Exchange, queue *Queue, routingKey string, args map[string]interface{}) error {
 	switch exchange.Typ {
 	case DIRECT:
 		for _, q := range exchange.Bindings[routingKey] {
-			if q.Name == queueName {
-				return fmt.Errorf("Queue %s already binded to exchange %s using routing key %s", queueName, exchangeName, routingKey)
+			if q.Name == queue.Name {
+				return nil
 			}
 		}
 
 		exchange.Bindings[routingKey] = append(exchange.Bindings[routingKey], queue)
 	case FANOUT:
-		exchange.Queues[queueName] = queue
+		exchange.Queues[queue.Name] = queue
+	case TOPIC:
+		exchange.Topics.Bind(routingKey, queue)
+	case HEADERS:
+		if _, err := headersMatchMode(args); err != nil {
+			return err
+		}
+		for _, binding := range exchange.HeaderBindings {
+			if binding.Queue.Name == queue.Name && headersArgsEqual(binding.Arguments, args) {
+				return nil
+			}
+		}
+		exchange.HeaderBindings = append(exchange.HeaderBindings, &HeadersBinding{
+			Queue:     queue,
+			Arguments: args,
+		})
 	}
-
-	// // Persist the state
-	// err := b.saveBrokerState()
-	// if err != nil {
-	// 	log.Printf("Failed to save broker state: %v", err)
-	// }
 	return nil
 }
 
+// GetTopicBindings returns the patterns of a topic exchange with the queues
+// bound under them
+func (vh *VHost) GetTopicBindings(exchange *Exchange) map[string][]string {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	return exchange.Topics.Bindings()
+}
+
+// GetHeadersBindings returns the queues bound to a headers exchange, keyed by
+// their binding arguments
+func (vh *VHost) GetHeadersBindings(exchange *Exchange) map[string][]string {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	bindings := make(map[string][]string)
+	for _, binding := range exchange.HeaderBindings {
+		key := headersBindingKey(binding.Arguments)
+		bindings[key] = append(bindings[key], binding.Queue.Name)
+	}
+	return bindings
+}
+
 // bindToDefaultExchange binds a queue to the default exchange using the queue name as the routing key.
 func (vh *VHost) BindToDefaultExchange(queueName string) error {
-	return vh.BindQueue(default_exchange, queueName, queueName)
+	return vh.BindQueue("", default_exchange, queueName, queueName, nil)
 }
 
-func (b *VHost) DeletBinding(exchangeName, queueName, routingKey string) error {
+// DeletBinding removes the binding of the queue to the exchange. The routing
+// key identifies the binding on direct and topic exchanges, the arguments on
+// headers exchanges; a fanout exchange has a single binding per queue.
+func (b *VHost) DeletBinding(exchangeName, queueName, routingKey string, args map[string]interface{}) error {
+	defer b.writeTopology()
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	if exchangeName == "" || exchangeName == default_exchange {
+		return amqp.NewError(constants.ACCESS_REFUSED, "operation not permitted on the default exchange")
+	}
 	// Find the exchange
 	exchange, ok := b.Exchanges[exchangeName]
 	if !ok {
-		return fmt.Errorf("exchange %s not found", exchangeName)
+		return amqp.NewError(constants.NOT_FOUND, "no exchange '%s' in vhost '%s'", exchangeName, b.Name)
 	}
-
-	queues, ok := exchange.Bindings[routingKey]
+	queue, ok := b.Queues[queueName]
 	if !ok {
-		return fmt.Errorf("Binding with routing key %s not found", routingKey)
+		return amqp.NewError(constants.NOT_FOUND, "no queue '%s' in vhost '%s'", queueName, b.Name)
 	}
+	notFound := amqp.NewError(constants.NOT_FOUND, "no binding %s between exchange '%s' and queue '%s' in vhost '%s'",
+		routingKey, exchangeName, queueName, b.Name)
 
-	// Find the queue
-	var index int
-	found := false
-	for i, q := range queues {
-		if q.Name == queueName {
-			index = i
-			found = true
-			break
+	switch exchange.Typ {
+	case DIRECT:
+		queues := exchange.Bindings[routingKey]
+		index := -1
+		for i, q := range queues {
+			if q == queue {
+				index = i
+				break
+			}
 		}
-	}
-	if !found {
-		return fmt.Errorf("Queue %s not found", queueName)
-	}
-
-	// Remove the queue from the bindings
-	exchange.Bindings[routingKey] = append(queues[:index], queues[index+1:]...)
-	if len(exchange.Bindings[routingKey]) == 0 {
-		delete(exchange.Bindings, routingKey)
-	}
-
-	// // Persist the state
-	// err := b.saveBrokerState()
-	// if err != nil {
-	// 	log.Printf("Failed to save broker state: %v", err)
-	// }
-
-	return nil
-}
-
-// func (vh *VHost) RegisterSessionAndConsummer(sessionID, consumerID string) string {
-// 	// sessionID := generateSessionID()
-// 	// consumerID := conn.RemoteAddr().String()
-
-// 	vh.registerConsumer(consumerID, "default", sessionID)
-// 	log.Println("New connection registered")
-// 	return consumerID
-// }
-
-// func (b *VHost) registerConsumer(consumerID, queue, sessionID string) {
-// 	b.mu.Lock()
-// 	defer b.mu.Unlock()
-// 	consumer := &Consumer{
-// 		ID:        consumerID,
-// 		Queue:     queue,
-// 		SessionID: sessionID,
-// 	}
-// 	b.Consumers[consumerID] = consumer
-// 	b.ConsumerSessions[sessionID] = consumerID
-// 	b.ConsumerUnackMsgs[consumerID] = make(map[string]bool)
-// }
-
-func (vh *VHost) getSessionID(conn net.Conn) (string, bool) {
-	vh.mu.Lock()
-	defer vh.mu.Unlock()
-	for sessionID, consumerID := range vh.ConsumerSessions {
-		if conn.RemoteAddr().String() == consumerID {
-			return sessionID, true
+		if index < 0 {
+			return notFound
+		}
+		// Remove the queue from the bindings
+		exchange.Bindings[routingKey] = append(queues[:index], queues[index+1:]...)
+		if len(exchange.Bindings[routingKey]) == 0 {
+			delete(exchange.Bindings, routingKey)
+		}
+	case FANOUT:
+		if _, ok := exchange.Queues[queueName]; !ok {
+			return notFound
+		}
+		delete(exchange.Queues, queueName)
+	case TOPIC:
+		if !exchange.Topics.Unbind(routingKey, queueName) {
+			return notFound
+		}
+	case HEADERS:
+		index := -1
+		for i, binding := range exchange.HeaderBindings {
+			if binding.Queue == queue && headersArgsEqual(binding.Arguments, args) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return notFound
 		}
+		exchange.HeaderBindings = append(exchange.HeaderBindings[:index], exchange.HeaderBindings[index+1:]...)
 	}
-	return "", false
+	b.forgetBinding(exchange, queue, routingKey, args)
+	b.autoDeleteExchange(exchange)
+	return nil
 }
@@ -0,0 +1,131 @@
+package vhost
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/andrelcunha/ottermq/pkg/connection/constants"
+)
+
+// testConn is a connection that only has a remote address, which tells the
+// sessions apart
+type testConn struct {
+	net.Conn
+	port int
+}
+
+func (c testConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: c.port}
+}
+
+// TestDeleteQueueAutoDeletesItsExchangesOnly deletes a queue bound to an
+// auto-delete exchange: that exchange goes away, an unrelated one stays
+func TestDeleteQueueAutoDeletesItsExchangesOnly(t *testing.T) {
+	vh := NewVhost("/")
+	for _, name := range []string{"bound", "unrelated"} {
+		if err := vh.DeclareExchange(name, TOPIC, false, ExchangeOptions{AutoDelete: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := vh.DeclareQueue(nil, "q", false, QueueOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vh.BindQueue("", "bound", "q", "a.#", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vh.DeleteQueue(nil, "q", false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := vh.DeclareExchange("unrelated", TOPIC, true, ExchangeOptions{}); err != nil {
+		t.Errorf("unrelated exchange deleted with the queue: %v", err)
+	}
+	if err := vh.DeclareExchange("bound", TOPIC, true, ExchangeOptions{}); err == nil {
+		t.Error("auto-delete exchange kept after losing its last binding")
+	}
+}
+
+func TestDeclareQueuePassive(t *testing.T) {
+	vh := NewVhost("/")
+	if _, err := vh.DeclareQueue(nil, "missing", true, QueueOptions{}); replyCode(err) != constants.NOT_FOUND {
+		t.Fatalf("passive declare of a missing queue: %v", err)
+	}
+	if _, ok := vh.Queues["missing"]; ok {
+		t.Fatal("passive declare created the queue")
+	}
+	newTestQueue(t, vh, "q")
+	// a passive declare does not compare the options
+	if _, err := vh.DeclareQueue(nil, "q", true, QueueOptions{Durable: true}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeclareQueueInequivalent(t *testing.T) {
+	vh := NewVhost("/")
+	opts := QueueOptions{AutoDelete: true, Arguments: map[string]interface{}{"x-max-length": int32(10)}}
+	if _, err := vh.DeclareQueue(nil, "q", false, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vh.DeclareQueue(nil, "q", false, opts); err != nil {
+		t.Fatalf("redeclaring with the same options: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts QueueOptions
+	}{
+		{"durable", QueueOptions{Durable: true, AutoDelete: true, Arguments: opts.Arguments}},
+		{"auto_delete", QueueOptions{Arguments: opts.Arguments}},
+		{"other argument value", QueueOptions{AutoDelete: true, Arguments: map[string]interface{}{"x-max-length": int32(20)}}},
+		{"missing argument", QueueOptions{AutoDelete: true}},
+	}
+	for _, tt := range tests {
+		if _, err := vh.DeclareQueue(nil, "q", false, tt.opts); replyCode(err) != constants.PRECONDITION_FAILED {
+			t.Errorf("%s: redeclare returned %v, want PRECONDITION_FAILED", tt.name, err)
+		}
+	}
+}
+
+func TestDeclareExclusiveQueue(t *testing.T) {
+	vh := NewVhost("/")
+	owner, other := testConn{port: 1}, testConn{port: 2}
+	if _, err := vh.DeclareQueue(owner, "q", false, QueueOptions{Exclusive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vh.DeclareQueue(owner, "q", true, QueueOptions{}); err != nil {
+		t.Fatalf("passive declare by the owner: %v", err)
+	}
+	for _, passive := range []bool{false, true} {
+		if _, err := vh.DeclareQueue(other, "q", passive, QueueOptions{Exclusive: true}); replyCode(err) != constants.RESOURCE_LOCKED {
+			t.Errorf("declare (passive %v) by another connection: %v, want RESOURCE_LOCKED", passive, err)
+		}
+	}
+}
+
+func TestDeclareServerNamedQueue(t *testing.T) {
+	vh := NewVhost("/")
+	conn := testConn{port: 1}
+	first, err := vh.DeclareQueue(conn, "", false, QueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := vh.DeclareQueue(conn, "", false, QueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, queue := range []*Queue{first, second} {
+		if !strings.HasPrefix(queue.Name, "amq.gen-") {
+			t.Errorf("server-named queue %s without the amq.gen- prefix", queue.Name)
+		}
+		if queue.owner != SessionID(conn) {
+			t.Errorf("server-named queue %s not owned by the declaring connection", queue.Name)
+		}
+	}
+	if first.Name == second.Name {
+		t.Fatalf("two server-named queues named %s", first.Name)
+	}
+	// a passive declare with no name does not generate one
+	if _, err := vh.DeclareQueue(conn, "", true, QueueOptions{}); replyCode(err) != constants.NOT_FOUND {
+		t.Fatalf("passive declare with no name: %v", err)
+	}
+}
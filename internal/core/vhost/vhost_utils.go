@@ -3,46 +3,111 @@ package vhost
 import (
 	"log"
 	"net"
-
-	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"strings"
 )
 
 func (vh *VHost) CleanupConnection(conn net.Conn) {
 	log.Println("Cleaning vhost connection")
+	sessionID := SessionID(conn)
+	vh.handleConsumerDisconnection(sessionID)
+
 	vh.mu.Lock()
+	// exclusive and server-named queues do not outlive their connection
+	for _, queue := range vh.Queues {
+		if queue.owner == sessionID {
+			vh.removeQueue(queue)
+		}
+	}
+	states := make([]*ChannelDeliveryState, 0)
+	for key, state := range vh.ChannelDeliveries {
+		if strings.HasPrefix(key, sessionID+"/") {
+			states = append(states, state)
+			delete(vh.ChannelDeliveries, key)
+		}
+	}
+	vh.mu.Unlock()
+	vh.writeTopology()
 
+	for _, state := range states {
+		vh.requeueUnacked(state.drainUnacked())
+	}
+}
+
+// CleanupChannel cancels the consumers of a closed channel and requeues the
+// messages it left unacked
+func (vh *VHost) CleanupChannel(conn net.Conn, channel uint16) {
+	sessionID := SessionID(conn)
+	vh.mu.Lock()
+	for key := range vh.ConsumerSessions[sessionID] {
+		if consumer, ok := vh.Consumers[key]; ok && consumer.Channel == channel {
+			vh.removeConsumer(key)
+		}
+	}
+	key := channelKey(sessionID, channel)
+	state, ok := vh.ChannelDeliveries[key]
+	delete(vh.ChannelDeliveries, key)
 	vh.mu.Unlock()
-	consumerID, ok := vh.getSessionID(conn)
+	vh.writeTopology()
+
 	if ok {
-		vh.handleConsumerDisconnection(consumerID)
+		vh.requeueUnacked(state.drainUnacked())
 	}
 }
 
+// Close stops the vhost before it is deleted: the expiry timers of its
+// queues are stopped and its pending topology writes are done, so none runs
+// after the vhost is removed from the database.
+func (vh *VHost) Close() {
+	vh.mu.Lock()
+	for name, queue := range vh.Queues {
+		queue.mu.Lock()
+		if queue.expiryTimer != nil {
+			queue.expiryTimer.Stop()
+			queue.expiryTimer = nil
+		}
+		queue.mu.Unlock()
+		// a timer already fired finds its queue deleted
+		delete(vh.Queues, name)
+	}
+	vh.topology.mu.Lock()
+	vh.topology.closed = true
+	vh.topology.mu.Unlock()
+	vh.mu.Unlock()
+	vh.writeTopology()
+}
+
 func (b *VHost) handleConsumerDisconnection(sessionID string) {
+	defer b.writeTopology()
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	consumerID, ok := b.ConsumerSessions[sessionID]
+	consumerKeys, ok := b.ConsumerSessions[sessionID]
 	if !ok {
 		log.Printf("Session %s not found\n", sessionID)
 		return
 	}
 
-	consumer, ok := b.Consumers[consumerID]
-	if !ok {
-		log.Printf("Consumer %s not found\n", consumerID)
-		return
+	for key := range consumerKeys {
+		b.removeConsumer(key)
 	}
+	delete(b.ConsumerSessions, sessionID)
+}
 
-	// requeue unacknowledged messages
-	for msgID := range b.ConsumerUnackMsgs[consumerID] {
-		if queue, ok := b.Queues[consumer.Queue]; ok {
-			// queue.messages <- Message{ID: msgID}
-			queue.ReQueue(amqp.Message{ID: msgID})
-		}
+// HasUser tells whether the user has access to the vhost: either as one
+// of its users or through a permission entry
+func (vh *VHost) HasUser(username string) bool {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	if _, ok := vh.Users[username]; ok {
+		return true
 	}
+	_, ok := vh.Permissions[username]
+	return ok
+}
 
-	delete(b.ConsumerUnackMsgs, consumerID)
-	delete(b.ConsumerSessions, sessionID)
-	delete(b.Consumers, consumerID)
+// Counts returns the number of exchanges and queues of the vhost
+func (vh *VHost) Counts() (int, int) {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+	return len(vh.Exchanges), len(vh.Queues)
 }
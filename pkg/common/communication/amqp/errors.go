@@ -0,0 +1,25 @@
+package amqp
+
+import (
+	"fmt"
+
+	"github.com/andrelcunha/ottermq/pkg/connection/constants"
+)
+
+// Error is an AMQP exception. It carries the reply code and text sent back
+// to the peer on channel.close or connection.close.
+type Error struct {
+	ReplyCode constants.ReplyCode
+	ReplyText string
+}
+
+func NewError(code constants.ReplyCode, format string, args ...interface{}) *Error {
+	return &Error{
+		ReplyCode: code,
+		ReplyText: fmt.Sprintf("%s - %s", code, fmt.Sprintf(format, args...)),
+	}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Exception (%d) Reason: %q", e.ReplyCode, e.ReplyText)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"time"
 
 	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp/message"
 	"github.com/andrelcunha/ottermq/pkg/connection/constants"
@@ -19,6 +20,16 @@ type ChannelState struct {
 	HeaderFrame *HeaderFrame
 	Body        []byte
 	BodySize    uint64
+
+	// publisher confirms: once confirm.select is received, every publish on
+	// the channel gets the next sequence number
+	ConfirmMode bool
+	PublishSeq  uint64
+
+	// transactions: publishes and acks are held back until tx.commit
+	TxMode      bool
+	TxPublishes []Message
+	TxAcks      []*RequestMethodMessage
 }
 
 type HeaderFrame struct {
@@ -55,6 +66,13 @@ type Message struct {
 	Properties message.BasicProperties `json:"properties"`
 	Exchange   string                  `json:"exchange"`
 	RoutingKey string                  `json:"routing_key"`
+	// Redelivered is set when the message goes back to its queue unacked
+	Redelivered bool `json:"redelivered"`
+	// EnqueuedAt is when the message was routed to its queues, where its TTL
+	// counts from; zero for when it is pushed
+	EnqueuedAt time.Time `json:"-"`
+	// ExpiresAt is when the message expires in its queue, zero for never
+	ExpiresAt time.Time `json:"-"`
 }
 
 type ContentList struct {
@@ -95,18 +113,28 @@ func (msg ResponseContent) FormatHeaderFrame() []byte {
 	return frame
 }
 
-func (msg ResponseContent) FormatBodyFrame() []byte {
-	frameType := uint8(constants.TYPE_BODY)
-	var payloadBuf bytes.Buffer
-	channel := msg.Channel
-	content := msg.Message.Body
-	payloadBuf.Write(content)
-
-	payloadSize := uint32(payloadBuf.Len())
-	headerBuf := FormatHeader(frameType, channel, payloadSize)
-	frame := append(headerBuf, payloadBuf.Bytes()...)
-	frame = append(frame, FRAME_END)
-	return frame
+// FormatBodyFrames splits the body into content body frames no larger than
+// frameMax, header and frame-end included. A frameMax of 0 puts the whole
+// body in one frame; an empty body has no frame.
+func (msg ResponseContent) FormatBodyFrames(frameMax uint32) [][]byte {
+	body := msg.Message.Body
+	if len(body) == 0 {
+		return nil
+	}
+	chunkSize := len(body)
+	if frameMax > 8 && int64(frameMax)-8 < int64(chunkSize) {
+		chunkSize = int(frameMax - 8)
+	}
+	frames := make([][]byte, 0, (len(body)+chunkSize-1)/chunkSize)
+	for len(body) > 0 {
+		n := min(chunkSize, len(body))
+		frame := make([]byte, 0, 8+n)
+		frame = append(frame, FormatHeader(uint8(constants.TYPE_BODY), msg.Channel, uint32(n))...)
+		frame = append(frame, body[:n]...)
+		frames = append(frames, append(frame, FRAME_END))
+		body = body[n:]
+	}
+	return frames
 }
 
 func (msg ResponseMethodMessage) FormatMethodFrame() []byte {
@@ -200,3 +228,30 @@ func EncodeGetOkToContentList(msg *message.BasicGetOk) *ContentList {
 	contentList := &ContentList{KeyValuePairs: KeyValuePairs}
 	return contentList
 }
+
+func EncodeDeliverToContentList(msg *message.BasicDeliver) *ContentList {
+	KeyValuePairs := []KeyValue{
+		{ // consumer_tag
+			Key:   STRING_SHORT,
+			Value: msg.ConsumerTag,
+		},
+		{ // delivery_tag
+			Key:   INT_LONG_LONG,
+			Value: msg.DeliveryTag,
+		},
+		{ // redelivered
+			Key:   BIT,
+			Value: msg.Redelivered,
+		},
+		{ // exchange
+			Key:   STRING_SHORT,
+			Value: msg.Exchange,
+		},
+		{ // routing_key
+			Key:   STRING_SHORT,
+			Value: msg.RoutingKey,
+		},
+	}
+	contentList := &ContentList{KeyValuePairs: KeyValuePairs}
+	return contentList
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"time"
+
+	"github.com/andrelcunha/ottermq/pkg/connection/utils"
 )
 
 type BasicPublishMessage struct {
@@ -27,6 +29,51 @@ type BasicGetOk struct {
 	MessageCount uint32
 }
 
+type BasicQosMessage struct {
+	PrefetchSize  uint32
+	PrefetchCount uint16
+	Global        bool
+}
+
+type BasicConsumeMessage struct {
+	Queue       string
+	ConsumerTag string
+	NoLocal     bool
+	NoAck       bool
+	Exclusive   bool
+	NoWait      bool
+	Arguments   map[string]interface{}
+}
+
+type BasicCancelMessage struct {
+	ConsumerTag string
+	NoWait      bool
+}
+
+type BasicDeliver struct {
+	ConsumerTag string
+	DeliveryTag uint64
+	Redelivered bool
+	Exchange    string
+	RoutingKey  string
+}
+
+type BasicAckMessage struct {
+	DeliveryTag uint64
+	Multiple    bool
+}
+
+type BasicRejectMessage struct {
+	DeliveryTag uint64
+	Requeue     bool
+}
+
+type BasicNackMessage struct {
+	DeliveryTag uint64
+	Multiple    bool
+	Requeue     bool
+}
+
 type BasicProperties struct {
 	ContentType     string                 // shortstr
 	ContentEncoding string                 // shortstr
@@ -62,11 +109,8 @@ func (props *BasicProperties) EncodeBasicProperties() ([]byte, uint16, error) {
 	}
 	if props.Headers != nil {
 		flags |= (1 << 13)
-		encodedTable, err := encodeTable(props.Headers)
-		if err != nil {
-			return nil, 0, err
-		}
-		if _, err := buf.Write(encodedTable); err != nil {
+		encodedTable := utils.EncodeTable(props.Headers)
+		if err := encodeLongStr(&buf, string(encodedTable)); err != nil {
 			return nil, 0, err
 		}
 	}
@@ -149,51 +193,6 @@ func encodeShortStr(buf *bytes.Buffer, value string) error {
 	return nil
 }
 
-func encodeTable(table map[string]interface{}) ([]byte, error) {
-	var buf bytes.Buffer
-	for key, value := range table { // Field name
-		if err := encodeShortStr(&buf, key); err != nil {
-			return nil, err
-		}
-
-		// Field value type and value
-		switch v := value.(type) {
-		case string:
-			buf.WriteByte('S') // Field value type 'S' (string)
-			if err := encodeLongStr(&buf, v); err != nil {
-				return nil, err
-			}
-
-		case int:
-			buf.WriteByte('I') // Field value type 'I' (int)
-			if err := binary.Write(&buf, binary.BigEndian, int32(v)); err != nil {
-				return nil, err
-			}
-
-		case map[string]interface{}: // Recursively encode the nested map
-			buf.WriteByte('F') // Field value type 'F' (field table)
-			encodedTable, err := encodeTable(v)
-			if err != nil {
-				return nil, err
-			}
-			if err := encodeLongStr(&buf, string(encodedTable)); err != nil {
-				return nil, err
-			}
-
-		case bool:
-			buf.WriteByte('t')
-			if v {
-				buf.WriteByte(1)
-			} else {
-				buf.WriteByte(0)
-			}
-		default:
-			buf.WriteByte('U')
-		}
-	}
-	return buf.Bytes(), nil
-}
-
 func encodeOctet(buf *bytes.Buffer, value uint8) error {
 	return buf.WriteByte(value)
 }
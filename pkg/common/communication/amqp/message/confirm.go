@@ -0,0 +1,5 @@
+package message
+
+type ConfirmSelectMessage struct {
+	NoWait bool
+}
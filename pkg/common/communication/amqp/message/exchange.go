@@ -3,6 +3,7 @@ package message
 type ExchangeDeclareMessage struct {
 	ExchangeName string
 	ExchangeType string
+	Passive      bool
 	Durable      bool
 	AutoDelete   bool
 	Internal     bool
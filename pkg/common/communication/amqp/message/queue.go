@@ -1,17 +1,31 @@
 package message
 
 type QueueDeclareMessage struct {
+	QueueName  string
+	Passive    bool
+	Durable    bool
+	Exclusive  bool
+	AutoDelete bool
+	NoWait     bool
+	Arguments  map[string]interface{}
+}
+
+type QueueDeleteMessage struct {
 	QueueName string
-	Durable   bool
 	IfUnused  bool
-	Exclusive bool
+	IfEmpty   bool
 	NoWait    bool
-	Arguments map[string]interface{}
 }
 
-type QueueDeleteMessage struct {
+type QueueUnbindMessage struct {
+	Queue      string
+	Exchange   string
+	RoutingKey string
+	Arguments  map[string]interface{}
+}
+
+type QueuePurgeMessage struct {
 	QueueName string
-	IfUnused  bool
 	NoWait    bool
 }
 
@@ -0,0 +1,70 @@
+package common
+
+// Definitions is the broker configuration exported and imported as a single
+// JSON document: vhosts, users, permissions and the durable topology.
+type Definitions struct {
+	VHosts           []VHostDefinition           `json:"vhosts"`
+	Users            []UserDefinition            `json:"users"`
+	Permissions      []PermissionDefinition      `json:"permissions"`
+	TopicPermissions []TopicPermissionDefinition `json:"topic_permissions"`
+	Exchanges        []ExchangeDefinition        `json:"exchanges"`
+	Queues           []QueueDefinition           `json:"queues"`
+	Bindings         []BindingDefinition         `json:"bindings"`
+	// the broker has no policies yet; the list is kept so documents from
+	// other brokers can be imported
+	Policies []interface{} `json:"policies"`
+}
+
+type VHostDefinition struct {
+	Name string `json:"name"`
+}
+
+type UserDefinition struct {
+	Name         string `json:"name"`
+	PasswordHash string `json:"password_hash"`
+	Role         string `json:"role"`
+}
+
+type PermissionDefinition struct {
+	User      string `json:"user"`
+	VHost     string `json:"vhost"`
+	Configure string `json:"configure"`
+	Write     string `json:"write"`
+	Read      string `json:"read"`
+}
+
+type TopicPermissionDefinition struct {
+	User     string `json:"user"`
+	VHost    string `json:"vhost"`
+	Exchange string `json:"exchange"`
+	Write    string `json:"write"`
+	Read     string `json:"read"`
+}
+
+type ExchangeDefinition struct {
+	Name       string                 `json:"name"`
+	VHost      string                 `json:"vhost"`
+	Type       string                 `json:"type"`
+	Durable    bool                   `json:"durable"`
+	AutoDelete bool                   `json:"auto_delete"`
+	Internal   bool                   `json:"internal"`
+	Arguments  map[string]interface{} `json:"arguments"`
+}
+
+type QueueDefinition struct {
+	Name       string                 `json:"name"`
+	VHost      string                 `json:"vhost"`
+	Durable    bool                   `json:"durable"`
+	AutoDelete bool                   `json:"auto_delete"`
+	Arguments  map[string]interface{} `json:"arguments"`
+}
+
+// BindingDefinition binds the destination queue to the source exchange
+type BindingDefinition struct {
+	Source          string                 `json:"source"`
+	VHost           string                 `json:"vhost"`
+	Destination     string                 `json:"destination"`
+	DestinationType string                 `json:"destination_type"`
+	RoutingKey      string                 `json:"routing_key"`
+	Arguments       map[string]interface{} `json:"arguments"`
+}
@@ -17,6 +17,14 @@ type ConnectionInfoDTO struct {
 	Done chan struct{} `json:"-"`
 }
 
+type VHostDTO struct {
+	Name        string `json:"name"`
+	Id          string `json:"id"`
+	Exchanges   int    `json:"exchanges"`
+	Queues      int    `json:"queues"`
+	Connections int    `json:"connections"`
+}
+
 type ExchangeDTO struct {
 	VHostName string `json:"vhost"`
 	VHostId   string `json:"vhost_id"`
@@ -29,4 +37,18 @@ type QueueDTO struct {
 	VHostId   string `json:"vhost_id"`
 	Name      string `json:"name"`
 	Messages  int    `json:"messages"`
+	// ready messages of each priority, for queues declared with x-max-priority
+	MessagesByPriority map[int]int `json:"messages_by_priority,omitempty"`
+}
+
+type ConsumerDTO struct {
+	VHostName     string `json:"vhost"`
+	VHostId       string `json:"vhost_id"`
+	ConsumerTag   string `json:"consumer_tag"`
+	Queue         string `json:"queue"`
+	Connection    string `json:"connection"`
+	Channel       uint16 `json:"channel"`
+	AckRequired   bool   `json:"ack_required"`
+	Exclusive     bool   `json:"exclusive"`
+	PrefetchCount uint16 `json:"prefetch_count"`
 }
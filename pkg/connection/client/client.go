@@ -45,7 +45,7 @@ func NewClient(config *shared.ClientConfig) *Client {
 }
 
 func (c *Client) Dial(host, port string) error {
-	addr := fmt.Sprintf("%s:%s", host, port)
+	addr := net.JoinHostPort(host, port)
 	conn, err := net.Dial("tcp", addr)
 	if err != nil {
 		return fmt.Errorf("ERROR: %s\n", err.Error())
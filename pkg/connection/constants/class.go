@@ -9,5 +9,6 @@ const (
 	EXCHANGE   TypeClass = 40
 	QUEUE      TypeClass = 50
 	BASIC      TypeClass = 60
+	CONFIRM    TypeClass = 85
 	TX         TypeClass = 90
 )
@@ -0,0 +1,8 @@
+package confirm
+
+type ConfirmMethod int
+
+const (
+	SELECT    ConfirmMethod = 10
+	SELECT_OK ConfirmMethod = 11
+)
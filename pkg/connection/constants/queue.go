@@ -7,6 +7,8 @@ const (
 	QUEUE_DECLARE_OK QueueMethod = 11
 	QUEUE_BIND       QueueMethod = 20
 	QUEUE_BIND_OK    QueueMethod = 21
+	QUEUE_PURGE      QueueMethod = 30
+	QUEUE_PURGE_OK   QueueMethod = 31
 	QUEUE_UNBIND     QueueMethod = 50
 	QUEUE_UNBIND_OK  QueueMethod = 51
 	QUEUE_DELETE     QueueMethod = 40
@@ -0,0 +1,67 @@
+package constants
+
+type ReplyCode uint16
+
+// Reply codes as defined by the AMQP 0-9-1 specification
+const (
+	REPLY_SUCCESS       ReplyCode = 200
+	CONTENT_TOO_LARGE   ReplyCode = 311
+	NO_ROUTE            ReplyCode = 312
+	NO_CONSUMERS        ReplyCode = 313
+	CONNECTION_FORCED   ReplyCode = 320
+	INVALID_PATH        ReplyCode = 402
+	ACCESS_REFUSED      ReplyCode = 403
+	NOT_FOUND           ReplyCode = 404
+	RESOURCE_LOCKED     ReplyCode = 405
+	PRECONDITION_FAILED ReplyCode = 406
+	FRAME_ERROR         ReplyCode = 501
+	SYNTAX_ERROR        ReplyCode = 502
+	COMMAND_INVALID     ReplyCode = 503
+	CHANNEL_ERROR       ReplyCode = 504
+	UNEXPECTED_FRAME    ReplyCode = 505
+	RESOURCE_ERROR      ReplyCode = 506
+	NOT_ALLOWED         ReplyCode = 530
+	NOT_IMPLEMENTED     ReplyCode = 540
+	INTERNAL_ERROR      ReplyCode = 541
+)
+
+var replyCodeNames = map[ReplyCode]string{
+	REPLY_SUCCESS:       "REPLY_SUCCESS",
+	CONTENT_TOO_LARGE:   "CONTENT_TOO_LARGE",
+	NO_ROUTE:            "NO_ROUTE",
+	NO_CONSUMERS:        "NO_CONSUMERS",
+	CONNECTION_FORCED:   "CONNECTION_FORCED",
+	INVALID_PATH:        "INVALID_PATH",
+	ACCESS_REFUSED:      "ACCESS_REFUSED",
+	NOT_FOUND:           "NOT_FOUND",
+	RESOURCE_LOCKED:     "RESOURCE_LOCKED",
+	PRECONDITION_FAILED: "PRECONDITION_FAILED",
+	FRAME_ERROR:         "FRAME_ERROR",
+	SYNTAX_ERROR:        "SYNTAX_ERROR",
+	COMMAND_INVALID:     "COMMAND_INVALID",
+	CHANNEL_ERROR:       "CHANNEL_ERROR",
+	UNEXPECTED_FRAME:    "UNEXPECTED_FRAME",
+	RESOURCE_ERROR:      "RESOURCE_ERROR",
+	NOT_ALLOWED:         "NOT_ALLOWED",
+	NOT_IMPLEMENTED:     "NOT_IMPLEMENTED",
+	INTERNAL_ERROR:      "INTERNAL_ERROR",
+}
+
+func (code ReplyCode) String() string {
+	if name, ok := replyCodeNames[code]; ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+// IsHardError reports whether the reply code is a connection exception.
+// Any other code is a soft error and only closes the channel.
+func (code ReplyCode) IsHardError() bool {
+	switch code {
+	case CONNECTION_FORCED, INVALID_PATH, FRAME_ERROR, SYNTAX_ERROR,
+		COMMAND_INVALID, CHANNEL_ERROR, UNEXPECTED_FRAME, RESOURCE_ERROR,
+		NOT_ALLOWED, NOT_IMPLEMENTED, INTERNAL_ERROR:
+		return true
+	}
+	return false
+}
@@ -20,7 +20,10 @@ import (
 // Client responds with connection.tune-ok
 // Client sends connection.open
 // Server responds with connection.open-ok
-func ServerHandshake(configurations *map[string]interface{}, conn net.Conn) error {
+//
+// checkVHost tells whether the user may open the requested vhost; when it
+// fails, the connection is closed with its error instead of connection.open-ok.
+func ServerHandshake(configurations *map[string]interface{}, conn net.Conn, checkVHost func(username, vhost string) error) error {
 	// read the protocol header from the client
 	clientHeader, err := shared.ReadProtocolHeader(conn)
 	if err != nil {
@@ -80,6 +83,9 @@ func ServerHandshake(configurations *map[string]interface{}, conn net.Conn) erro
 		FrameMax:   131072,
 		Heartbeat:  10,
 	}
+	if frameMax, ok := (*configurations)["frameMax"].(uint32); ok && frameMax > 0 {
+		tune.FrameMax = frameMax
+	}
 	// create tune frame
 	tuneFrame := shared.CreateConnectionTuneFrame(tune)
 	if err := shared.SendFrame(conn, tuneFrame); err != nil {
@@ -111,6 +117,15 @@ func ServerHandshake(configurations *map[string]interface{}, conn net.Conn) erro
 	}
 	fmt.Printf("Received connection.tune-ok: %+v\n", tuneOkFrame)
 	// TODO: save tuneOK data
+	// the client may lower frame_max, 0 leaves it to the server
+	frameMax := tune.FrameMax
+	if tuneOkFrame.FrameMax > 0 && tuneOkFrame.FrameMax < frameMax {
+		frameMax = tuneOkFrame.FrameMax
+	}
+	(*configurations)["frameMax"] = frameMax
+	if frameConn, ok := conn.(*shared.FrameConn); ok {
+		frameConn.SetFrameMax(frameMax)
+	}
 
 	// read connection.open frame
 	frame, err = shared.ReadFrame(conn)
@@ -135,8 +150,16 @@ func ServerHandshake(configurations *map[string]interface{}, conn net.Conn) erro
 	}
 
 	openFrame, ok := state.MethodFrame.Content.(*shared.ConnectionOpenFrame)
-	(*configurations)["vhost"] = openFrame.VirtualHost
+	if !ok {
+		return fmt.Errorf("Type assertion ConnectionOpenFrame failed")
+	}
 	fmt.Printf("Received connection.open: %+v\n", openFrame)
+	username := (*configurations)["username"].(string)
+	if err := checkVHost(username, openFrame.VirtualHost); err != nil {
+		sendOpenRefused(conn, err)
+		return err
+	}
+	(*configurations)["vhost"] = openFrame.VirtualHost
 
 	//send connection.open-ok frame
 	openOkFrame := shared.CreateConnectionOpenOkFrame()
@@ -147,6 +170,43 @@ func ServerHandshake(configurations *map[string]interface{}, conn net.Conn) erro
 	return nil
 }
 
+// sendOpenRefused replies connection.open with a connection.close carrying
+// the error
+func sendOpenRefused(conn net.Conn, err error) {
+	amqpErr, ok := err.(*amqp.Error)
+	if !ok {
+		amqpErr = amqp.NewError(constants.NOT_ALLOWED, "%v", err)
+	}
+	frame := amqp.ResponseMethodMessage{
+		Channel:  0,
+		ClassID:  uint16(constants.CONNECTION),
+		MethodID: uint16(constants.CONNECTION_CLOSE),
+		Content: amqp.ContentList{
+			KeyValuePairs: []amqp.KeyValue{
+				{ // reply-code
+					Key:   amqp.INT_SHORT,
+					Value: uint16(amqpErr.ReplyCode),
+				},
+				{ // reply-text
+					Key:   amqp.STRING_SHORT,
+					Value: amqpErr.ReplyText,
+				},
+				{ // class-id
+					Key:   amqp.INT_SHORT,
+					Value: uint16(constants.CONNECTION),
+				},
+				{ // method-id
+					Key:   amqp.INT_SHORT,
+					Value: uint16(constants.CONNECTION_OPEN),
+				},
+			},
+		},
+	}.FormatMethodFrame()
+	if err := shared.SendFrame(conn, frame); err != nil {
+		log.Printf("Failed to send connection.close: %v", err)
+	}
+}
+
 func processStartOkContent(configurations *map[string]interface{}, startOkFrame *shared.ConnectionStartOkFrame) error {
 	mechanism := startOkFrame.Mechanism
 	if mechanism != "PLAIN" {
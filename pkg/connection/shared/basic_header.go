@@ -58,6 +58,12 @@ func parseBasicHeader(headerPayload []byte) (*amqp.HeaderFrame, error) {
 
 }
 
+// DecodeBasicProperties decodes a property list encoded with the given
+// property flags
+func DecodeBasicProperties(flags uint16, payload []byte) (*message.BasicProperties, error) {
+	return createContentPropertiesTable(decodeBasicHeaderFlags(flags), bytes.NewReader(payload))
+}
+
 func decodeBasicHeaderFlags(short uint16) []string {
 	flagNames := []string{
 		"contentType",
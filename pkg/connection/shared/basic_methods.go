@@ -12,13 +12,29 @@ import (
 
 func parseBasicMethod(methodID uint16, payload []byte) (interface{}, error) {
 	switch methodID {
-	// case uint16(constants.BASIC_ACK):
-	// 	fmt.Printf("[DEBUG] Received BASIC_ACK frame \n")
-	// 	return parseBasicAckFrame(payload)
+	case uint16(constants.BASIC_ACK):
+		fmt.Printf("[DEBUG] Received BASIC_ACK frame \n")
+		return parseBasicAckFrame(payload)
 
-	// case uint16(constants.BASIC_REJECT):
-	// 	fmt.Printf("[DEBUG] Received BASIC_REJECT frame \n")
-	// 	return parseBasicRejectFrame(payload)
+	case uint16(constants.BASIC_REJECT):
+		fmt.Printf("[DEBUG] Received BASIC_REJECT frame \n")
+		return parseBasicRejectFrame(payload)
+
+	case uint16(constants.BASIC_NACK):
+		fmt.Printf("[DEBUG] Received BASIC_NACK frame \n")
+		return parseBasicNackFrame(payload)
+
+	case uint16(constants.BASIC_QOS):
+		fmt.Printf("[DEBUG] Received BASIC_QOS frame \n")
+		return parseBasicQosFrame(payload)
+
+	case uint16(constants.BASIC_CONSUME):
+		fmt.Printf("[DEBUG] Received BASIC_CONSUME frame \n")
+		return parseBasicConsumeFrame(payload)
+
+	case uint16(constants.BASIC_CANCEL):
+		fmt.Printf("[DEBUG] Received BASIC_CANCEL frame \n")
+		return parseBasicCancelFrame(payload)
 
 	case uint16(constants.BASIC_PUBLISH):
 		fmt.Printf("[DEBUG] Received BASIC_PUBLISH frame \n")
@@ -75,14 +91,14 @@ func DecodeBasicGetFlags(octet byte) map[string]bool {
 	flagNames := []string{"noAck", "flag2", "flag3", "flag4", "flag5", "flag6", "flag7", "flag8"}
 
 	for i := 0; i < 8; i++ {
-		flags[flagNames[i]] = (octet & (1 << uint(7-i))) != 0
+		flags[flagNames[i]] = (octet & (1 << uint(i))) != 0
 	}
 
 	return flags
 }
 
 func parseBasicPublishFrame(payload []byte) (*amqp.RequestMethodMessage, error) {
-	if len(payload) < 10 {
+	if len(payload) < 5 {
 		return nil, fmt.Errorf("payload too short")
 	}
 
@@ -132,3 +148,212 @@ func DecodeBasicPublishFlags(octet byte) map[string]bool {
 
 	return flags
 }
+
+// Fields:
+// 0: prefetch size - (long)
+// 1: prefetch count - (short)
+// 2: global - (bit)
+func parseBasicQosFrame(payload []byte) (*amqp.RequestMethodMessage, error) {
+	if len(payload) < 7 {
+		return nil, fmt.Errorf("payload too short")
+	}
+
+	buf := bytes.NewReader(payload)
+	prefetchSize, err := DecodeLongInt(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode prefetch size: %v", err)
+	}
+	prefetchCount, err := DecodeShortInt(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode prefetch count: %v", err)
+	}
+	octet, err := buf.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read octet: %v", err)
+	}
+	msg := &message.BasicQosMessage{
+		PrefetchSize:  prefetchSize,
+		PrefetchCount: prefetchCount,
+		Global:        octet&1 != 0,
+	}
+	return &amqp.RequestMethodMessage{
+		Content: msg,
+	}, nil
+}
+
+// Fields:
+// 0-1: reserved short int
+// 2: queue name - (shortstr)
+// 3: consumer tag - (shortstr)
+// 4: no-local, no-ack, exclusive, no-wait - (bits)
+// 5: arguments - (table)
+func parseBasicConsumeFrame(payload []byte) (*amqp.RequestMethodMessage, error) {
+	if len(payload) < 5 {
+		return nil, fmt.Errorf("payload too short")
+	}
+
+	buf := bytes.NewReader(payload)
+	reserved1, err := DecodeShortInt(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode reserved1: %v", err)
+	}
+	if reserved1 != 0 {
+		return nil, fmt.Errorf("reserved1 must be 0")
+	}
+	queue, err := DecodeShortStr(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode queue: %v", err)
+	}
+	consumerTag, err := DecodeShortStr(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode consumer tag: %v", err)
+	}
+	octet, err := buf.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read octet: %v", err)
+	}
+	flags := DecodeBasicConsumeFlags(octet)
+
+	var arguments map[string]interface{}
+	if buf.Len() >= 4 {
+		argumentsStr, err := DecodeLongStr(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode arguments: %v", err)
+		}
+		arguments, err = DecodeTable([]byte(argumentsStr))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read arguments: %v", err)
+		}
+	}
+	msg := &message.BasicConsumeMessage{
+		Queue:       queue,
+		ConsumerTag: consumerTag,
+		NoLocal:     flags["noLocal"],
+		NoAck:       flags["noAck"],
+		Exclusive:   flags["exclusive"],
+		NoWait:      flags["noWait"],
+		Arguments:   arguments,
+	}
+	fmt.Printf("[DEBUG] BasicConsume fomated: %+v \n", msg)
+	return &amqp.RequestMethodMessage{
+		Content: msg,
+	}, nil
+}
+
+// DecodeBasicConsumeFlags decodes the consume bits, packed from the
+// least significant bit of the octet
+func DecodeBasicConsumeFlags(octet byte) map[string]bool {
+	flags := make(map[string]bool)
+	flagNames := []string{"noLocal", "noAck", "exclusive", "noWait", "flag5", "flag6", "flag7", "flag8"}
+
+	for i := 0; i < 8; i++ {
+		flags[flagNames[i]] = (octet & (1 << uint(i))) != 0
+	}
+
+	return flags
+}
+
+// Fields:
+// 0: consumer tag - (shortstr)
+// 1: no-wait - (bit)
+func parseBasicCancelFrame(payload []byte) (*amqp.RequestMethodMessage, error) {
+	if len(payload) < 2 {
+		return nil, fmt.Errorf("payload too short")
+	}
+
+	buf := bytes.NewReader(payload)
+	consumerTag, err := DecodeShortStr(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode consumer tag: %v", err)
+	}
+	octet, err := buf.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read octet: %v", err)
+	}
+	msg := &message.BasicCancelMessage{
+		ConsumerTag: consumerTag,
+		NoWait:      octet&1 != 0,
+	}
+	return &amqp.RequestMethodMessage{
+		Content: msg,
+	}, nil
+}
+
+// Fields:
+// 0: delivery tag - (longlong)
+// 1: multiple - (bit)
+func parseBasicAckFrame(payload []byte) (*amqp.RequestMethodMessage, error) {
+	if len(payload) < 9 {
+		return nil, fmt.Errorf("payload too short")
+	}
+
+	buf := bytes.NewReader(payload)
+	deliveryTag, err := DecodeLongLongInt(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode delivery tag: %v", err)
+	}
+	octet, err := buf.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read octet: %v", err)
+	}
+	msg := &message.BasicAckMessage{
+		DeliveryTag: deliveryTag,
+		Multiple:    octet&1 != 0,
+	}
+	return &amqp.RequestMethodMessage{
+		Content: msg,
+	}, nil
+}
+
+// Fields:
+// 0: delivery tag - (longlong)
+// 1: requeue - (bit)
+func parseBasicRejectFrame(payload []byte) (*amqp.RequestMethodMessage, error) {
+	if len(payload) < 9 {
+		return nil, fmt.Errorf("payload too short")
+	}
+
+	buf := bytes.NewReader(payload)
+	deliveryTag, err := DecodeLongLongInt(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode delivery tag: %v", err)
+	}
+	octet, err := buf.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read octet: %v", err)
+	}
+	msg := &message.BasicRejectMessage{
+		DeliveryTag: deliveryTag,
+		Requeue:     octet&1 != 0,
+	}
+	return &amqp.RequestMethodMessage{
+		Content: msg,
+	}, nil
+}
+
+// Fields:
+// 0: delivery tag - (longlong)
+// 1: multiple, requeue - (bits)
+func parseBasicNackFrame(payload []byte) (*amqp.RequestMethodMessage, error) {
+	if len(payload) < 9 {
+		return nil, fmt.Errorf("payload too short")
+	}
+
+	buf := bytes.NewReader(payload)
+	deliveryTag, err := DecodeLongLongInt(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode delivery tag: %v", err)
+	}
+	octet, err := buf.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read octet: %v", err)
+	}
+	msg := &message.BasicNackMessage{
+		DeliveryTag: deliveryTag,
+		Multiple:    octet&1 != 0,
+		Requeue:     octet&2 != 0,
+	}
+	return &amqp.RequestMethodMessage{
+		Content: msg,
+	}, nil
+}
@@ -0,0 +1,34 @@
+package shared
+
+import (
+	"fmt"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp/message"
+	"github.com/andrelcunha/ottermq/pkg/connection/constants/confirm"
+)
+
+func parseConfirmMethod(methodID uint16, payload []byte) (interface{}, error) {
+	switch methodID {
+	case uint16(confirm.SELECT):
+		fmt.Printf("[DEBUG] Received CONFIRM_SELECT frame \n")
+		return parseConfirmSelectFrame(payload)
+
+	default:
+		return nil, fmt.Errorf("unknown method ID: %d", methodID)
+	}
+}
+
+// Fields:
+// 0: no-wait - (bit)
+func parseConfirmSelectFrame(payload []byte) (*amqp.RequestMethodMessage, error) {
+	if len(payload) < 1 {
+		return nil, fmt.Errorf("payload too short")
+	}
+	msg := &message.ConfirmSelectMessage{
+		NoWait: payload[0]&1 != 0,
+	}
+	return &amqp.RequestMethodMessage{
+		Content: msg,
+	}, nil
+}
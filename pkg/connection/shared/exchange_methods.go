@@ -30,8 +30,8 @@ func parseExchangeMethod(methodID uint16, payload []byte) (interface{}, error) {
 // 3: type - (string)
 // 4: passive - (bit)
 // 5: durable - (bit)
-// 6: reserved 2
-// 7: reserved 3
+// 6: auto-delete - (bit)
+// 7: internal - (bit)
 // 8: no-wait - (bit)
 // 9: arguments - (table)
 func parseExchangeDeclareFrame(payload []byte) (*amqp.RequestMethodMessage, error) {
@@ -60,14 +60,14 @@ func parseExchangeDeclareFrame(payload []byte) (*amqp.RequestMethodMessage, erro
 		return nil, fmt.Errorf("failed to read octet: %v", err)
 	}
 	flags := DecodeExchangeDeclareFlags(octet)
+	passive := flags["passive"]
 	autoDelete := flags["autoDelete"]
 	durable := flags["durable"]
 	internal := flags["internal"]
 	noWait := flags["noWait"]
 
 	var arguments map[string]interface{}
-	if buf.Len() > 4 {
-
+	if buf.Len() >= 4 {
 		argumentsStr, err := DecodeLongStr(buf)
 		if err != nil {
 			return nil, fmt.Errorf("failed to decode arguments: %v", err)
@@ -80,6 +80,7 @@ func parseExchangeDeclareFrame(payload []byte) (*amqp.RequestMethodMessage, erro
 	msg := &message.ExchangeDeclareMessage{
 		ExchangeName: exchangeName,
 		ExchangeType: exchangeType,
+		Passive:      passive,
 		Durable:      durable,
 		AutoDelete:   autoDelete,
 		Internal:     internal,
@@ -99,7 +100,7 @@ func parseExchangeDeclareFrame(payload []byte) (*amqp.RequestMethodMessage, erro
 // 3: if-unused - (bit)
 // 4: no-wait - (bit)
 func parseExchangeDeleteFrame(payload []byte) (*amqp.RequestMethodMessage, error) {
-	if len(payload) < 6 {
+	if len(payload) < 4 {
 		return nil, fmt.Errorf("payload too short")
 	}
 	fmt.Printf("[DEBUG] Received EXCHANGE_DELETE frame %x \n", payload)
@@ -120,7 +121,7 @@ func parseExchangeDeleteFrame(payload []byte) (*amqp.RequestMethodMessage, error
 	if err != nil {
 		return nil, fmt.Errorf("failed to read octet: %v", err)
 	}
-	flags := DecodeExchangeDeclareFlags(octet)
+	flags := DecodeExchangeDeleteFlags(octet)
 	ifUnused := flags["ifUnused"]
 	noWait := flags["noWait"]
 
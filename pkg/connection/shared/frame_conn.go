@@ -0,0 +1,186 @@
+package shared
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// outboundQueueSize is the number of sends a connection holds before
+	// its senders block
+	outboundQueueSize = 1024
+	writeBufferSize   = 64 * 1024
+	// closeFlushTimeout bounds the time Close waits for the queued frames
+	// to be written to a peer that does not read them
+	closeFlushTimeout = 5 * time.Second
+)
+
+// FrameConn is a net.Conn whose writes go through a goroutine of its own.
+// Sends are queued in order on a bounded queue and written through a buffer
+// that is flushed whenever the queue runs empty, so the frames of concurrent
+// senders never interleave and small frames are batched into few writes.
+// Reads go through a FrameReader.
+type FrameConn struct {
+	net.Conn
+	reader   *FrameReader
+	frameMax atomic.Uint32 // largest frame exchanged with the peer
+	out      chan [][]byte
+	closing  chan struct{} // closed by Close or when a write fails
+	done     chan struct{} // closed when the writer is gone
+	once     sync.Once
+
+	mu       sync.Mutex
+	err      error // the write error that stopped the writer
+	closeErr error
+}
+
+// NewFrameConn starts the writer of the connection. Frames larger than
+// frameMax are refused until SetFrameMax sets the negotiated size.
+func NewFrameConn(conn net.Conn, frameMax uint32) *FrameConn {
+	c := &FrameConn{
+		Conn:    conn,
+		reader:  NewFrameReader(conn, frameMax),
+		out:     make(chan [][]byte, outboundQueueSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	c.frameMax.Store(frameMax)
+	go c.writeLoop()
+	return c
+}
+
+// Read reads raw bytes through the buffer of the frame reader
+func (c *FrameConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// ReadFrame returns the next frame, valid until the next call
+func (c *FrameConn) ReadFrame() ([]byte, error) {
+	return c.reader.ReadFrame()
+}
+
+// SetFrameMax sets the largest frame accepted from the peer, and sent to it
+func (c *FrameConn) SetFrameMax(frameMax uint32) {
+	c.frameMax.Store(frameMax)
+	c.reader.SetFrameMax(frameMax)
+}
+
+// FrameMax returns the largest frame exchanged with the peer
+func (c *FrameConn) FrameMax() uint32 {
+	return c.frameMax.Load()
+}
+
+// WriteFrames queues the frames to be written back to back, with no frame
+// of another sender in between. It blocks while the queue is full. The
+// frames must not be modified afterwards.
+func (c *FrameConn) WriteFrames(frames ...[]byte) error {
+	select {
+	case <-c.closing:
+		return c.closedError()
+	default:
+	}
+	select {
+	case c.out <- frames:
+		return nil
+	case <-c.closing:
+		return c.closedError()
+	}
+}
+
+// Write queues a copy of p
+func (c *FrameConn) Write(p []byte) (int, error) {
+	if err := c.WriteFrames(append([]byte(nil), p...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes the frames still queued, then closes the connection
+func (c *FrameConn) Close() error {
+	c.stop(nil)
+	select {
+	case <-c.done:
+	case <-time.After(closeFlushTimeout):
+		// unblock the writer
+		c.Conn.Close()
+		<-c.done
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closeErr
+}
+
+func (c *FrameConn) stop(err error) {
+	c.once.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		close(c.closing)
+	})
+}
+
+func (c *FrameConn) closedError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return net.ErrClosed
+}
+
+func (c *FrameConn) writeLoop() {
+	defer func() {
+		err := c.Conn.Close()
+		c.mu.Lock()
+		c.closeErr = err
+		c.mu.Unlock()
+		close(c.done)
+	}()
+	w := bufio.NewWriterSize(c.Conn, writeBufferSize)
+	write := func(frames [][]byte) bool {
+		for _, frame := range frames {
+			if _, err := w.Write(frame); err != nil {
+				c.stop(err)
+				return false
+			}
+		}
+		return true
+	}
+	for {
+		select {
+		case frames := <-c.out:
+			if !write(frames) {
+				return
+			}
+			continue
+		default:
+		}
+		// the queue is empty: send what was batched so far
+		if err := w.Flush(); err != nil {
+			c.stop(err)
+			return
+		}
+		select {
+		case frames := <-c.out:
+			if !write(frames) {
+				return
+			}
+		case <-c.closing:
+			// write what was queued before the close
+			for {
+				select {
+				case frames := <-c.out:
+					if !write(frames) {
+						return
+					}
+				default:
+					w.Flush()
+					return
+				}
+			}
+		}
+	}
+}
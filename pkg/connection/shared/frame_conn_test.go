@@ -0,0 +1,60 @@
+package shared
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// TestFrameConnKeepsSendsTogether has concurrent senders each write groups
+// of frames and checks no group was split by another sender's frames
+func TestFrameConnKeepsSendsTogether(t *testing.T) {
+	server, client := net.Pipe()
+	conn := NewFrameConn(server, 0)
+
+	const senders, groups = 8, 200
+	var wg sync.WaitGroup
+	for s := 0; s < senders; s++ {
+		wg.Add(1)
+		go func(id byte) {
+			defer wg.Done()
+			for g := 0; g < groups; g++ {
+				if err := SendFrames(conn, []byte{id, 1}, []byte{id, 2}, []byte{id, 3}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(byte(s))
+	}
+	go func() {
+		wg.Wait()
+		conn.Close()
+	}()
+
+	data, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != senders*groups*6 {
+		t.Fatalf("read %d bytes, want %d", len(data), senders*groups*6)
+	}
+	for i := 0; i < len(data); i += 6 {
+		id := data[i]
+		if !bytes.Equal(data[i:i+6], []byte{id, 1, id, 2, id, 3}) {
+			t.Fatalf("group at %d interleaved: %v", i, data[i:i+6])
+		}
+	}
+}
+
+func TestFrameConnWriteAfterClose(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := NewFrameConn(server, 0)
+	go io.Copy(io.Discard, client)
+	conn.Close()
+	if err := SendFrame(conn, []byte{1}); err == nil {
+		t.Error("SendFrame on a closed connection succeeded")
+	}
+}
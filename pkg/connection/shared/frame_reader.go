@@ -0,0 +1,98 @@
+package shared
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/connection/constants"
+)
+
+const (
+	readBufferSize = 64 * 1024
+	// frameBufferSize is the smallest frame buffer kept in the pool, the
+	// minimum frame_max a peer may negotiate
+	frameBufferSize = 4096
+)
+
+// frameBuffers holds the buffers frames are read into, shared by all
+// connections
+var frameBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, frameBufferSize)
+		return &buf
+	},
+}
+
+// FrameReader reads frames through a buffer. The frames are read into
+// pooled buffers: a frame is only valid until the next call to ReadFrame,
+// whoever keeps a part of it must copy it.
+type FrameReader struct {
+	r        *bufio.Reader
+	frameMax uint32  // largest frame accepted, header and frame-end included; 0 for no limit
+	header   [7]byte // type, channel and payload size of the frame being read
+	buf      *[]byte // buffer of the frame returned last
+}
+
+func NewFrameReader(r io.Reader, frameMax uint32) *FrameReader {
+	return &FrameReader{
+		r:        bufio.NewReaderSize(r, readBufferSize),
+		frameMax: frameMax,
+	}
+}
+
+// SetFrameMax sets the largest frame accepted, as negotiated with
+// connection.tune-ok
+func (fr *FrameReader) SetFrameMax(frameMax uint32) {
+	fr.frameMax = frameMax
+}
+
+// Read reads raw bytes, such as the protocol header, through the buffer
+func (fr *FrameReader) Read(p []byte) (int, error) {
+	return fr.r.Read(p)
+}
+
+// ReadFrame returns the next frame: its header and payload, without the
+// frame-end. A frame larger than frame_max or missing its frame-end fails
+// with FRAME_ERROR.
+func (fr *FrameReader) ReadFrame() ([]byte, error) {
+	// give the previous frame back before waiting for the next one, so an
+	// idle connection holds no buffer
+	fr.release()
+
+	if _, err := io.ReadFull(fr.r, fr.header[:]); err != nil {
+		return nil, err
+	}
+	payloadSize := binary.BigEndian.Uint32(fr.header[3:])
+	if fr.frameMax > 0 && uint64(payloadSize)+8 > uint64(fr.frameMax) {
+		return nil, amqp.NewError(constants.FRAME_ERROR,
+			"frame size %d exceeds frame_max %d", uint64(payloadSize)+8, fr.frameMax)
+	}
+
+	size := len(fr.header) + int(payloadSize) + 1
+	buf := frameBuffers.Get().(*[]byte)
+	if cap(*buf) < size {
+		frameBuffers.Put(buf)
+		frame := make([]byte, size)
+		buf = &frame
+	}
+	fr.buf = buf
+	frame := (*buf)[:size]
+	copy(frame, fr.header[:])
+	if _, err := io.ReadFull(fr.r, frame[len(fr.header):]); err != nil {
+		return nil, err
+	}
+	if frame[size-1] != 0xCE {
+		return nil, amqp.NewError(constants.FRAME_ERROR, "invalid frame end octet %x", frame[size-1])
+	}
+	return frame[:size-1], nil
+}
+
+func (fr *FrameReader) release() {
+	if fr.buf != nil {
+		frameBuffers.Put(fr.buf)
+		fr.buf = nil
+	}
+}
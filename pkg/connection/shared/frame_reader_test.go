@@ -0,0 +1,100 @@
+package shared
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/connection/constants"
+)
+
+func testFrame(channel uint16, payload []byte) []byte {
+	frame := FormatHeader(uint8(constants.TYPE_BODY), channel, uint32(len(payload)))
+	frame = append(frame, payload...)
+	return append(frame, 0xCE)
+}
+
+func TestFrameReader(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(testFrame(1, []byte("hello")))
+	stream.Write(testFrame(2, nil))
+	reader := NewFrameReader(&stream, 4096)
+
+	frame, err := reader.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testFrame(1, []byte("hello")); !bytes.Equal(frame, want[:len(want)-1]) {
+		t.Errorf("ReadFrame() = %x, want %x", frame, want[:len(want)-1])
+	}
+	frame, err = reader.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frame) != 7 || frame[2] != 2 {
+		t.Errorf("ReadFrame() = %x, want the empty frame of channel 2", frame)
+	}
+}
+
+func TestFrameReaderErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+	}{
+		{"over frame_max", testFrame(1, make([]byte, 4096-7))},
+		{"bad frame end", append(testFrame(1, []byte("x"))[:8], 0xCD)},
+	}
+	for _, tt := range tests {
+		reader := NewFrameReader(bytes.NewReader(tt.frame), 4096)
+		_, err := reader.ReadFrame()
+		var amqpErr *amqp.Error
+		if !errors.As(err, &amqpErr) || amqpErr.ReplyCode != constants.FRAME_ERROR {
+			t.Errorf("%s: ReadFrame() error = %v, want FRAME_ERROR", tt.name, err)
+		}
+	}
+}
+
+// TestFrameReaderAllocations checks frames are read into the pooled
+// buffers
+func TestFrameReaderAllocations(t *testing.T) {
+	frame := testFrame(1, make([]byte, 1000))
+	stream := bytes.NewReader(nil)
+	data := bytes.Repeat(frame, 1000)
+	reader := NewFrameReader(stream, 0)
+	stream.Reset(data)
+	allocs := testing.AllocsPerRun(500, func() {
+		if _, err := reader.ReadFrame(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("ReadFrame() allocates %v times per frame", allocs)
+	}
+}
+
+// TestFrameReaderReadsSplitBody reads a body split to fit frame_max
+func TestFrameReaderReadsSplitBody(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 1000)
+	content := amqp.ResponseContent{Channel: 1, ClassID: 60, Message: amqp.Message{Body: body}}
+	frames := content.FormatBodyFrames(4096)
+	if len(frames) != 3 {
+		t.Fatalf("body split in %d frames, want 3", len(frames))
+	}
+
+	reader := NewFrameReader(bytes.NewReader(bytes.Join(frames, nil)), 4096)
+	var received []byte
+	for range frames {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		received = append(received, frame[7:]...)
+	}
+	if !bytes.Equal(received, body) {
+		t.Fatal("reassembled body differs")
+	}
+	if frames := content.FormatBodyFrames(0); len(frames) != 1 {
+		t.Fatalf("body split in %d frames without frame_max, want 1", len(frames))
+	}
+}
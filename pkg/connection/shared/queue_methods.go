@@ -21,6 +21,12 @@ func parseQueueMethod(methodID uint16, payload []byte) (interface{}, error) {
 	case uint16(constants.QUEUE_BIND):
 		fmt.Printf("[DEBUG] Received QUEUE_BIND frame \n")
 		return parseQueueBindFrame(payload)
+	case uint16(constants.QUEUE_UNBIND):
+		fmt.Printf("[DEBUG] Received QUEUE_UNBIND frame \n")
+		return parseQueueUnbindFrame(payload)
+	case uint16(constants.QUEUE_PURGE):
+		fmt.Printf("[DEBUG] Received QUEUE_PURGE frame \n")
+		return parseQueuePurgeFrame(payload)
 
 	default:
 		return nil, fmt.Errorf("unknown method ID: %d", methodID)
@@ -29,14 +35,13 @@ func parseQueueMethod(methodID uint16, payload []byte) (interface{}, error) {
 
 // Fields:
 // 0-1: reserved short int
-// 2: exchange name - length (short)
-// 3: type - (string)
-// 4: passive - (bit)
-// 5: durable - (bit)
-// 6: reserved 2
-// 7: reserved 3
-// 8: no-wait - (bit)
-// 9: arguments - (table)
+// 2: queue name - length (short)
+// 3: passive - (bit)
+// 4: durable - (bit)
+// 5: exclusive - (bit)
+// 6: auto-delete - (bit)
+// 7: no-wait - (bit)
+// 8: arguments - (table)
 func parseQueueDeclareFrame(payload []byte) (*amqp.RequestMethodMessage, error) {
 	if len(payload) < 6 {
 		return nil, fmt.Errorf("payload too short")
@@ -52,21 +57,21 @@ func parseQueueDeclareFrame(payload []byte) (*amqp.RequestMethodMessage, error)
 	}
 	queueName, err := DecodeShortStr(buf)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode exchange name: %v", err)
+		return nil, fmt.Errorf("failed to decode queue name: %v", err)
 	}
 	octet, err := buf.ReadByte()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read octet: %v", err)
 	}
 	flags := DecodeQueueDeclareFlags(octet)
-	ifUnused := flags["ifUnused"]
+	passive := flags["passive"]
 	durable := flags["durable"]
 	exclusive := flags["exclusive"]
+	autoDelete := flags["autoDelete"]
 	noWait := flags["noWait"]
 
 	var arguments map[string]interface{}
-	if buf.Len() > 4 {
-
+	if buf.Len() >= 4 {
 		argumentsStr, err := DecodeLongStr(buf)
 		if err != nil {
 			return nil, fmt.Errorf("failed to decode arguments: %v", err)
@@ -77,12 +82,13 @@ func parseQueueDeclareFrame(payload []byte) (*amqp.RequestMethodMessage, error)
 		}
 	}
 	msg := &message.QueueDeclareMessage{
-		QueueName: queueName,
-		Durable:   durable,
-		IfUnused:  ifUnused,
-		Exclusive: exclusive,
-		NoWait:    noWait,
-		Arguments: arguments,
+		QueueName:  queueName,
+		Passive:    passive,
+		Durable:    durable,
+		Exclusive:  exclusive,
+		AutoDelete: autoDelete,
+		NoWait:     noWait,
+		Arguments:  arguments,
 	}
 	request := &amqp.RequestMethodMessage{
 		Content: msg,
@@ -93,11 +99,12 @@ func parseQueueDeclareFrame(payload []byte) (*amqp.RequestMethodMessage, error)
 
 // Fields:
 // 0-1: reserved short int
-// 2: exchange name - length (short)
+// 2: queue name - length (short)
 // 3: if-unused - (bit)
-// 4: no-wait - (bit)
+// 4: if-empty - (bit)
+// 5: no-wait - (bit)
 func parseQueueDeleteFrame(payload []byte) (*amqp.RequestMethodMessage, error) {
-	if len(payload) < 6 {
+	if len(payload) < 4 {
 		return nil, fmt.Errorf("payload too short")
 	}
 	fmt.Printf("[DEBUG] Received QUEUE_DELETE frame %x \n", payload)
@@ -110,21 +117,23 @@ func parseQueueDeleteFrame(payload []byte) (*amqp.RequestMethodMessage, error) {
 	if reserverd1 != 0 {
 		return nil, fmt.Errorf("reserved1 must be 0")
 	}
-	exchangeName, err := DecodeShortStr(buf)
+	queueName, err := DecodeShortStr(buf)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode exchange name: %v", err)
+		return nil, fmt.Errorf("failed to decode queue name: %v", err)
 	}
 	octet, err := buf.ReadByte()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read octet: %v", err)
 	}
-	flags := DecodeQueueDeclareFlags(octet)
+	flags := DecodeQueueDeleteFlags(octet)
 	ifUnused := flags["ifUnused"]
+	ifEmpty := flags["ifEmpty"]
 	noWait := flags["noWait"]
 
 	msg := &message.QueueDeleteMessage{
-		QueueName: exchangeName,
+		QueueName: queueName,
 		IfUnused:  ifUnused,
+		IfEmpty:   ifEmpty,
 		NoWait:    noWait,
 	}
 	request := &amqp.RequestMethodMessage{
@@ -167,8 +176,11 @@ func parseQueueBindFrame(payload []byte) (*amqp.RequestMethodMessage, error) {
 	flags := DecodeQueueBindFlags(octet)
 	noWait := flags["noWait"]
 	arguments := make(map[string]interface{})
-	if buf.Len() > 4 {
+	if buf.Len() >= 4 {
 		argumentsStr, err := DecodeLongStr(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode arguments: %v", err)
+		}
 		arguments, err = DecodeTable([]byte(argumentsStr))
 		if err != nil {
 			return nil, fmt.Errorf("failed to read arguments: %v", err)
@@ -187,3 +199,97 @@ func parseQueueBindFrame(payload []byte) (*amqp.RequestMethodMessage, error) {
 	fmt.Printf("[DEBUG] Queue fomated: %+v \n", msg)
 	return request, nil
 }
+
+// Fields:
+// 0-1: reserved short int
+// 2: queue name - length (short)
+// 3: exchange name - length (short)
+// 4: routing key - length (short)
+// 5: arguments - (table)
+func parseQueueUnbindFrame(payload []byte) (*amqp.RequestMethodMessage, error) {
+	if len(payload) < 5 {
+		return nil, fmt.Errorf("payload too short")
+	}
+	fmt.Printf("[DEBUG] Received QUEUE_UNBIND frame %x \n", payload)
+	buf := bytes.NewReader(payload)
+	reserverd1, err := DecodeShortInt(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode reserved1: %v", err)
+	}
+	if reserverd1 != 0 {
+		return nil, fmt.Errorf("reserved1 must be 0")
+	}
+	queue, err := DecodeShortStr(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode queue name: %v", err)
+	}
+	exchange, err := DecodeShortStr(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode exchange name: %v", err)
+	}
+	routingKey, err := DecodeShortStr(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode routing key: %v", err)
+	}
+	arguments := make(map[string]interface{})
+	if buf.Len() >= 4 {
+		argumentsStr, err := DecodeLongStr(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode arguments: %v", err)
+		}
+		arguments, err = DecodeTable([]byte(argumentsStr))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read arguments: %v", err)
+		}
+	}
+	msg := &message.QueueUnbindMessage{
+		Queue:      queue,
+		Exchange:   exchange,
+		RoutingKey: routingKey,
+		Arguments:  arguments,
+	}
+	request := &amqp.RequestMethodMessage{
+		Content: msg,
+	}
+	fmt.Printf("[DEBUG] Queue fomated: %+v \n", msg)
+	return request, nil
+}
+
+// Fields:
+// 0-1: reserved short int
+// 2: queue name - length (short)
+// 3: no-wait - (bit)
+func parseQueuePurgeFrame(payload []byte) (*amqp.RequestMethodMessage, error) {
+	if len(payload) < 4 {
+		return nil, fmt.Errorf("payload too short")
+	}
+	fmt.Printf("[DEBUG] Received QUEUE_PURGE frame %x \n", payload)
+
+	buf := bytes.NewReader(payload)
+	reserverd1, err := DecodeShortInt(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode reserved1: %v", err)
+	}
+	if reserverd1 != 0 {
+		return nil, fmt.Errorf("reserved1 must be 0")
+	}
+	queueName, err := DecodeShortStr(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode queue name: %v", err)
+	}
+	octet, err := buf.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read octet: %v", err)
+	}
+	flags := DecodeQueuePurgeFlags(octet)
+
+	msg := &message.QueuePurgeMessage{
+		QueueName: queueName,
+		NoWait:    flags["noWait"],
+	}
+	request := &amqp.RequestMethodMessage{
+		Content: msg,
+	}
+	fmt.Printf("[DEBUG] Queue fomated: %+v \n", msg)
+	return request, nil
+}
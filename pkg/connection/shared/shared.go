@@ -52,10 +52,16 @@ func ReadHeader(conn net.Conn) ([]byte, error) {
 	return header, nil
 }
 
+// ReadFrame returns the next frame of the connection: its header and
+// payload, without the frame-end. On a FrameConn, the frame is only valid
+// until the next call.
 func ReadFrame(conn net.Conn) ([]byte, error) {
+	if frameConn, ok := conn.(*FrameConn); ok {
+		return frameConn.ReadFrame()
+	}
 	// all frames starts with a 7-octet header
-	frameHeader := make([]byte, 7)
-	_, err := io.ReadFull(conn, frameHeader)
+	var frameHeader [7]byte
+	_, err := io.ReadFull(conn, frameHeader[:])
 	if err != nil {
 		return nil, err
 	}
@@ -69,27 +75,21 @@ func ReadFrame(conn net.Conn) ([]byte, error) {
 	// 4th to 7th octets (long) are the size of the payload
 	payloadSize := binary.BigEndian.Uint32(frameHeader[3:])
 
-	// read the framePayload
-	framePayload := make([]byte, payloadSize)
-	_, err = io.ReadFull(conn, framePayload)
-	if err != nil {
-		return nil, err
-	}
-
-	// frame-end is a 1-octet after the payload
-	frameEnd := make([]byte, 1)
-	_, err = io.ReadFull(conn, frameEnd)
+	// read the framePayload and the frame-end, a 1-octet after the payload
+	frame := make([]byte, len(frameHeader)+int(payloadSize)+1)
+	copy(frame, frameHeader[:])
+	_, err = io.ReadFull(conn, frame[len(frameHeader):])
 	if err != nil {
 		return nil, err
 	}
 
 	// check if the frame-end is correct (0xCE)
-	if frameEnd[0] != 0xCE {
+	if frame[len(frame)-1] != 0xCE {
 		// return nil, ErrInvalidFrameEnd
 		return nil, fmt.Errorf("invalid frame end octet")
 	}
 
-	return append(frameHeader, framePayload...), nil
+	return frame[:len(frame)-1], nil
 }
 
 func FormatHeader(frameType uint8, channel uint16, payloadSize uint32) []byte {
@@ -244,15 +244,74 @@ func ParseMethodFrame(configurations *map[string]interface{}, channel uint16, pa
 		}
 		return nil, nil
 
+	case uint16(constants.CONFIRM):
+		fmt.Printf("[DEBUG] Received CONFIRM frame on channel %d\n", channel)
+		request, err := parseConfirmMethod(methodID, methodPayload)
+		if err != nil {
+			return nil, err
+		}
+		if request != nil {
+			msg, ok := request.(*amqp.RequestMethodMessage)
+			if ok {
+				msg.Channel = channel
+				msg.ClassID = classID
+				msg.MethodID = methodID
+				state := &amqp.ChannelState{
+					MethodFrame: msg,
+				}
+				return state, nil
+			}
+		}
+		return nil, nil
+
+	case uint16(constants.TX):
+		fmt.Printf("[DEBUG] Received TX frame on channel %d\n", channel)
+		request, err := parseTxMethod(methodID, methodPayload)
+		if err != nil {
+			return nil, err
+		}
+		if request != nil {
+			msg, ok := request.(*amqp.RequestMethodMessage)
+			if ok {
+				msg.Channel = channel
+				msg.ClassID = classID
+				msg.MethodID = methodID
+				state := &amqp.ChannelState{
+					MethodFrame: msg,
+				}
+				return state, nil
+			}
+		}
+		return nil, nil
+
 	default:
 		fmt.Printf("[DEBUG] Unknown class ID: %d\n", classID)
 		return nil, fmt.Errorf("unknown class ID: %d", classID)
 	}
 }
 
+// FrameMax returns the largest frame the peer accepts, as negotiated on the
+// connection; 0 when there is no limit
+func FrameMax(conn net.Conn) uint32 {
+	if frameConn, ok := conn.(*FrameConn); ok {
+		return frameConn.FrameMax()
+	}
+	return 0
+}
+
 func SendFrame(conn net.Conn, frame []byte) error {
-	fmt.Printf("[DEBUG] Sending frame: %x\n", frame)
-	_, err := conn.Write(frame)
+	return SendFrames(conn, frame)
+}
+
+// SendFrames writes the frames back to back. On a FrameConn, no frame of
+// another sender can come in between, so the method, header and body frames
+// of a message are kept together.
+func SendFrames(conn net.Conn, frames ...[]byte) error {
+	if frameConn, ok := conn.(*FrameConn); ok {
+		return frameConn.WriteFrames(frames...)
+	}
+	buffers := net.Buffers(frames)
+	_, err := buffers.WriteTo(conn)
 	return err
 }
 
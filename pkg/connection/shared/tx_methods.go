@@ -0,0 +1,25 @@
+package shared
+
+import (
+	"fmt"
+
+	"github.com/andrelcunha/ottermq/pkg/common/communication/amqp"
+	"github.com/andrelcunha/ottermq/pkg/connection/constants/tx"
+)
+
+// tx.select, tx.commit and tx.rollback carry no fields
+func parseTxMethod(methodID uint16, payload []byte) (interface{}, error) {
+	switch methodID {
+	case uint16(tx.SELECT):
+		fmt.Printf("[DEBUG] Received TX_SELECT frame \n")
+	case uint16(tx.COMMIT):
+		fmt.Printf("[DEBUG] Received TX_COMMIT frame \n")
+	case uint16(tx.ROLLBACK):
+		fmt.Printf("[DEBUG] Received TX_ROLLBACK frame \n")
+	default:
+		return nil, fmt.Errorf("unknown method ID: %d", methodID)
+	}
+	return &amqp.RequestMethodMessage{
+		Content: nil,
+	}, nil
+}
@@ -8,6 +8,12 @@ import (
 	"time"
 )
 
+// Decimal is the AMQP decimal field value: Value / 10^Scale
+type Decimal struct {
+	Scale uint8
+	Value int32
+}
+
 // decodeTable decodes an AMQP field table from a byte slice
 func DecodeTable(data []byte) (map[string]interface{}, error) {
 
@@ -22,73 +28,123 @@ func DecodeTable(data []byte) (map[string]interface{}, error) {
 		}
 
 		fieldName := make([]byte, fieldNameLength)
-		_, err = buf.Read(fieldName)
+		_, err = io.ReadFull(buf, fieldName)
 		if err != nil {
 			return nil, err
 		}
 
-		// Read field value type
-		fieldType, err := buf.ReadByte()
+		value, err := decodeFieldValue(buf)
 		if err != nil {
 			return nil, err
 		}
+		table[string(fieldName)] = value
+	}
+	return table, nil
+}
 
-		// Read field value based on the type
-		switch fieldType {
-		case 'S': // String
-			var strLength uint32
-			if err := binary.Read(buf, binary.BigEndian, &strLength); err != nil {
-				return nil, err
-			}
-
-			strValue := make([]byte, strLength)
-			_, err := buf.Read(strValue)
-			if err != nil {
-				return nil, err
-			}
-
-			table[string(fieldName)] = string(strValue)
+// decodeFieldValue reads a field value type followed by the value
+func decodeFieldValue(buf *bytes.Reader) (interface{}, error) {
+	fieldType, err := buf.ReadByte()
+	if err != nil {
+		return nil, err
+	}
 
-		case 'I': // Integer (simplified, normally long-int should be used)
-			var intValue int32
-			if err := binary.Read(buf, binary.BigEndian, &intValue); err != nil {
-				return nil, err
-			}
+	switch fieldType {
+	case 't':
+		return DecodeBoolean(buf)
+
+	case 'b':
+		var value int8
+		err := binary.Read(buf, binary.BigEndian, &value)
+		return value, err
+
+	case 'B':
+		var value uint8
+		err := binary.Read(buf, binary.BigEndian, &value)
+		return value, err
+
+	case 's':
+		var value int16
+		err := binary.Read(buf, binary.BigEndian, &value)
+		return value, err
+
+	case 'u':
+		var value uint16
+		err := binary.Read(buf, binary.BigEndian, &value)
+		return value, err
+
+	case 'I':
+		var value int32
+		err := binary.Read(buf, binary.BigEndian, &value)
+		return value, err
+
+	case 'i':
+		var value uint32
+		err := binary.Read(buf, binary.BigEndian, &value)
+		return value, err
+
+	case 'l':
+		var value int64
+		err := binary.Read(buf, binary.BigEndian, &value)
+		return value, err
+
+	case 'f':
+		var value float32
+		err := binary.Read(buf, binary.BigEndian, &value)
+		return value, err
+
+	case 'd':
+		var value float64
+		err := binary.Read(buf, binary.BigEndian, &value)
+		return value, err
+
+	case 'D':
+		var value Decimal
+		if err := binary.Read(buf, binary.BigEndian, &value.Scale); err != nil {
+			return nil, err
+		}
+		err := binary.Read(buf, binary.BigEndian, &value.Value)
+		return value, err
 
-			table[string(fieldName)] = intValue
+	case 'S':
+		return DecodeLongStr(buf)
 
-		case 'F':
-			var strLength uint32
-			if err := binary.Read(buf, binary.BigEndian, &strLength); err != nil {
-				return nil, err
-			}
+	case 'x':
+		value, err := DecodeLongStr(buf)
+		return []byte(value), err
 
-			strValue := make([]byte, strLength)
-			_, err := buf.Read(strValue)
+	case 'A':
+		data, err := DecodeLongStr(buf)
+		if err != nil {
+			return nil, err
+		}
+		array := make([]interface{}, 0)
+		arrayBuf := bytes.NewReader([]byte(data))
+		for arrayBuf.Len() > 0 {
+			value, err := decodeFieldValue(arrayBuf)
 			if err != nil {
 				return nil, err
 			}
+			array = append(array, value)
+		}
+		return array, nil
 
-			value, err := DecodeTable(strValue)
-			if err != nil {
-				return nil, err
-			}
-			table[string(fieldName)] = value
+	case 'T':
+		return DecodeTimestamp(buf)
 
-		case 't':
-			value, err := DecodeBoolean(buf)
-			if err != nil {
-				return nil, err
-			}
-			table[string(fieldName)] = value
+	case 'F':
+		data, err := DecodeLongStr(buf)
+		if err != nil {
+			return nil, err
+		}
+		return DecodeTable([]byte(data))
 
-		// Add cases for other types as needed
+	case 'V':
+		return nil, nil
 
-		default:
-			return nil, fmt.Errorf("unknown field type: %c", fieldType)
-		}
+	default:
+		return nil, fmt.Errorf("unknown field type: %c", fieldType)
 	}
-	return table, nil
 }
 
 // DecodeTimestamp reads and decodes a 64-bit POSIX timestamp from a bytes.Reader
@@ -109,7 +165,7 @@ func DecodeLongStr(buf *bytes.Reader) (string, error) {
 	}
 
 	strData := make([]byte, strLen)
-	_, err = buf.Read(strData)
+	_, err = io.ReadFull(buf, strData)
 	if err != nil {
 		return "", err
 	}
@@ -125,7 +181,7 @@ func DecodeShortStr(buf *bytes.Reader) (string, error) {
 	}
 
 	strData := make([]byte, strLen)
-	_, err = buf.Read(strData)
+	_, err = io.ReadFull(buf, strData)
 	if err != nil {
 		return "", err
 	}
@@ -174,7 +230,7 @@ func DecodeExchangeDeclareFlags(octet byte) map[string]bool {
 	flagNames := []string{"passive", "durable", "autoDelete", "internal", "noWait", "flag6", "flag7", "flag8"}
 
 	for i := 0; i < 8; i++ {
-		flags[flagNames[i]] = (octet & (1 << uint(7-i))) != 0
+		flags[flagNames[i]] = (octet & (1 << uint(i))) != 0
 	}
 
 	return flags
@@ -185,7 +241,7 @@ func DecodeExchangeDeleteFlags(octet byte) map[string]bool {
 	flagNames := []string{"ifUnused", "noWait", "flag3", "flag4", "flag5", "flag6", "flag7", "flag8"}
 
 	for i := 0; i < 8; i++ {
-		flags[flagNames[i]] = (octet & (1 << uint(7-i))) != 0
+		flags[flagNames[i]] = (octet & (1 << uint(i))) != 0
 	}
 
 	return flags
@@ -193,10 +249,10 @@ func DecodeExchangeDeleteFlags(octet byte) map[string]bool {
 
 func DecodeQueueDeclareFlags(octet byte) map[string]bool {
 	flags := make(map[string]bool)
-	flagNames := []string{"passive", "durable", "ifUnused", "exclusive", "noWait", "flag6", "flag7", "flag8"}
+	flagNames := []string{"passive", "durable", "exclusive", "autoDelete", "noWait", "flag6", "flag7", "flag8"}
 
 	for i := 0; i < 8; i++ {
-		flags[flagNames[i]] = (octet & (1 << uint(7-i))) != 0
+		flags[flagNames[i]] = (octet & (1 << uint(i))) != 0
 	}
 
 	return flags
@@ -204,10 +260,21 @@ func DecodeQueueDeclareFlags(octet byte) map[string]bool {
 
 func DecodeQueueDeleteFlags(octet byte) map[string]bool {
 	flags := make(map[string]bool)
-	flagNames := []string{"ifUnused", "noWait", "flag3", "flag4", "flag5", "flag6", "flag7", "flag8"}
+	flagNames := []string{"ifUnused", "ifEmpty", "noWait", "flag4", "flag5", "flag6", "flag7", "flag8"}
+
+	for i := 0; i < 8; i++ {
+		flags[flagNames[i]] = (octet & (1 << uint(i))) != 0
+	}
+
+	return flags
+}
+
+func DecodeQueuePurgeFlags(octet byte) map[string]bool {
+	flags := make(map[string]bool)
+	flagNames := []string{"noWait", "flag2", "flag3", "flag4", "flag5", "flag6", "flag7", "flag8"}
 
 	for i := 0; i < 8; i++ {
-		flags[flagNames[i]] = (octet & (1 << uint(7-i))) != 0
+		flags[flagNames[i]] = (octet & (1 << uint(i))) != 0
 	}
 
 	return flags
@@ -218,7 +285,7 @@ func DecodeQueueBindFlags(octet byte) map[string]bool {
 	flagNames := []string{"noWait", "flag2", "flag3", "flag4", "flag5", "flag6", "flag7", "flag8"}
 
 	for i := 0; i < 8; i++ {
-		flags[flagNames[i]] = (octet & (1 << uint(7-i))) != 0
+		flags[flagNames[i]] = (octet & (1 << uint(i))) != 0
 	}
 
 	return flags
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"strings"
+	"time"
 )
 
 // encodeTable encodes a proper AMQP field table
@@ -15,37 +16,100 @@ func EncodeTable(table map[string]interface{}) []byte {
 		buf.Write(EncodeShortStr(key))
 
 		// Field value type and value
-		switch v := value.(type) {
-		case string:
-			buf.WriteByte('S') // Field value type 'S' (string)
-			buf.Write(EncodeLongStr([]byte(v)))
-
-		case int:
-			buf.WriteByte('I') // Field value type 'I' (int)
-			binary.Write(&buf, binary.BigEndian, int32(v))
-
-		// In the case map[string]interface:
-		case map[string]interface{}:
-			// Recursively encode the nested map
-			buf.WriteByte('F') // Field value type 'F' (field table)
-			encodedTable := EncodeTable(v)
-			buf.Write(EncodeLongStr(encodedTable))
-
-		case bool:
-			buf.WriteByte('t')
-			if v {
-				buf.WriteByte(1)
-			} else {
-				buf.WriteByte(0)
-			}
-
-		default:
-			buf.WriteByte('U')
-		}
+		encodeFieldValue(&buf, value)
 	}
 	return buf.Bytes()
 }
 
+// encodeFieldValue writes the field value type followed by the value
+func encodeFieldValue(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case bool:
+		buf.WriteByte('t')
+		if v {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+
+	case int8:
+		buf.WriteByte('b')
+		binary.Write(buf, binary.BigEndian, v)
+
+	case uint8:
+		buf.WriteByte('B')
+		binary.Write(buf, binary.BigEndian, v)
+
+	case int16:
+		buf.WriteByte('s')
+		binary.Write(buf, binary.BigEndian, v)
+
+	case uint16:
+		buf.WriteByte('u')
+		binary.Write(buf, binary.BigEndian, v)
+
+	case int:
+		buf.WriteByte('I') // Field value type 'I' (int)
+		binary.Write(buf, binary.BigEndian, int32(v))
+
+	case int32:
+		buf.WriteByte('I')
+		binary.Write(buf, binary.BigEndian, v)
+
+	case uint32:
+		buf.WriteByte('i')
+		binary.Write(buf, binary.BigEndian, v)
+
+	case int64:
+		buf.WriteByte('l')
+		binary.Write(buf, binary.BigEndian, v)
+
+	case float32:
+		buf.WriteByte('f')
+		binary.Write(buf, binary.BigEndian, v)
+
+	case float64:
+		buf.WriteByte('d')
+		binary.Write(buf, binary.BigEndian, v)
+
+	case Decimal:
+		buf.WriteByte('D')
+		binary.Write(buf, binary.BigEndian, v.Scale)
+		binary.Write(buf, binary.BigEndian, v.Value)
+
+	case string:
+		buf.WriteByte('S') // Field value type 'S' (string)
+		buf.Write(EncodeLongStr([]byte(v)))
+
+	case []byte:
+		buf.WriteByte('x')
+		buf.Write(EncodeLongStr(v))
+
+	case []interface{}:
+		buf.WriteByte('A')
+		var array bytes.Buffer
+		for _, item := range v {
+			encodeFieldValue(&array, item)
+		}
+		buf.Write(EncodeLongStr(array.Bytes()))
+
+	case time.Time:
+		buf.WriteByte('T')
+		binary.Write(buf, binary.BigEndian, uint64(v.Unix()))
+
+	// In the case map[string]interface:
+	case map[string]interface{}:
+		// Recursively encode the nested map
+		buf.WriteByte('F') // Field value type 'F' (field table)
+		encodedTable := EncodeTable(v)
+		buf.Write(EncodeLongStr(encodedTable))
+
+	default:
+		// nil and unsupported values are sent as void
+		buf.WriteByte('V')
+	}
+}
+
 func EncodeLongStr(data []byte) []byte {
 	var buf bytes.Buffer
 	binary.Write(&buf, binary.BigEndian, uint32(len(data)))
@@ -3,11 +3,18 @@ package persistdb
 import (
 	"database/sql"
 	"log"
+	"sync"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var db *sql.DB
+var (
+	db *sql.DB
+	// OpenDB and CloseDB calls may nest and come from several goroutines:
+	// the database is closed when the last user closes it
+	dbMu   sync.Mutex
+	dbRefs int
+)
 
 // const dbPath = "./data/ottermq.db"
 var dbPath string
@@ -17,13 +24,11 @@ func SetDbPath(path string) {
 }
 
 func InitDB() {
-	var err error
-	db, err = sql.Open("sqlite3", dbPath)
-	if err != nil {
+	if err := OpenDB(); err != nil {
 		log.Fatal(err)
 	}
 	createTables()
-	db.Close()
+	CloseDB()
 }
 
 func createTables() {
@@ -74,17 +79,113 @@ func createTables() {
 	if err != nil {
 		log.Fatalf("Faled to create 'role_permissions' table: %v\n", err)
 	}
+
+	createUserPermissionsTable := `
+	CREATE TABLE IF NOT EXISTS user_permissions (
+		username TEXT NOT NULL,
+		vhost TEXT NOT NULL,
+		configure TEXT NOT NULL,
+		write TEXT NOT NULL,
+		read TEXT NOT NULL,
+		PRIMARY KEY(username, vhost)
+	);`
+	_, err = db.Exec(createUserPermissionsTable)
+	if err != nil {
+		log.Fatalf("Faled to create 'user_permissions' table: %v\n", err)
+	}
+
+	createTopicPermissionsTable := `
+	CREATE TABLE IF NOT EXISTS topic_permissions (
+		username TEXT NOT NULL,
+		vhost TEXT NOT NULL,
+		exchange TEXT NOT NULL,
+		write TEXT NOT NULL,
+		read TEXT NOT NULL,
+		PRIMARY KEY(username, vhost, exchange)
+	);`
+	_, err = db.Exec(createTopicPermissionsTable)
+	if err != nil {
+		log.Fatalf("Faled to create 'topic_permissions' table: %v\n", err)
+	}
+
+	createVHostsTable := `
+	CREATE TABLE IF NOT EXISTS vhosts (
+		name TEXT PRIMARY KEY
+	);`
+	_, err = db.Exec(createVHostsTable)
+	if err != nil {
+		log.Fatalf("Failed to create 'vhosts' table: %v\n", err)
+	}
+
+	createExchangesTable := `
+	CREATE TABLE IF NOT EXISTS exchanges (
+		vhost TEXT NOT NULL,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		auto_delete INTEGER NOT NULL,
+		internal INTEGER NOT NULL,
+		arguments BLOB,
+		PRIMARY KEY(vhost, name)
+	);`
+	_, err = db.Exec(createExchangesTable)
+	if err != nil {
+		log.Fatalf("Failed to create 'exchanges' table: %v\n", err)
+	}
+
+	createQueuesTable := `
+	CREATE TABLE IF NOT EXISTS queues (
+		vhost TEXT NOT NULL,
+		name TEXT NOT NULL,
+		auto_delete INTEGER NOT NULL,
+		arguments BLOB,
+		PRIMARY KEY(vhost, name)
+	);`
+	_, err = db.Exec(createQueuesTable)
+	if err != nil {
+		log.Fatalf("Failed to create 'queues' table: %v\n", err)
+	}
+
+	createBindingsTable := `
+	CREATE TABLE IF NOT EXISTS bindings (
+		vhost TEXT NOT NULL,
+		exchange TEXT NOT NULL,
+		queue TEXT NOT NULL,
+		routing_key TEXT NOT NULL,
+		arguments_key TEXT NOT NULL,
+		arguments BLOB,
+		PRIMARY KEY(vhost, exchange, queue, routing_key, arguments_key)
+	);`
+	_, err = db.Exec(createBindingsTable)
+	if err != nil {
+		log.Fatalf("Failed to create 'bindings' table: %v\n", err)
+	}
 }
 
 func CloseDB() {
-	db.Close()
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	if dbRefs == 0 {
+		return
+	}
+	dbRefs--
+	if dbRefs == 0 {
+		db.Close()
+	}
 }
 
 func OpenDB() error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	if dbRefs > 0 {
+		dbRefs++
+		return nil
+	}
 	var err error
 	db, err = sql.Open("sqlite3", dbPath)
 	if err != nil {
 		log.Println("Error opening database: ", err.Error())
+		return err
 	}
-	return err
+	dbRefs++
+	return nil
 }
@@ -7,6 +7,14 @@ type User struct {
 	RoleID   int    `json:"role_id"`
 }
 
+// AdminRoleID is the id of the admin role, the first of the default roles
+const AdminRoleID = 1
+
+// IsAdmin tells whether the user has the admin role
+func (u *User) IsAdmin() bool {
+	return u != nil && u.RoleID == AdminRoleID
+}
+
 type Role struct {
 	ID          int    `json:"id"`
 	Name        string `json:"name"`
@@ -24,6 +32,57 @@ type RolePermission struct {
 	PermissionID int `json:"permission_id"`
 }
 
+// UserPermission holds the configure, write and read regular expressions
+// of a user in a vhost
+type UserPermission struct {
+	Username  string `json:"username"`
+	VHost     string `json:"vhost"`
+	Configure string `json:"configure"`
+	Write     string `json:"write"`
+	Read      string `json:"read"`
+}
+
+// TopicPermission holds the write and read regular expressions matching
+// the routing keys a user may publish or bind with on a topic exchange
+type TopicPermission struct {
+	Username string `json:"username"`
+	VHost    string `json:"vhost"`
+	Exchange string `json:"exchange"`
+	Write    string `json:"write"`
+	Read     string `json:"read"`
+}
+
+// ExchangeRecord is a durable exchange. The arguments are an encoded AMQP
+// field table.
+type ExchangeRecord struct {
+	VHost      string `json:"vhost"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	AutoDelete bool   `json:"auto_delete"`
+	Internal   bool   `json:"internal"`
+	Arguments  []byte `json:"arguments"`
+}
+
+// QueueRecord is a durable queue. The arguments are an encoded AMQP field
+// table.
+type QueueRecord struct {
+	VHost      string `json:"vhost"`
+	Name       string `json:"name"`
+	AutoDelete bool   `json:"auto_delete"`
+	Arguments  []byte `json:"arguments"`
+}
+
+// BindingRecord binds a durable queue to a durable exchange. ArgumentsKey
+// identifies the arguments of a headers binding.
+type BindingRecord struct {
+	VHost        string `json:"vhost"`
+	Exchange     string `json:"exchange"`
+	Queue        string `json:"queue"`
+	RoutingKey   string `json:"routing_key"`
+	ArgumentsKey string `json:"arguments_key"`
+	Arguments    []byte `json:"arguments"`
+}
+
 type UserListDTO struct {
 	ID          int    `json:"id"`
 	Username    string `json:"username"`
@@ -33,3 +33,15 @@ func GetRoleByID(id int) (Role, error) {
 	}
 	return role, nil
 }
+
+func GetRoleByName(name string) (Role, error) {
+	OpenDB()
+	defer CloseDB()
+	var role Role
+	err := db.QueryRow("SELECT id, name, description FROM roles WHERE name = ?", name).Scan(&role.ID, &role.Name, &role.Description)
+	if err != nil {
+		log.Printf("Failed to query role: %v\n", err)
+		return Role{}, err
+	}
+	return role, nil
+}
@@ -0,0 +1,199 @@
+package persistdb
+
+import (
+	"database/sql"
+	"log"
+)
+
+func SaveVHost(name string) error {
+	OpenDB()
+	defer CloseDB()
+	_, err := db.Exec("INSERT OR IGNORE INTO vhosts (name) VALUES (?)", name)
+	if err != nil {
+		log.Printf("Failed to save vhost: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func GetVHosts() ([]string, error) {
+	OpenDB()
+	defer CloseDB()
+	rows, err := db.Query("SELECT name FROM vhosts ORDER BY name")
+	if err != nil {
+		log.Printf("Failed to query vhosts: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	vhosts := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			log.Printf("Failed to scan vhost: %v\n", err)
+			return nil, err
+		}
+		vhosts = append(vhosts, name)
+	}
+	return vhosts, nil
+}
+
+// DeleteVHost removes the vhost along with its exchanges, queues and
+// bindings
+func DeleteVHost(name string) error {
+	return inTransaction(func(tx *sql.Tx) error {
+		for _, query := range []string{
+			"DELETE FROM bindings WHERE vhost = ?",
+			"DELETE FROM queues WHERE vhost = ?",
+			"DELETE FROM exchanges WHERE vhost = ?",
+			"DELETE FROM vhosts WHERE name = ?",
+		} {
+			if _, err := tx.Exec(query, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func SaveExchange(exchange ExchangeRecord) error {
+	OpenDB()
+	defer CloseDB()
+	_, err := db.Exec(`INSERT OR REPLACE INTO exchanges (vhost, name, type, auto_delete, internal, arguments) VALUES (?, ?, ?, ?, ?, ?)`,
+		exchange.VHost, exchange.Name, exchange.Type, exchange.AutoDelete, exchange.Internal, exchange.Arguments)
+	if err != nil {
+		log.Printf("Failed to save exchange: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteExchange removes the exchange along with its bindings
+func DeleteExchange(vhost, name string) error {
+	return inTransaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM bindings WHERE vhost = ? AND exchange = ?", vhost, name); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM exchanges WHERE vhost = ? AND name = ?", vhost, name)
+		return err
+	})
+}
+
+func SaveQueue(queue QueueRecord) error {
+	OpenDB()
+	defer CloseDB()
+	_, err := db.Exec(`INSERT OR REPLACE INTO queues (vhost, name, auto_delete, arguments) VALUES (?, ?, ?, ?)`,
+		queue.VHost, queue.Name, queue.AutoDelete, queue.Arguments)
+	if err != nil {
+		log.Printf("Failed to save queue: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteQueue removes the queue along with its bindings
+func DeleteQueue(vhost, name string) error {
+	return inTransaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM bindings WHERE vhost = ? AND queue = ?", vhost, name); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM queues WHERE vhost = ? AND name = ?", vhost, name)
+		return err
+	})
+}
+
+func SaveBinding(binding BindingRecord) error {
+	OpenDB()
+	defer CloseDB()
+	_, err := db.Exec(`INSERT OR IGNORE INTO bindings (vhost, exchange, queue, routing_key, arguments_key, arguments) VALUES (?, ?, ?, ?, ?, ?)`,
+		binding.VHost, binding.Exchange, binding.Queue, binding.RoutingKey, binding.ArgumentsKey, binding.Arguments)
+	if err != nil {
+		log.Printf("Failed to save binding: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func DeleteBinding(binding BindingRecord) error {
+	OpenDB()
+	defer CloseDB()
+	_, err := db.Exec(`DELETE FROM bindings WHERE vhost = ? AND exchange = ? AND queue = ? AND routing_key = ? AND arguments_key = ?`,
+		binding.VHost, binding.Exchange, binding.Queue, binding.RoutingKey, binding.ArgumentsKey)
+	if err != nil {
+		log.Printf("Failed to delete binding: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// GetTopology returns the durable exchanges, queues and bindings of the
+// vhost
+func GetTopology(vhost string) ([]ExchangeRecord, []QueueRecord, []BindingRecord, error) {
+	OpenDB()
+	defer CloseDB()
+	exchanges := []ExchangeRecord{}
+	rows, err := db.Query("SELECT name, type, auto_delete, internal, arguments FROM exchanges WHERE vhost = ? ORDER BY name", vhost)
+	if err != nil {
+		log.Printf("Failed to query exchanges: %v\n", err)
+		return nil, nil, nil, err
+	}
+	for rows.Next() {
+		e := ExchangeRecord{VHost: vhost}
+		if err := rows.Scan(&e.Name, &e.Type, &e.AutoDelete, &e.Internal, &e.Arguments); err != nil {
+			rows.Close()
+			return nil, nil, nil, err
+		}
+		exchanges = append(exchanges, e)
+	}
+	rows.Close()
+
+	queues := []QueueRecord{}
+	rows, err = db.Query("SELECT name, auto_delete, arguments FROM queues WHERE vhost = ? ORDER BY name", vhost)
+	if err != nil {
+		log.Printf("Failed to query queues: %v\n", err)
+		return nil, nil, nil, err
+	}
+	for rows.Next() {
+		q := QueueRecord{VHost: vhost}
+		if err := rows.Scan(&q.Name, &q.AutoDelete, &q.Arguments); err != nil {
+			rows.Close()
+			return nil, nil, nil, err
+		}
+		queues = append(queues, q)
+	}
+	rows.Close()
+
+	bindings := []BindingRecord{}
+	rows, err = db.Query("SELECT exchange, queue, routing_key, arguments_key, arguments FROM bindings WHERE vhost = ? ORDER BY rowid", vhost)
+	if err != nil {
+		log.Printf("Failed to query bindings: %v\n", err)
+		return nil, nil, nil, err
+	}
+	for rows.Next() {
+		b := BindingRecord{VHost: vhost}
+		if err := rows.Scan(&b.Exchange, &b.Queue, &b.RoutingKey, &b.ArgumentsKey, &b.Arguments); err != nil {
+			rows.Close()
+			return nil, nil, nil, err
+		}
+		bindings = append(bindings, b)
+	}
+	rows.Close()
+	return exchanges, queues, bindings, nil
+}
+
+// inTransaction runs fn in a transaction, committed if fn succeeds
+func inTransaction(fn func(tx *sql.Tx) error) error {
+	OpenDB()
+	defer CloseDB()
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v\n", err)
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		log.Printf("Failed to run transaction: %v\n", err)
+		return err
+	}
+	return tx.Commit()
+}
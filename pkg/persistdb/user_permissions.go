@@ -0,0 +1,115 @@
+package persistdb
+
+import "log"
+
+func SetUserPermission(permission UserPermission) error {
+	OpenDB()
+	defer CloseDB()
+	_, err := db.Exec(`INSERT INTO user_permissions (username, vhost, configure, write, read) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(username, vhost) DO UPDATE SET configure = excluded.configure, write = excluded.write, read = excluded.read`,
+		permission.Username, permission.VHost, permission.Configure, permission.Write, permission.Read)
+	if err != nil {
+		log.Printf("Failed to set user permission: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func GetUserPermissions() ([]UserPermission, error) {
+	OpenDB()
+	defer CloseDB()
+	rows, err := db.Query("SELECT username, vhost, configure, write, read FROM user_permissions ORDER BY vhost, username")
+	if err != nil {
+		log.Printf("Failed to query user permissions: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []UserPermission{}
+	for rows.Next() {
+		var p UserPermission
+		err := rows.Scan(&p.Username, &p.VHost, &p.Configure, &p.Write, &p.Read)
+		if err != nil {
+			log.Printf("Failed to scan user permission: %v\n", err)
+			return nil, err
+		}
+		permissions = append(permissions, p)
+	}
+	return permissions, nil
+}
+
+func DeleteUserPermission(username, vhost string) error {
+	OpenDB()
+	defer CloseDB()
+	_, err := db.Exec("DELETE FROM user_permissions WHERE username = ? AND vhost = ?", username, vhost)
+	if err != nil {
+		log.Printf("Failed to delete user permission: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func SetTopicPermission(permission TopicPermission) error {
+	OpenDB()
+	defer CloseDB()
+	_, err := db.Exec(`INSERT INTO topic_permissions (username, vhost, exchange, write, read) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(username, vhost, exchange) DO UPDATE SET write = excluded.write, read = excluded.read`,
+		permission.Username, permission.VHost, permission.Exchange, permission.Write, permission.Read)
+	if err != nil {
+		log.Printf("Failed to set topic permission: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func GetTopicPermissions() ([]TopicPermission, error) {
+	OpenDB()
+	defer CloseDB()
+	rows, err := db.Query("SELECT username, vhost, exchange, write, read FROM topic_permissions ORDER BY vhost, username, exchange")
+	if err != nil {
+		log.Printf("Failed to query topic permissions: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []TopicPermission{}
+	for rows.Next() {
+		var p TopicPermission
+		err := rows.Scan(&p.Username, &p.VHost, &p.Exchange, &p.Write, &p.Read)
+		if err != nil {
+			log.Printf("Failed to scan topic permission: %v\n", err)
+			return nil, err
+		}
+		permissions = append(permissions, p)
+	}
+	return permissions, nil
+}
+
+func DeleteTopicPermission(username, vhost, exchange string) error {
+	OpenDB()
+	defer CloseDB()
+	_, err := db.Exec("DELETE FROM topic_permissions WHERE username = ? AND vhost = ? AND exchange = ?", username, vhost, exchange)
+	if err != nil {
+		log.Printf("Failed to delete topic permission: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteVHostPermissions removes the permissions and topic permissions of
+// every user in the vhost
+func DeleteVHostPermissions(vhost string) error {
+	OpenDB()
+	defer CloseDB()
+	_, err := db.Exec("DELETE FROM user_permissions WHERE vhost = ?", vhost)
+	if err != nil {
+		log.Printf("Failed to delete vhost permissions: %v\n", err)
+		return err
+	}
+	_, err = db.Exec("DELETE FROM topic_permissions WHERE vhost = ?", vhost)
+	if err != nil {
+		log.Printf("Failed to delete vhost topic permissions: %v\n", err)
+		return err
+	}
+	return nil
+}
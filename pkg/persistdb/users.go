@@ -65,6 +65,46 @@ func GetUserByUsername(username string) (User, error) {
 	return user, nil
 }
 
+// GetUsersWithPasswords lists the users along with their password hashes,
+// for the definitions export
+func GetUsersWithPasswords() ([]User, error) {
+	OpenDB()
+	defer CloseDB()
+	rows, err := db.Query("SELECT id, username, password, role_id FROM users ORDER BY username")
+	if err != nil {
+		log.Printf("Failed to query users: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.ID, &user.Username, &user.Password, &user.RoleID)
+		if err != nil {
+			log.Printf("Failed to scan user: %v\n", err)
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// SaveUserWithHash adds the user, or updates its password hash and role if
+// it exists. The hash is stored as is.
+func SaveUserWithHash(username, passwordHash string, roleID int) error {
+	OpenDB()
+	defer CloseDB()
+	_, err := db.Exec(`INSERT INTO users (username, password, role_id) VALUES (?, ?, ?)
+		ON CONFLICT(username) DO UPDATE SET password = excluded.password, role_id = excluded.role_id`,
+		username, passwordHash, roleID)
+	if err != nil {
+		log.Printf("Failed to save user: %v\n", err)
+		return err
+	}
+	return nil
+}
+
 func GenerateJWTToken(user UserListDTO) (string, error) {
 	// convert user to json
 	jsonUser, err := json.Marshal(user)
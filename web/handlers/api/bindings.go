@@ -38,7 +38,7 @@ func BindQueue(c *fiber.Ctx, ch *amqp091.Channel) error {
 		request.RoutingKey,
 		request.ExchangeName,
 		false, // noWait
-		nil,   // arguments
+		amqp091.Table(broker.JSONArguments(request.Arguments)),
 	)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -85,7 +85,7 @@ func ListBindings(c *fiber.Ctx, b *broker.Broker) error {
 
 // DeleteBinding godoc
 // @Summary Delete a binding
-// @Description Delete a binding from an exchange to a queue
+// @Description Delete a binding from an exchange to a queue. The arguments must match the ones of the binding.
 // @Tags bindings
 // @Accept json
 // @Produce json
@@ -94,29 +94,26 @@ func ListBindings(c *fiber.Ctx, b *broker.Broker) error {
 // @Failure 400 {object} fiber.Map
 // @Failure 500 {object} fiber.Map
 // @Router /api/bindings [delete]
-func DeleteBinding(c *fiber.Ctx) error {
-	// var request models.DeleteBindingRequest
-	// if err := c.BodyParser(&request); err != nil {
-	// 	return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
-	// }
-	// command := fmt.Sprintf("DELETE_BINDING %s %s %s",
-	// 	request.ExchangeName,
-	// 	request.QueueName,
-	// 	request.RoutingKey)
-	// response, err := utils.SendCommand(command)
-	// if err != nil {
-	// 	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
-	// }
-
-	// var commandResponse api.CommandResponse
-	// if err := json.Unmarshal([]byte(response), &commandResponse); err != nil {
-	// 	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "failed to parse response"})
-	// }
+func DeleteBinding(c *fiber.Ctx, ch *amqp091.Channel) error {
+	var request models.DeleteBindingRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 
-	// if commandResponse.Status == "ERROR" {
-	// 	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": commandResponse.Message})
-	// } else {
-	// 	return c.Status(http.StatusOK).JSON(fiber.Map{"message": commandResponse.Message})
-	// }
-	return nil // just to make the function compile
+	err := ch.QueueUnbind(
+		request.QueueName,
+		request.RoutingKey,
+		request.ExchangeName,
+		amqp091.Table(broker.JSONArguments(request.Arguments)),
+	)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Binding deleted",
+	})
 }
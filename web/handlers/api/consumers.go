@@ -0,0 +1,27 @@
+package api
+
+import (
+	"github.com/andrelcunha/ottermq/internal/core/broker"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ListConsumers godoc
+// @Summary List all consumers
+// @Description Get a list of all consumers with their prefetch count
+// @Tags consumers
+// @Accept json
+// @Produce json
+// @Success 200 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/consumers [get]
+func ListConsumers(c *fiber.Ctx, b *broker.Broker) error {
+	consumers := broker.ListConsumers(b)
+	if consumers == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to list consumers",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"consumers": consumers,
+	})
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"github.com/andrelcunha/ottermq/internal/core/broker"
+	"github.com/andrelcunha/ottermq/pkg/common"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ExportDefinitions godoc
+// @Summary Export the broker definitions
+// @Description Export vhosts, users with their password hashes, permissions, durable exchanges, queues and bindings, and policies as one JSON document
+// @Tags definitions
+// @Accept json
+// @Produce json
+// @Success 200 {object} common.Definitions
+// @Failure 500 {object} fiber.Map
+// @Security ApiKeyAuth
+// @Router /api/definitions [get]
+func ExportDefinitions(c *fiber.Ctx, b *broker.Broker) error {
+	defs, err := b.ExportDefinitions()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(defs)
+}
+
+// ImportDefinitions godoc
+// @Summary Import broker definitions
+// @Description Apply a definitions document. Existing resources are kept, so importing the same document twice changes nothing.
+// @Tags definitions
+// @Accept json
+// @Produce json
+// @Param definitions body common.Definitions true "Definitions to import"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Security ApiKeyAuth
+// @Router /api/definitions [post]
+func ImportDefinitions(c *fiber.Ctx, b *broker.Broker) error {
+	var defs common.Definitions
+	if err := c.BodyParser(&defs); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := b.ImportDefinitions(&defs); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Definitions imported successfully",
+	})
+}
@@ -60,7 +60,7 @@ func CreateQueue(c *fiber.Ctx, ch *amqp091.Channel) error {
 		request.QueueName,
 		false, // durable
 		false, // delete when unused
-		true,  // exclusive
+		false, // exclusive
 		false, // no-wait
 		nil,   // arguments
 	)
@@ -86,39 +86,25 @@ func CreateQueue(c *fiber.Ctx, ch *amqp091.Channel) error {
 // @Failure 400 {object} fiber.Map
 // @Failure 500 {object} fiber.Map
 // @Router /api/queues/{queue} [delete]
-func DeleteQueue(c *fiber.Ctx) error {
-	// queueName := c.Params("queue")
-	// if queueName == "" {
-	// 	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-	// 		"error": "queue name is required",
-	// 	})
-	// }
-
-	// command := fmt.Sprintf("DELETE_QUEUE %s", queueName)
-	// response, err := utils.SendCommand(command)
-	// if err != nil {
-	// 	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-	// 		"error": err.Error(),
-	// 	})
-	// }
+func DeleteQueue(c *fiber.Ctx, ch *amqp091.Channel) error {
+	queueName := c.Params("queue")
+	if queueName == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "queue name is required",
+		})
+	}
 
-	// var commandResponse api.CommandResponse
-	// if err := json.Unmarshal([]byte(response), &commandResponse); err != nil {
-	// 	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-	// 		"error": "failed to parse response",
-	// 	})
-	// }
+	messageCount, err := ch.QueueDelete(queueName, false, false, false)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 
-	// if commandResponse.Status == "ERROR" {
-	// 	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-	// 		"error": commandResponse.Message,
-	// 	})
-	// } else {
-	// 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-	// 		"message": commandResponse.Message,
-	// 	})
-	// }
-	return nil // just to make it compile
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "Queue deleted successfully",
+		"messages": messageCount,
+	})
 }
 
 // GetMessage godoc
@@ -151,6 +137,11 @@ func GetMessage(c *fiber.Ctx, ch *amqp091.Channel) error {
 			"error": "no messages available",
 		})
 	}
+	if err := msg.Ack(false); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 	fmt.Println("Message received: ", string(msg.Body))
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"data": string(msg.Body),
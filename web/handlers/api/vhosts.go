@@ -0,0 +1,86 @@
+package api
+
+import (
+	"net/url"
+
+	"github.com/andrelcunha/ottermq/internal/core/broker"
+	"github.com/andrelcunha/ottermq/web/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ListVHosts godoc
+// @Summary List all virtual hosts
+// @Description Get a list of all virtual hosts
+// @Tags vhosts
+// @Accept json
+// @Produce json
+// @Success 200 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Router /api/vhosts [get]
+func ListVHosts(c *fiber.Ctx, b *broker.Broker) error {
+	vhosts := broker.ListVHosts(b)
+	if vhosts == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to list vhosts",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"vhosts": vhosts,
+	})
+}
+
+// CreateVHost godoc
+// @Summary Create a new virtual host
+// @Description Create a new virtual host with the specified name
+// @Tags vhosts
+// @Accept json
+// @Produce json
+// @Param vhost body models.CreateVHostRequest true "Virtual host to create"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Security ApiKeyAuth
+// @Router /api/vhosts [post]
+func CreateVHost(c *fiber.Ctx, b *broker.Broker) error {
+	var request models.CreateVHostRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if _, err := b.CreateVHost(request.Name); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "VHost created successfully",
+	})
+}
+
+// DeleteVHost godoc
+// @Summary Delete a virtual host
+// @Description Delete a virtual host, closing its connections and dropping its exchanges and queues
+// @Tags vhosts
+// @Accept json
+// @Produce json
+// @Param vhost path string true "VHost name (URL encoded)"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Security ApiKeyAuth
+// @Router /api/vhosts/{vhost} [delete]
+func DeleteVHost(c *fiber.Ctx, b *broker.Broker) error {
+	name, err := url.PathUnescape(c.Params("vhost"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := b.DeleteVHost(name); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "VHost deleted successfully",
+	})
+}
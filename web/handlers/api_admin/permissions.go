@@ -0,0 +1,185 @@
+package api_admin
+
+import (
+	"net/url"
+
+	"github.com/andrelcunha/ottermq/internal/core/broker"
+	"github.com/andrelcunha/ottermq/pkg/persistdb"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetPermissions godoc
+// @Summary Get all user permissions
+// @Description Get the configure, write and read permissions of every user in every vhost
+// @Tags permissions
+// @Accept json
+// @Produce json
+// @Success 200 {object} []persistdb.UserPermission
+// @Failure 500 {object} fiber.Map
+// @Security ApiKeyAuth
+// @Router /api/admin/permissions [get]
+func GetPermissions(c *fiber.Ctx) error {
+	permissions, err := persistdb.GetUserPermissions()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(permissions)
+}
+
+// SetPermissions godoc
+// @Summary Set the permissions of a user in a vhost
+// @Description Set the configure, write and read regular expressions of a user in a vhost. An empty expression grants nothing.
+// @Tags permissions
+// @Accept json
+// @Produce json
+// @Param vhost path string true "VHost name (URL encoded)"
+// @Param user path string true "Username"
+// @Param permissions body persistdb.UserPermission true "Permissions (username and vhost are taken from the path)"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Security ApiKeyAuth
+// @Router /api/admin/permissions/{vhost}/{user} [put]
+func SetPermissions(c *fiber.Ctx, b *broker.Broker) error {
+	vhostName, err := url.PathUnescape(c.Params("vhost"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	var request persistdb.UserPermission
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	err = b.SetUserPermissions(c.Params("user"), vhostName, request.Configure, request.Write, request.Read)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Permissions set successfully",
+	})
+}
+
+// DeletePermissions godoc
+// @Summary Delete the permissions of a user in a vhost
+// @Description Revoke the configure, write and read permissions of a user in a vhost
+// @Tags permissions
+// @Accept json
+// @Produce json
+// @Param vhost path string true "VHost name (URL encoded)"
+// @Param user path string true "Username"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Security ApiKeyAuth
+// @Router /api/admin/permissions/{vhost}/{user} [delete]
+func DeletePermissions(c *fiber.Ctx, b *broker.Broker) error {
+	vhostName, err := url.PathUnescape(c.Params("vhost"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := b.ClearUserPermissions(c.Params("user"), vhostName); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Permissions deleted successfully",
+	})
+}
+
+// GetTopicPermissions godoc
+// @Summary Get all topic permissions
+// @Description Get the write and read routing key expressions of every user on every topic exchange
+// @Tags permissions
+// @Accept json
+// @Produce json
+// @Success 200 {object} []persistdb.TopicPermission
+// @Failure 500 {object} fiber.Map
+// @Security ApiKeyAuth
+// @Router /api/admin/topic-permissions [get]
+func GetTopicPermissions(c *fiber.Ctx) error {
+	permissions, err := persistdb.GetTopicPermissions()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(permissions)
+}
+
+// SetTopicPermissions godoc
+// @Summary Set the topic permissions of a user on an exchange
+// @Description Set the regular expressions matching the routing keys a user may publish (write) or bind (read) with on a topic exchange
+// @Tags permissions
+// @Accept json
+// @Produce json
+// @Param vhost path string true "VHost name (URL encoded)"
+// @Param user path string true "Username"
+// @Param exchange path string true "Exchange name"
+// @Param permissions body persistdb.TopicPermission true "Topic permissions (username, vhost and exchange are taken from the path)"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Security ApiKeyAuth
+// @Router /api/admin/topic-permissions/{vhost}/{user}/{exchange} [put]
+func SetTopicPermissions(c *fiber.Ctx, b *broker.Broker) error {
+	vhostName, err := url.PathUnescape(c.Params("vhost"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	var request persistdb.TopicPermission
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	err = b.SetTopicPermissions(c.Params("user"), vhostName, c.Params("exchange"), request.Write, request.Read)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Topic permissions set successfully",
+	})
+}
+
+// DeleteTopicPermissions godoc
+// @Summary Delete the topic permissions of a user on an exchange
+// @Description Lift the routing key restrictions of a user on a topic exchange
+// @Tags permissions
+// @Accept json
+// @Produce json
+// @Param vhost path string true "VHost name (URL encoded)"
+// @Param user path string true "Username"
+// @Param exchange path string true "Exchange name"
+// @Success 200 {object} fiber.Map
+// @Failure 400 {object} fiber.Map
+// @Failure 500 {object} fiber.Map
+// @Security ApiKeyAuth
+// @Router /api/admin/topic-permissions/{vhost}/{user}/{exchange} [delete]
+func DeleteTopicPermissions(c *fiber.Ctx, b *broker.Broker) error {
+	vhostName, err := url.PathUnescape(c.Params("vhost"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := b.ClearTopicPermissions(c.Params("user"), vhostName, c.Params("exchange")); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Topic permissions deleted successfully",
+	})
+}
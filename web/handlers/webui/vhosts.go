@@ -0,0 +1,14 @@
+package webui
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+func ListVHosts(c *fiber.Ctx) error {
+	// get username from cookie
+	username := c.Cookies("username")
+	return c.Render("vhosts", fiber.Map{
+		"Title":    "Virtual Hosts",
+		"Username": username,
+	})
+}
@@ -4,10 +4,14 @@ type BindQueueRequest struct {
 	ExchangeName string `json:"exchange_name"`
 	QueueName    string `json:"queue_name"`
 	RoutingKey   string `json:"routing_key"`
+	// binding arguments, such as the headers matched by a headers exchange
+	Arguments map[string]interface{} `json:"arguments"`
 }
 
 type DeleteBindingRequest struct {
 	ExchangeName string `json:"exchange_name"`
 	QueueName    string `json:"queue_name"`
 	RoutingKey   string `json:"routing_key"`
+	// binding arguments, such as the headers matched by a headers exchange
+	Arguments map[string]interface{} `json:"arguments"`
 }
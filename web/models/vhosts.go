@@ -0,0 +1,5 @@
+package models
+
+type CreateVHostRequest struct {
+	Name string `json:"name"`
+}
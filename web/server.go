@@ -22,7 +22,6 @@ type WebServer struct {
 	config            *Config
 	Broker            *broker.Broker
 	Client            *amqp091.Connection
-	Channel           *amqp091.Channel
 }
 
 type Config struct {
@@ -43,22 +42,31 @@ func NewWebServer(config *Config, broker *broker.Broker, conn *amqp091.Connectio
 	// if err != nil {
 	// 	return nil, err
 	// }
-	ch, err := conn.Channel()
-	if err != nil {
-		return nil, err
-	}
-
 	return &WebServer{
 		// brokerAddr: brokerAddr,
 		// conn:              conn,
 		// heartbeatInterval: time.Duration(config.HeartbeatInterval) * time.Second,
-		config:  config,
-		Broker:  broker,
-		Client:  conn,
-		Channel: ch,
+		config: config,
+		Broker: broker,
+		Client: conn,
 	}, nil
 }
 
+// withChannel runs the handler on a channel of its own. An error closes the
+// channel on the broker side, so a shared one would fail every later call.
+func (ws *WebServer) withChannel(handler func(*fiber.Ctx, *amqp091.Channel) error) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ch, err := ws.Client.Channel()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		defer ch.Close()
+		return handler(c, ch)
+	}
+}
+
 func (ws *WebServer) SetupApp(logFile *os.File) *fiber.App {
 	// connectionSting := fmt.Sprintf("amqp://%s:%s@%s:%s/", ws.config.Username, ws.config.Password, ws.config.BrokerHost, ws.config.BrokerPort)
 	// conn, err := getBrokerClient(connectionSting)
@@ -89,38 +97,45 @@ func (ws *WebServer) AddApi(app *fiber.App) {
 	apiGrp.Get("/queues", func(c *fiber.Ctx) error {
 		return api.ListQueues(c, ws.Broker)
 	})
-	apiGrp.Post("/queues", func(c *fiber.Ctx) error {
-		return api.CreateQueue(c, ws.Channel)
-	})
-	apiGrp.Delete("/queues/:queue", api.DeleteQueue)
-	apiGrp.Post("/queues/:queue/consume", func(c *fiber.Ctx) error {
-		return api.GetMessage(c, ws.Channel)
-	})
+	apiGrp.Post("/queues", ws.withChannel(api.CreateQueue))
+	apiGrp.Delete("/queues/:queue", ws.withChannel(api.DeleteQueue))
+	apiGrp.Post("/queues/:queue/consume", ws.withChannel(api.GetMessage))
 	apiGrp.Post("/messages/:id/ack", api.AckMessage)
-	apiGrp.Post("/messages", func(c *fiber.Ctx) error {
-		return api.PublishMessage(c, ws.Channel)
-	})
+	apiGrp.Post("/messages", ws.withChannel(api.PublishMessage))
 
 	apiGrp.Get("/exchanges", func(c *fiber.Ctx) error {
 		return api.ListExchanges(c, ws.Broker)
 	})
-	apiGrp.Post("/exchanges", func(c *fiber.Ctx) error {
-		return api.CreateExchange(c, ws.Channel)
-	})
+	apiGrp.Post("/exchanges", ws.withChannel(api.CreateExchange))
 
-	apiGrp.Delete("/exchanges/:exchange", func(c *fiber.Ctx) error {
-		return api.DeleteExchange(c, ws.Channel)
-	})
+	apiGrp.Delete("/exchanges/:exchange", ws.withChannel(api.DeleteExchange))
 	apiGrp.Get("/bindings/:exchange", func(c *fiber.Ctx) error {
 		return api.ListBindings(c, ws.Broker)
 	})
-	apiGrp.Post("/bindings", func(c *fiber.Ctx) error {
-		return api.BindQueue(c, ws.Channel)
-	})
-	apiGrp.Delete("/bindings", api.DeleteBinding)
+	apiGrp.Post("/bindings", ws.withChannel(api.BindQueue))
+	apiGrp.Delete("/bindings", ws.withChannel(api.DeleteBinding))
 	apiGrp.Get("/connections", func(c *fiber.Ctx) error {
 		return api.ListConnections(c, ws.Broker)
 	})
+	apiGrp.Get("/consumers", func(c *fiber.Ctx) error {
+		return api.ListConsumers(c, ws.Broker)
+	})
+	apiGrp.Get("/vhosts", func(c *fiber.Ctx) error {
+		return api.ListVHosts(c, ws.Broker)
+	})
+	apiGrp.Post("/vhosts", middleware.JwtMiddleware(ws.config.JwtKey), func(c *fiber.Ctx) error {
+		return api.CreateVHost(c, ws.Broker)
+	})
+	apiGrp.Delete("/vhosts/:vhost", middleware.JwtMiddleware(ws.config.JwtKey), func(c *fiber.Ctx) error {
+		return api.DeleteVHost(c, ws.Broker)
+	})
+	// the definitions hold the password hashes
+	apiGrp.Get("/definitions", middleware.JwtMiddleware(ws.config.JwtKey), func(c *fiber.Ctx) error {
+		return api.ExportDefinitions(c, ws.Broker)
+	})
+	apiGrp.Post("/definitions", middleware.JwtMiddleware(ws.config.JwtKey), func(c *fiber.Ctx) error {
+		return api.ImportDefinitions(c, ws.Broker)
+	})
 	apiGrp.Post("/login", api_admin.Login)
 }
 
@@ -144,6 +159,7 @@ func (ws *WebServer) AddUI(app *fiber.App) {
 	webGrp.Get("/connections", webui.ListConnections)
 	webGrp.Get("/exchanges", webui.ListExchanges)
 	webGrp.Get("/queues", webui.ListQueues)
+	webGrp.Get("/vhosts", webui.ListVHosts)
 	// webGrp.Get("/settings", webui.Settings)
 }
 
@@ -153,4 +169,18 @@ func (ws *WebServer) AddAdminApi(app *fiber.App) {
 	apiAdminGrp.Use(middleware.JwtMiddleware(ws.config.JwtKey))
 	apiAdminGrp.Get("/users", api_admin.GetUsers)
 	apiAdminGrp.Post("/users", api_admin.AddUser)
+	apiAdminGrp.Get("/permissions", api_admin.GetPermissions)
+	apiAdminGrp.Put("/permissions/:vhost/:user", func(c *fiber.Ctx) error {
+		return api_admin.SetPermissions(c, ws.Broker)
+	})
+	apiAdminGrp.Delete("/permissions/:vhost/:user", func(c *fiber.Ctx) error {
+		return api_admin.DeletePermissions(c, ws.Broker)
+	})
+	apiAdminGrp.Get("/topic-permissions", api_admin.GetTopicPermissions)
+	apiAdminGrp.Put("/topic-permissions/:vhost/:user/:exchange", func(c *fiber.Ctx) error {
+		return api_admin.SetTopicPermissions(c, ws.Broker)
+	})
+	apiAdminGrp.Delete("/topic-permissions/:vhost/:user/:exchange", func(c *fiber.Ctx) error {
+		return api_admin.DeleteTopicPermissions(c, ws.Broker)
+	})
 }
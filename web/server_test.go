@@ -0,0 +1,173 @@
+package web
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrelcunha/ottermq/config"
+	"github.com/andrelcunha/ottermq/internal/core/broker"
+	"github.com/andrelcunha/ottermq/pkg/persistdb"
+	"github.com/gofiber/fiber/v2"
+)
+
+// newTestServer starts a broker on a free port, with a data directory of
+// its own, and the API of a web server connected to it
+func newTestServer(t *testing.T) (*WebServer, *fiber.App) {
+	log.SetOutput(io.Discard)
+	dir := t.TempDir()
+	persistdb.SetDbPath(filepath.Join(dir, "ottermq.db"))
+	persistdb.InitDB()
+	persistdb.AddDefaultRoles()
+	persistdb.AddDefaultPermissions()
+	if err := persistdb.AddUser(persistdb.UserCreateDTO{Username: "guest", Password: "guest", RoleID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	b := broker.NewBroker(&config.Config{
+		Port:                 port,
+		Host:                 "localhost",
+		Username:             "guest",
+		Password:             "guest",
+		HeartbeatIntervalMax: 10,
+		ChannelMax:           5,
+		FrameMax:             131072,
+		DataDir:              dir,
+	})
+	b.VHosts["/"].Users["guest"] = &persistdb.User{Username: "guest", RoleID: 1}
+	go b.Start()
+	t.Cleanup(b.Shutdown)
+	waitForListener(t, net.JoinHostPort("localhost", port))
+
+	webConfig := &Config{
+		BrokerHost: "localhost",
+		BrokerPort: port,
+		Username:   "guest",
+		Password:   "guest",
+		JwtKey:     "secret",
+	}
+	conn, err := GetBrokerClient(webConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	ws, err := NewWebServer(webConfig, b, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	ws.AddApi(app)
+	return ws, app
+}
+
+// waitForListener waits until the broker accepts connections, so connecting
+// the client does not go through its retry delay
+func waitForListener(t *testing.T, addr string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("broker not listening on %s: %v", addr, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func request(t *testing.T, app *fiber.App, method, path, body string, headers ...string) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+// TestAPIRecoversFromChannelErrors deletes a queue that does not exist,
+// which the broker answers by closing the channel, then makes valid calls
+func TestAPIRecoversFromChannelErrors(t *testing.T) {
+	_, app := newTestServer(t)
+	if status := request(t, app, "DELETE", "/api/queues/missing", ""); status != fiber.StatusInternalServerError {
+		t.Fatalf("deleting a missing queue: status %d", status)
+	}
+	if status := request(t, app, "POST", "/api/queues", `{"queue_name":"q"}`); status != fiber.StatusOK {
+		t.Fatalf("creating a queue after an error: status %d", status)
+	}
+	if status := request(t, app, "DELETE", "/api/queues/q", ""); status != fiber.StatusOK {
+		t.Fatalf("deleting the queue: status %d", status)
+	}
+}
+
+// TestAPIDeletesHeadersBinding binds a queue to a headers exchange and
+// removes the binding through the API, which identifies it by its arguments
+func TestAPIDeletesHeadersBinding(t *testing.T) {
+	ws, app := newTestServer(t)
+	if status := request(t, app, "POST", "/api/exchanges", `{"exchange_name":"h","exchange_type":"headers"}`); status != fiber.StatusOK {
+		t.Fatalf("creating the exchange: status %d", status)
+	}
+	if status := request(t, app, "POST", "/api/queues", `{"queue_name":"q"}`); status != fiber.StatusOK {
+		t.Fatalf("creating the queue: status %d", status)
+	}
+	binding := `{"exchange_name":"h","queue_name":"q","arguments":{"x-match":"all","k":"v"}}`
+	if status := request(t, app, "POST", "/api/bindings", binding); status != fiber.StatusOK {
+		t.Fatalf("binding the queue: status %d", status)
+	}
+	if status := request(t, app, "DELETE", "/api/bindings", `{"exchange_name":"h","queue_name":"q"}`); status != fiber.StatusInternalServerError {
+		t.Fatalf("deleting a binding with other arguments: status %d", status)
+	}
+	if status := request(t, app, "DELETE", "/api/bindings", binding); status != fiber.StatusOK {
+		t.Fatalf("deleting the binding: status %d", status)
+	}
+	if bindings := ws.Broker.VHosts["/"].Exchanges["h"].HeaderBindings; len(bindings) != 0 {
+		t.Fatalf("%d bindings left", len(bindings))
+	}
+}
+
+// TestAPIVHostChangesRequireToken creates and deletes a vhost, which is only
+// accepted with a token
+func TestAPIVHostChangesRequireToken(t *testing.T) {
+	ws, app := newTestServer(t)
+	token, err := persistdb.GenerateJWTToken(persistdb.UserListDTO{ID: 1, Username: "guest", Role: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := []string{"Authorization", "Bearer " + token}
+
+	if status := request(t, app, "POST", "/api/vhosts", `{"name":"v"}`); status != fiber.StatusBadRequest {
+		t.Fatalf("creating a vhost without a token: status %d", status)
+	}
+	if ws.Broker.GetVHostFromName("v") != nil {
+		t.Fatal("vhost created without a token")
+	}
+	if status := request(t, app, "POST", "/api/vhosts", `{"name":"v"}`, auth...); status != fiber.StatusOK {
+		t.Fatalf("creating a vhost: status %d", status)
+	}
+	if status := request(t, app, "DELETE", "/api/vhosts/v", ""); status != fiber.StatusBadRequest {
+		t.Fatalf("deleting a vhost without a token: status %d", status)
+	}
+	if ws.Broker.GetVHostFromName("v") == nil {
+		t.Fatal("vhost deleted without a token")
+	}
+	if status := request(t, app, "DELETE", "/api/vhosts/v", "", auth...); status != fiber.StatusOK {
+		t.Fatalf("deleting a vhost: status %d", status)
+	}
+}
@@ -0,0 +1,47 @@
+document.addEventListener('DOMContentLoaded', function() {
+    fetchVHosts();
+
+    document.getElementById('add-vhost-form').addEventListener('submit', function(e) {
+        e.preventDefault();
+        const vhostName = document.getElementById('vhost-name').value;
+        addVHost(vhostName);
+    });
+});
+
+async function fetchVHosts() {
+    const response = await fetch('/api/vhosts');
+    const data = await response.json();
+    const vhostsList = document.getElementById('vhosts-list');
+    vhostsList.innerHTML = '';
+    for (const vhost of data.vhosts) {
+        const row = document.createElement('tr');
+        const deleteButton = vhost.name === '/' ? '' :
+            `<button class='delete-button' onclick="deleteVHost('${vhost.name}')">Delete</button>`;
+        row.innerHTML = `
+            <td><b>${vhost.name}</b></td>
+            <td>${vhost.exchanges}</td>
+            <td>${vhost.queues}</td>
+            <td>${vhost.connections}</td>
+            <td>${deleteButton}</td>
+        `;
+        vhostsList.appendChild(row);
+    };
+}
+
+async function addVHost(name) {
+    const vhost = {
+        name: name
+    }
+    const response = await fetch('/api/vhosts', {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify(vhost)
+    });
+    if (response.ok) fetchVHosts();
+}
+
+async function deleteVHost(name) {
+    if (!confirm(`Delete virtual host '${name}'? Its connections will be closed and its queues dropped.`)) return;
+    const response = await fetch(`/api/vhosts/${encodeURIComponent(name)}`, { method: 'DELETE' });
+    if (response.ok) fetchVHosts();
+}